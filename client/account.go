@@ -0,0 +1,107 @@
+package shellgame
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"os"
+)
+
+type accountRequest struct {
+	Name      string `json:"name"`
+	Password  string `json:"password,omitempty"`
+	PublicKey string `json:"public_key,omitempty"`
+	Challenge string `json:"challenge,omitempty"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// シェルゲーサーバにアカウントを登録し、そのままログインする。
+// passwordとsignerの少なくとも一方を指定する。
+func RegisterAccount(name string, password string, signer ssh.Signer) error {
+	body := &accountRequest{Name: name, Password: password}
+	if signer != nil {
+		body.PublicKey = string(ssh.MarshalAuthorizedKey(signer.PublicKey()))
+	}
+	return postAccount(accountEndpoint.String(), body)
+}
+
+// パスワードでログインする。
+func Login(name string, password string) error {
+	return postAccount(loginEndpoint.String(), &accountRequest{Name: name, Password: password})
+}
+
+// シェルゲーサーバが発行したチャレンジにsignerで署名してログインする。
+func LoginWithKey(name string, signer ssh.Signer) error {
+	p, err := json.Marshal(&accountRequest{Name: name})
+	if err != nil {
+		return err
+	}
+	resp, err := doWithSession("POST", keyLoginEndpoint.String(), bytes.NewBuffer(p))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("%s", bytes.TrimSpace(body))
+	}
+	res := &struct {
+		Challenge string `json:"challenge"`
+	}{}
+	if err := json.Unmarshal(body, res); err != nil {
+		return err
+	}
+	challenge, err := base64.StdEncoding.DecodeString(res.Challenge)
+	if err != nil {
+		return err
+	}
+	sig, err := signer.Sign(rand.Reader, challenge)
+	if err != nil {
+		return err
+	}
+	return postAccount(loginEndpoint.String(), &accountRequest{
+		Name:      name,
+		Challenge: res.Challenge,
+		Signature: base64.StdEncoding.EncodeToString(ssh.Marshal(sig)),
+	})
+}
+
+// SHELLGAME_SSH_KEYに指定された秘密鍵を読み込む。指定がない場合はnilを返す。
+// パスフレーズ付きの秘密鍵には対応していない。
+func LoadSigner() (ssh.Signer, error) {
+	path := os.Getenv("SHELLGAME_SSH_KEY")
+	if path == "" {
+		return nil, nil
+	}
+	key, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.ParsePrivateKey(key)
+}
+
+// 登録・ログインに成功した場合は、サーバが返したプロフィールを自分のプロフィールとする。
+func postAccount(url string, body *accountRequest) error {
+	p, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := doWithSession("POST", url, bytes.NewBuffer(p))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	profile, err := readProfile(resp)
+	if err != nil {
+		return err
+	}
+	SetMyProfile(profile)
+	return nil
+}
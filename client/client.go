@@ -4,9 +4,9 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/taise-hub/shellgame-cli/common"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -21,27 +21,64 @@ const (
 var (
 	baseEndpoint     = &url.URL{Scheme: "http", Host: HOST, Path: "/"}
 	profileEndpoint  = &url.URL{Scheme: "http", Host: HOST, Path: "/profiles"}
+	accountEndpoint  = &url.URL{Scheme: "http", Host: HOST, Path: "/accounts"}
+	loginEndpoint    = &url.URL{Scheme: "http", Host: HOST, Path: "/login"}
+	keyLoginEndpoint = &url.URL{Scheme: "http", Host: HOST, Path: "/login/challenge"}
+	roomsEndpoint    = &url.URL{Scheme: "http", Host: HOST, Path: "/rooms"}
+	privateEndpoint  = &url.URL{Scheme: "http", Host: HOST, Path: "/private-rooms"}
 	playersEndpoint  = &url.URL{Scheme: "http", Host: HOST, Path: "/players"}
 	shellEndpoint    = &url.URL{Scheme: "ws", Host: HOST, Path: "/shell"}
+	battleEndpoint   = &url.URL{Scheme: "http", Host: HOST, Path: "/battle"}
 	matchingEndpoint = &url.URL{Scheme: "ws", Host: HOST, Path: "/waitmatch"}
+	channelEndpoint  = &url.URL{Scheme: "ws", Host: HOST, Path: "/battle/channel"}
 	muRead           sync.Mutex
 	muWrite          sync.Mutex
 )
 
+// msgをEnvelopeに詰めて送信する。
 func WriteConn(conn *websocket.Conn, msg common.Message) error {
+	env, err := common.Wrap(msg)
+	if err != nil {
+		return err
+	}
 	defer muWrite.Unlock()
 	muWrite.Lock()
-	return conn.WriteJSON(msg)
+	return conn.WriteJSON(env)
 }
 
+// Envelopeを受信し、msgに取り出す。
 func ReadConn(conn *websocket.Conn, msg common.Message) error {
-	defer muRead.Unlock()
+	env := &common.Envelope{}
 	muRead.Lock()
-	return conn.ReadJSON(msg)
+	err := conn.ReadJSON(env)
+	muRead.Unlock()
+	if err != nil {
+		return err
+	}
+	return env.Unwrap(msg)
+}
+
+// このクライアントのプロトコルのバージョンをサブプロトコルとして提示してWebSocketで接続する。
+// サーバがハンドシェイクを拒否した場合は、応答の本文に含まれる理由をエラーとして返す。
+func dial(u string, header http.Header) (*websocket.Conn, error) {
+	dialer := *websocket.DefaultDialer
+	dialer.Subprotocols = []string{common.Subprotocol(common.PROTOCOL_VERSION)}
+	wsconn, resp, err := dialer.Dial(u, header)
+	if err == websocket.ErrBadHandshake && resp != nil {
+		defer resp.Body.Close()
+		// 拒否の理由はバージョンに関わらず読めるように、Unwrap()を使わずに取り出す。
+		env := &common.Envelope{}
+		rejection := &common.Rejection{}
+		if json.NewDecoder(resp.Body).Decode(env) == nil && json.Unmarshal(env.Payload, rejection) == nil && env.Type == common.REJECT {
+			return nil, rejection
+		}
+	}
+	return wsconn, err
 }
 
 // シェルゲーサーバで稼働するコンテナにWebSocketを利用して接続する。
-func ConnectShell() (*websocket.Conn, error) {
+// チームメイトでコンテナを共有する対戦では、battleIDの対戦のチームのコンテナに接続する。
+func ConnectShell(battleID string) (*websocket.Conn, error) {
 	jar, err := getJar()
 	if err != nil {
 		return nil, err
@@ -51,17 +88,42 @@ func ConnectShell() (*websocket.Conn, error) {
 		header.Add("Cookie", fmt.Sprintf("%s=%s", cookie.Name, cookie.Value))
 	}
 
-	wsconn, _, err := websocket.DefaultDialer.Dial(shellEndpoint.String(), header)
+	u := *shellEndpoint
+	if battleID != "" {
+		u.RawQuery = url.Values{"battle": {battleID}}.Encode()
+	}
+	// シェルの入出力はWebSocketのフレームを介さずに送受信するため、Pongを受け取れない。
+	// 読み込みの期限を設けると操作していない間に切断されるため、設けずに切断はTCPのキープアライブで検知する。
+	return dial(u.String(), header)
+}
+
+// シェルゲーサーバで稼働するマッチングルームroomにWebSocketを利用して接続する。
+// 招待コードcodeを指定した場合はプライベートルームに接続する。
+func ConnectMatchingRoom(room string, code string) (*websocket.Conn, error) {
+	jar, err := getJar()
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	for _, cookie := range jar.Cookies(baseEndpoint) {
+		header.Add("Cookie", fmt.Sprintf("%s", cookie))
+	}
+
+	wsconn, err := dial(withRoom(matchingEndpoint, room, code).String(), header)
 	if err != nil {
 		return nil, err
 	}
 	wsconn.SetReadDeadline(time.Now().Add(60 * time.Second))
-	wsconn.SetPongHandler(func(string) error { wsconn.SetReadDeadline(time.Now().Add(60 * time.Second)); return nil })
+	wsconn.SetPongHandler(func(string) error {
+		wsconn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		wsconn.SetWriteDeadline(time.Now().Add(20 * time.Second))
+		return nil
+	})
 	return wsconn, nil
 }
 
-// シェルゲーサーバで稼働するマッチングルームにWebSocketを利用して接続する。
-func ConnectMatchingRoom() (*websocket.Conn, error) {
+// battleIDの対戦の対戦チャンネルにWebSocketを利用して接続する。対戦相手とチャットやエモートをやり取りする。
+func ConnectBattleChannel(battleID string) (*websocket.Conn, error) {
 	jar, err := getJar()
 	if err != nil {
 		return nil, err
@@ -71,7 +133,9 @@ func ConnectMatchingRoom() (*websocket.Conn, error) {
 		header.Add("Cookie", fmt.Sprintf("%s", cookie))
 	}
 
-	wsconn, _, err := websocket.DefaultDialer.Dial(matchingEndpoint.String(), header)
+	u := *channelEndpoint
+	u.RawQuery = url.Values{"id": {battleID}}.Encode()
+	wsconn, err := dial(u.String(), header)
 	if err != nil {
 		return nil, err
 	}
@@ -85,11 +149,9 @@ func ConnectMatchingRoom() (*websocket.Conn, error) {
 }
 
 // シェルゲーサーバにプレイヤー名を登録する。
+// IDはシェルゲーサーバで発行されたものを利用する。
 func PostProfile(name string) error {
-	id := uuid.New()
-	profile := &common.Profile{ID: id.String(), Name: name}
-	SetMyProfile(profile)
-	p, err := json.Marshal(profile)
+	p, err := json.Marshal(&common.Profile{Name: name})
 	if err != nil {
 		return err
 	}
@@ -98,14 +160,11 @@ func PostProfile(name string) error {
 		return err
 	}
 	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	profile, err := readProfile(resp)
 	if err != nil {
 		return err
 	}
-
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("%s", body)
-	}
+	SetMyProfile(profile)
 	jar, err := getJar()
 	if err != nil {
 		return err
@@ -114,10 +173,161 @@ func PostProfile(name string) error {
 	return nil
 }
 
-// シェルゲーサーバから対戦待ちユーザを取得する
-func GetMatchingProfiles() ([]*common.Profile, error) {
+// シェルゲーサーバに登録しているプロフィールを取得する。
+func GetProfile() (*common.Profile, error) {
+	resp, err := doWithSession("GET", profileEndpoint.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return readProfile(resp)
+}
+
+// シェルゲーサーバに登録しているプレイヤー名を変更する。
+func PutProfile(name string) error {
+	p, err := json.Marshal(&common.Profile{Name: name})
+	if err != nil {
+		return err
+	}
+	resp, err := doWithSession("PUT", profileEndpoint.String(), bytes.NewBuffer(p))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	profile, err := readProfile(resp)
+	if err != nil {
+		return err
+	}
+	SetMyProfile(profile)
+	return nil
+}
+
+// シェルゲーサーバからプロフィールを削除し、ログアウトする。
+func DeleteProfile() error {
+	resp, err := doWithSession("DELETE", profileEndpoint.String(), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s", bytes.TrimSpace(body))
+	}
+	SetMyProfile(nil)
+	return nil
+}
+
+// ステータスコードが200以外の場合はレスポンスボディをエラーとして返す。
+func readProfile(resp *http.Response) (*common.Profile, error) {
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("%s", bytes.TrimSpace(body))
+	}
+	profile := &common.Profile{}
+	if err := json.Unmarshal(body, profile); err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+// セッションのクッキーを付与してリクエストを送信する。
+func doWithSession(method string, url string, body io.Reader) (*http.Response, error) {
+	jar, err := getJar()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	client := &http.Client{Jar: jar}
+	return client.Do(req)
+}
+
+// シェルゲーサーバで稼働するマッチングルームの一覧を取得する
+func GetRooms() ([]*common.Room, error) {
+	resp, err := http.Get(roomsEndpoint.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("%s", body)
+	}
+
+	var rooms []*common.Room
+	if err := json.Unmarshal(body, &rooms); err != nil {
+		return nil, err
+	}
+	return rooms, nil
+}
+
+// 対戦の参加者とチームごとの得点を取得する。対戦の参加者のみ取得できる。
+func GetBattle(id string) (*common.Battle, error) {
+	u := *battleEndpoint
+	u.RawQuery = url.Values{"id": {id}}.Encode()
+	resp, err := doWithSession("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("%s", bytes.TrimSpace(body))
+	}
+
+	battle := &common.Battle{}
+	if err := json.Unmarshal(body, battle); err != nil {
+		return nil, err
+	}
+	return battle, nil
+}
+
+// プライベートルームを作成し、招待コードを取得する。作成したプレイヤーがホストとなる。
+func CreatePrivateRoom(questionSet string) (*common.PrivateRoom, error) {
+	b, err := json.Marshal(&common.PrivateRoom{QuestionSet: questionSet})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := doWithSession("POST", privateEndpoint.String(), bytes.NewBuffer(b))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("%s", bytes.TrimSpace(body))
+	}
+	room := &common.PrivateRoom{}
+	if err := json.Unmarshal(body, room); err != nil {
+		return nil, err
+	}
+	return room, nil
+}
+
+// シェルゲーサーバのマッチングルームroom(codeを指定した場合はプライベートルーム)から対戦待ちユーザを取得する
+func GetMatchingPlayers(room string, code string) ([]*common.MatchingPlayer, error) {
 	client := &http.Client{ }
-	req, err := http.NewRequest("GET", playersEndpoint.String(), nil)
+	req, err := http.NewRequest("GET", withRoom(playersEndpoint, room, code).String(), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -137,9 +347,23 @@ func GetMatchingProfiles() ([]*common.Profile, error) {
 		return nil, err
 	}
 
-	var profiles []*common.Profile
-	if err := json.Unmarshal(body, &profiles); err != nil {
+	var players []*common.MatchingPlayer
+	if err := json.Unmarshal(body, &players); err != nil {
 		return nil, err
 	}
-	return profiles, nil
+	return players, nil
+}
+
+// エンドポイントにマッチングルームを指定するクエリを付与する。
+// プライベートルームはルーム名ではなく招待コードで指定する。
+func withRoom(endpoint *url.URL, room string, code string) *url.URL {
+	u := *endpoint
+	q := u.Query()
+	if code != "" {
+		q.Set("code", code)
+	} else {
+		q.Set("room", room)
+	}
+	u.RawQuery = q.Encode()
+	return &u
 }
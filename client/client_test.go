@@ -11,7 +11,6 @@ import (
 )
 
 func TestPostProfile(t *testing.T) {
-	//NOTE: APIのレスポンスの仕様が固ってないためとりあえずtext/plainを返す。
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		p := &common.Profile{}
 		err := json.NewDecoder(r.Body).Decode(p)
@@ -24,8 +23,10 @@ func TestPostProfile(t *testing.T) {
 			fmt.Fprintf(w, "20文字以内で入力してください。")
 			return
 		}
+		p.ID = "da3fc9dd-bff1-43ed-b360-91e4f4ee9db1"
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
 		w.WriteHeader(http.StatusOK)
-		fmt.Fprintf(w, "OK")
+		json.NewEncoder(w).Encode(p)
 		return
 	}))
 	defer ts.Close()
@@ -57,9 +58,13 @@ func TestPostProfile(t *testing.T) {
 			profileEndpoint = url
 			actual := PostProfile(tt.args.name)
 			if actual != nil {
-				if actual.Error() != tt.expectedErr.Error() {
+				if tt.expectedErr == nil || actual.Error() != tt.expectedErr.Error() {
 					t.Errorf("Expected: %v\n\t\t Actual: %v \n", tt.expectedErr, actual)
 				}
+				return
+			}
+			if GetMyProfile().ID == "" {
+				t.Errorf("Expected: server-issued ID\n\t\t Actual: %#v \n", GetMyProfile())
 			}
 		})
 	}
@@ -114,7 +119,7 @@ func TestGetPlayers(t *testing.T) {
 			url, _ := url.Parse(ts.URL)
 			playersEndpoint = url
 
-			actual, err := GetMatchingPlayers()
+			actual, err := GetMatchingPlayers("", "")
 			if err != nil {
 				if err.Error() != tt.expectedErr.Error() {
 					t.Errorf("Expected: %v\n\t\t Actual: %v \n", tt.expectedErr, actual)
@@ -0,0 +1,48 @@
+package shellgame
+
+import (
+	"github.com/google/uuid"
+	"github.com/taise-hub/shellgame-cli/common"
+	"sync"
+)
+
+// Pendingは送信したメッセージのうち、サーバからACKまたはNACKを受け取っていないものを送信した順に保持する。
+// 接続し直した後に同じIDで再送すれば、サーバは処理し直さずに前回の結果を返す。
+type Pending struct {
+	mu   sync.Mutex
+	msgs []*common.MatchingMessage
+}
+
+func NewPending() *Pending {
+	return &Pending{}
+}
+
+// IDのないメッセージにはIDを発行し、応答を受け取るまで保持する。
+func (p *Pending) Add(msg *common.MatchingMessage) {
+	if msg.ID == "" {
+		msg.ID = uuid.NewString()
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.msgs = append(p.msgs, msg)
+}
+
+// ACK, NACKを受け取ったメッセージを取り除く。保持していなかった場合はfalseを返す。
+func (p *Pending) Resolve(id string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, msg := range p.msgs {
+		if msg.ID == id {
+			p.msgs = append(p.msgs[:i], p.msgs[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// 応答を受け取っていないメッセージを送信した順に返す。
+func (p *Pending) List() []*common.MatchingMessage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*common.MatchingMessage{}, p.msgs...)
+}
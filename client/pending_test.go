@@ -0,0 +1,37 @@
+package shellgame
+
+import (
+	"github.com/taise-hub/shellgame-cli/common"
+	"testing"
+)
+
+func TestPending(t *testing.T) {
+	p := NewPending()
+	offer := &common.MatchingMessage{Data: common.OFFER}
+	accept := &common.MatchingMessage{ID: "accept-1", Data: common.ACCEPT}
+	p.Add(offer)
+	p.Add(accept)
+
+	t.Run("IDのないメッセージにはIDを発行する。", func(t *testing.T) {
+		if offer.ID == "" || offer.ID == accept.ID {
+			t.Errorf("Expected: new id\n\t\t Actual: %q \n", offer.ID)
+		}
+	})
+	t.Run("応答を受け取っていないメッセージを送信した順に返す。", func(t *testing.T) {
+		list := p.List()
+		if len(list) != 2 || list[0] != offer || list[1] != accept {
+			t.Errorf("Expected: [%s %s]\n\t\t Actual: %v \n", offer.ID, accept.ID, list)
+		}
+	})
+	t.Run("応答を受け取ったメッセージは取り除かれる。", func(t *testing.T) {
+		if !p.Resolve(offer.ID) {
+			t.Errorf("Expected: %v\n\t\t Actual: %v \n", true, false)
+		}
+		if p.Resolve(offer.ID) {
+			t.Errorf("Expected: %v\n\t\t Actual: %v \n", false, true)
+		}
+		if list := p.List(); len(list) != 1 || list[0] != accept {
+			t.Errorf("Expected: [%s]\n\t\t Actual: %v \n", accept.ID, list)
+		}
+	})
+}
@@ -0,0 +1,71 @@
+package shellgame
+
+import (
+	"context"
+	"errors"
+	"github.com/gorilla/websocket"
+	"github.com/taise-hub/shellgame-cli/common"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Backoffは接続し直すまでの待ち時間を失敗するたびに倍にし、Maxを超えないようにする。
+// 同時に切断された多数のクライアントが一斉に接続し直さないように、待ち時間をJitterの割合までランダムに短くする。
+type Backoff struct {
+	Initial  time.Duration
+	Max      time.Duration
+	Attempts int     // 諦めるまでに接続を試みる回数
+	Jitter   float64 // 0から1
+}
+
+var DefaultBackoff = Backoff{Initial: 500 * time.Millisecond, Max: 30 * time.Second, Attempts: 10, Jitter: 0.5}
+
+var (
+	muRand sync.Mutex
+	random = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// attempt回目(1から)の接続を試みる前の待ち時間
+func (b Backoff) Wait(attempt int) time.Duration {
+	wait := b.Initial
+	for i := 1; i < attempt && wait < b.Max; i++ {
+		wait *= 2
+	}
+	if wait > b.Max {
+		wait = b.Max
+	}
+	muRand.Lock()
+	r := random.Float64()
+	muRand.Unlock()
+	return wait - time.Duration(float64(wait)*b.Jitter*r)
+}
+
+// 接続し直すのを諦めた場合のエラー
+var ErrGaveUp = errors.New("サーバに接続し直せませんでした。")
+
+// dialで接続できるまで、bの待ち時間を挟んで接続を試みる。notifyには各試行の前に、何回目の試行かと待ち時間を渡す。
+// サーバがハンドシェイクを拒否した場合は、接続し直しても結果が変わらないため理由をすぐに返す。
+func Redial(ctx context.Context, dial func() (*websocket.Conn, error), b Backoff, notify func(attempt int, wait time.Duration)) (*websocket.Conn, error) {
+	for attempt := 1; attempt <= b.Attempts; attempt++ {
+		wait := b.Wait(attempt)
+		if notify != nil {
+			notify(attempt, wait)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		conn, err := dial()
+		var rejection *common.Rejection
+		if errors.As(err, &rejection) {
+			return nil, rejection
+		} else if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, ErrGaveUp
+}
@@ -0,0 +1,72 @@
+package shellgame
+
+import (
+	"context"
+	"errors"
+	"github.com/gorilla/websocket"
+	"github.com/taise-hub/shellgame-cli/common"
+	"testing"
+	"time"
+)
+
+func TestBackoffWait(t *testing.T) {
+	b := Backoff{Initial: time.Second, Max: 10 * time.Second, Attempts: 10, Jitter: 0.5}
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{attempt: 1, max: time.Second},
+		{attempt: 2, max: 2 * time.Second},
+		{attempt: 3, max: 4 * time.Second},
+		{attempt: 4, max: 8 * time.Second},
+		{attempt: 5, max: 10 * time.Second},
+		{attempt: 10, max: 10 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run("失敗するたびに待ち時間が倍になり、上限を超えない。", func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				actual := b.Wait(tt.attempt)
+				if actual > tt.max || actual < tt.max/2 {
+					t.Fatalf("Expected: %v - %v\n\t\t Actual: %v \n", tt.max/2, tt.max, actual)
+				}
+			}
+		})
+	}
+}
+
+func TestRedial(t *testing.T) {
+	b := Backoff{Initial: time.Millisecond, Max: time.Millisecond, Attempts: 3}
+	t.Run("接続できるまで接続を試みる。", func(t *testing.T) {
+		n := 0
+		conn := &websocket.Conn{}
+		actual, err := Redial(context.Background(), func() (*websocket.Conn, error) {
+			if n++; n < 3 {
+				return nil, errors.New("refused")
+			}
+			return conn, nil
+		}, b, nil)
+		if err != nil || actual != conn {
+			t.Errorf("Expected: %v\n\t\t Actual: %v %v \n", conn, actual, err)
+		}
+	})
+	t.Run("接続できないまま試行回数を超えると諦める。", func(t *testing.T) {
+		var attempts []int
+		_, err := Redial(context.Background(), func() (*websocket.Conn, error) {
+			return nil, errors.New("refused")
+		}, b, func(attempt int, _ time.Duration) { attempts = append(attempts, attempt) })
+		if err != ErrGaveUp || len(attempts) != b.Attempts {
+			t.Errorf("Expected: %v after %d attempts\n\t\t Actual: %v after %v \n", ErrGaveUp, b.Attempts, err, attempts)
+		}
+	})
+	t.Run("サーバが拒否した場合は接続し直さずに理由を返す。", func(t *testing.T) {
+		n := 0
+		_, err := Redial(context.Background(), func() (*websocket.Conn, error) {
+			n++
+			return nil, &common.Rejection{Reason: "更新してください。"}
+		}, b, nil)
+		var rejection *common.Rejection
+		if !errors.As(err, &rejection) || n != 1 {
+			t.Errorf("Expected: rejection after 1 attempt\n\t\t Actual: %v after %d \n", err, n)
+		}
+	})
+}
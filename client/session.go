@@ -0,0 +1,161 @@
+package shellgame
+
+import (
+	"context"
+	"errors"
+	"github.com/gorilla/websocket"
+	"github.com/taise-hub/shellgame-cli/common"
+	"sync"
+	"time"
+)
+
+// Close()した後のSessionで送受信しようとした場合のエラー
+var ErrSessionClosed = errors.New("session closed")
+
+// サーバが接続を閉じる前に送信するエラー。接続し直しても同じ結果になるため、これを受け取った後は接続し直さない。
+var finalErrors = map[common.ErrorCode]bool{
+	common.ERR_IDLE_TIMEOUT: true,
+	common.ERR_ROOM_FULL:    true,
+}
+
+// サーバが接続を閉じる前に送信するエラーかどうか。
+func IsFinalError(code common.ErrorCode) bool {
+	return finalErrors[code]
+}
+
+// Sessionの接続状態の変化
+type SessionState struct {
+	Reconnecting bool
+	Attempt      int           // 何回目の接続し直しか
+	Wait         time.Duration // 接続し直す前の待ち時間
+	Err          error         // 接続し直すのを諦めた場合の理由
+}
+
+// Sessionはロビーや対戦チャンネルへのWebSocketの接続
+// 切断された場合はBackoffに従って接続し直し、ACKまたはNACKを受け取っていないメッセージを同じIDで再送する。
+// サーバは同じIDのメッセージを処理し直さずに前回の結果を返すため、切断前に処理されていたメッセージが二重に処理されることはない。
+type Session struct {
+	dial     func() (*websocket.Conn, error)
+	backoff  Backoff
+	pending  *Pending
+	notify   func(SessionState)
+	mu       sync.Mutex // connの差し替えと送信を保護する。
+	conn     *websocket.Conn
+	finished bool // サーバが接続を閉じることを予告したかどうか。Read()でのみ扱う。
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+// dialで接続する。最初の接続に失敗した場合は接続し直さずにエラーを返す。
+func NewSession(dial func() (*websocket.Conn, error), pending *Pending) (*Session, error) {
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Session{dial: dial, backoff: DefaultBackoff, pending: pending, conn: conn, ctx: ctx, cancel: cancel}, nil
+}
+
+// 接続状態が変化したときに呼び出す関数を設定する。Read()を呼び出す前に設定する。
+func (s *Session) OnStateChange(f func(SessionState)) {
+	s.notify = f
+}
+
+// Read()を呼び出す前に設定する。
+func (s *Session) SetBackoff(b Backoff) {
+	s.backoff = b
+}
+
+// msgにIDを発行して送信する。切断されている場合は、接続し直した後に再送する。
+func (s *Session) Write(msg *common.MatchingMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx.Err() != nil {
+		return ErrSessionClosed
+	}
+	s.pending.Add(msg)
+	// 送信できなかった場合は、Read()が切断に気付いて接続し直した後に再送する。
+	WriteConn(s.conn, msg)
+	return nil
+}
+
+func (s *Session) Ping() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx.Err() != nil {
+		return ErrSessionClosed
+	}
+	s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(20*time.Second))
+	return nil
+}
+
+// メッセージを受信する。切断された場合は接続し直してから受信を続ける。
+// Close()した場合や接続し直すのを諦めた場合はエラーを返す。
+func (s *Session) Read(msg *common.MatchingMessage) error {
+	for {
+		s.mu.Lock()
+		conn := s.conn
+		s.mu.Unlock()
+		err := ReadConn(conn, msg)
+		if err == nil {
+			if msg.Data == common.ERROR && IsFinalError(msg.Code) {
+				s.finished = true
+			}
+			return nil
+		}
+		if s.ctx.Err() != nil || s.finished {
+			s.Close()
+			return ErrSessionClosed
+		} else if errors.Is(err, common.ErrIncompatibleVersion) {
+			s.Close()
+			return err
+		}
+		if err := s.reconnect(); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Session) reconnect() error {
+	s.mu.Lock()
+	s.conn.Close()
+	s.mu.Unlock()
+	conn, err := Redial(s.ctx, s.dial, s.backoff, func(attempt int, wait time.Duration) {
+		s.setState(SessionState{Reconnecting: true, Attempt: attempt, Wait: wait})
+	})
+	if s.ctx.Err() != nil {
+		return ErrSessionClosed
+	} else if err != nil {
+		s.Close()
+		s.setState(SessionState{Err: err})
+		return err
+	}
+	// 再送が終わるまで新しいメッセージを送信しないように、差し替えと再送をまとめて行う。
+	s.mu.Lock()
+	s.conn = conn
+	for _, msg := range s.pending.List() {
+		WriteConn(conn, msg)
+	}
+	s.mu.Unlock()
+	s.setState(SessionState{})
+	return nil
+}
+
+func (s *Session) setState(state SessionState) {
+	if s.notify != nil {
+		s.notify(state)
+	}
+}
+
+// Close()した場合や接続し直すのを諦めた場合に閉じられる。以降は送受信できない。
+func (s *Session) Done() <-chan struct{} {
+	return s.ctx.Done()
+}
+
+// 接続を閉じ、以降は接続し直さない。
+func (s *Session) Close() error {
+	s.cancel()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn.Close()
+}
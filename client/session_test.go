@@ -0,0 +1,150 @@
+package shellgame
+
+import (
+	"github.com/gorilla/websocket"
+	"github.com/taise-hub/shellgame-cli/common"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 受信したメッセージをreceivedに流し、sendに流されたメッセージを送信するテスト用のサーバ
+// dropに流すと、その時点の接続を切断する。
+// クライアントと同じロックを取らないように、ReadConn, WriteConnを使わずに送受信する。
+type fakeServer struct {
+	received chan *common.MatchingMessage
+	send     chan *common.MatchingMessage
+	drop     chan struct{}
+}
+
+func startFakeServer(t *testing.T) (*fakeServer, func() (*websocket.Conn, error)) {
+	t.Helper()
+	fs := &fakeServer{
+		received: make(chan *common.MatchingMessage, 16),
+		send:     make(chan *common.MatchingMessage),
+		drop:     make(chan struct{}),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, req, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		go func() {
+			for {
+				env := &common.Envelope{}
+				msg := &common.MatchingMessage{}
+				if err := conn.ReadJSON(env); err != nil || env.Unwrap(msg) != nil {
+					return
+				}
+				fs.received <- msg
+			}
+		}()
+		for {
+			select {
+			case msg := <-fs.send:
+				env, _ := common.Wrap(msg)
+				conn.WriteJSON(env)
+			case <-fs.drop:
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	u := "ws" + strings.TrimPrefix(server.URL, "http")
+	return fs, func() (*websocket.Conn, error) {
+		conn, _, err := websocket.DefaultDialer.Dial(u, nil)
+		return conn, err
+	}
+}
+
+func (fs *fakeServer) expect(t *testing.T) *common.MatchingMessage {
+	t.Helper()
+	select {
+	case msg := <-fs.received:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for message")
+		return nil
+	}
+}
+
+func TestSession(t *testing.T) {
+	fs, dial := startFakeServer(t)
+	pending := NewPending()
+	session, err := NewSession(dial, pending)
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	session.SetBackoff(Backoff{Initial: time.Millisecond, Max: 10 * time.Millisecond, Attempts: 10})
+	states := make(chan SessionState, 16)
+	session.OnStateChange(func(s SessionState) { states <- s })
+	received := make(chan *common.MatchingMessage, 16)
+	closed := make(chan error, 1)
+	go func() {
+		for {
+			msg := &common.MatchingMessage{}
+			if err := session.Read(msg); err != nil {
+				closed <- err
+				return
+			}
+			received <- msg
+		}
+	}()
+
+	offer := &common.MatchingMessage{Data: common.OFFER}
+	t.Run("送信したメッセージにはIDが発行される。", func(t *testing.T) {
+		session.Write(offer)
+		if msg := fs.expect(t); msg.ID == "" || msg.ID != offer.ID {
+			t.Errorf("Expected: %s\n\t\t Actual: %s \n", offer.ID, msg.ID)
+		}
+	})
+	t.Run("切断されると接続し直し、応答を受け取っていないメッセージを同じIDで再送する。", func(t *testing.T) {
+		fs.drop <- struct{}{}
+		if msg := fs.expect(t); msg.ID != offer.ID {
+			t.Errorf("Expected: %s\n\t\t Actual: %s \n", offer.ID, msg.ID)
+		}
+	})
+	t.Run("接続し直している間は再接続中であることを通知し、接続し直すと通知を取り消す。", func(t *testing.T) {
+		if s := <-states; !s.Reconnecting || s.Attempt != 1 {
+			t.Errorf("Expected: reconnecting\n\t\t Actual: %+v \n", s)
+		}
+		if s := <-states; s.Reconnecting || s.Err != nil {
+			t.Errorf("Expected: connected\n\t\t Actual: %+v \n", s)
+		}
+	})
+	t.Run("接続し直した後も受信を続けられる。", func(t *testing.T) {
+		fs.send <- &common.MatchingMessage{ID: offer.ID, Data: common.ACK}
+		select {
+		case msg := <-received:
+			if msg.Data != common.ACK {
+				t.Errorf("Expected: %s\n\t\t Actual: %s \n", common.MatchingMessageData(common.ACK), msg.Data)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("timed out waiting for message")
+		}
+	})
+	t.Run("サーバが切断を予告した後は接続し直さない。", func(t *testing.T) {
+		fs.send <- &common.MatchingMessage{Data: common.ERROR, Code: common.ERR_IDLE_TIMEOUT}
+		<-received
+		fs.drop <- struct{}{}
+		select {
+		case err := <-closed:
+			if err != ErrSessionClosed {
+				t.Errorf("Expected: %v\n\t\t Actual: %v \n", ErrSessionClosed, err)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("Expected: %v\n\t\t Actual: still reading \n", ErrSessionClosed)
+		}
+		if err := session.Write(&common.MatchingMessage{Data: common.CHAT}); err != ErrSessionClosed {
+			t.Errorf("Expected: %v\n\t\t Actual: %v \n", ErrSessionClosed, err)
+		}
+		select {
+		case <-session.Done():
+		default:
+			t.Errorf("Expected: done\n\t\t Actual: not done \n")
+		}
+	})
+}
@@ -1,24 +1,31 @@
 package shellgame
 
 import (
+	"context"
+	"fmt"
+	"github.com/gorilla/websocket"
 	"golang.org/x/term"
 	"io"
+	"net"
 	"os"
+	"sync"
+	"time"
 )
 
 // Terminalは.github.com/charmbracelet/bubbletea.ExecCommandの実装
 // websoketを利用してシェルゲーサーバで用意されるコンテナに接続する。
+// 対戦のシェルは接続し直しても同じコンテナで再開できるため、切断された場合は接続し直す。
 type Terminal struct {
-	Stdin  io.Reader
-	Stdout io.Writer
+	BattleID string
+	Stdin    io.Reader
+	Stdout   io.Writer
 }
 
 func (t *Terminal) Run() error {
-	wsconn, err := ConnectShell()
+	wsconn, err := ConnectShell(t.BattleID)
 	if err != nil {
 		return err
 	}
-	defer wsconn.Close()
 
 	oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
 	if err != nil {
@@ -27,10 +34,56 @@ func (t *Terminal) Run() error {
 
 	defer func() { _ = term.Restore(int(os.Stdin.Fd()), oldState) }()
 
+	var mu sync.Mutex
 	conn := wsconn.UnderlyingConn()
-	go func() { io.Copy(conn, t.Stdin) }()
-	io.Copy(t.Stdout, conn)
-	return nil
+	defer func() {
+		mu.Lock()
+		defer mu.Unlock()
+		conn.Close()
+	}()
+	// 接続し直している間の入力は捨てる。
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := t.Stdin.Read(buf)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			c := conn
+			mu.Unlock()
+			c.Write(buf[:n])
+		}
+	}()
+	for {
+		mu.Lock()
+		c := conn
+		mu.Unlock()
+		// シェルが終了した場合はサーバが接続を閉じ、エラーなく終了する。
+		if _, err := io.Copy(t.Stdout, c); err == nil || t.BattleID == "" {
+			return nil
+		}
+		c.Close()
+		next, err := t.reconnect()
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		conn = next
+		mu.Unlock()
+	}
+}
+
+func (t *Terminal) reconnect() (net.Conn, error) {
+	dial := func() (*websocket.Conn, error) { return ConnectShell(t.BattleID) }
+	wsconn, err := Redial(context.Background(), dial, DefaultBackoff, func(attempt int, wait time.Duration) {
+		fmt.Fprintf(t.Stdout, "\r\n再接続中... (%d回目, %v後)\r\n", attempt, wait.Round(time.Second))
+	})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprint(t.Stdout, "\r\n再接続しました。\r\n")
+	return wsconn.UnderlyingConn(), nil
 }
 
 func (t *Terminal) SetStdin(r io.Reader) {
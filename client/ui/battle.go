@@ -1,15 +1,18 @@
 package ui
 
 import (
+	"fmt"
 	tea "github.com/charmbracelet/bubbletea"
 	shellgame "github.com/taise-hub/shellgame-cli/client"
 	"github.com/charmbracelet/bubbles/list"
+	"github.com/taise-hub/shellgame-cli/common"
+	"strings"
 )
 
 type shellFinishedMsg struct{ err error }
 
-func ExecShell() tea.Cmd {
-	return tea.Exec(&shellgame.Terminal{}, func(err error) tea.Msg {
+func ExecShell(battleID string) tea.Cmd {
+	return tea.Exec(&shellgame.Terminal{BattleID: battleID}, func(err error) tea.Msg {
 		return shellFinishedMsg{err}
 	})
 }
@@ -18,6 +21,8 @@ type battleModel struct {
 	screen screen
 	screens list.Model
 	isShell bool
+	battle *common.Battle // 対戦の参加者とチーム
+	chat battleChatModel
 	err error
 }
 
@@ -34,7 +39,7 @@ func NewBattleModel() battleModel {
 	s.SetFilteringEnabled(false)
 	s.SetShowHelp(false)
 
-	return battleModel{isShell: false, screen: "", screens: s}
+	return battleModel{isShell: false, screen: "", screens: s, chat: NewBattleChatModel()}
 }
 
 func (bm battleModel) Init() tea.Cmd {
@@ -42,9 +47,31 @@ func (bm battleModel) Init() tea.Cmd {
 }
 
 func (bm battleModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if _, ok := msg.(tea.KeyMsg); ok && bm.chat.visible {
+		return bm.chat.Update(msg, bm)
+	}
 	switch msg := msg.(type) {
+	case sessionStateMsg:
+		bm.chat.state = msg.String()
+		return bm, nil
+	case disconnectedMsg:
+		bm.chat.disconnected(msg)
+		return bm, nil
+	case MatchingMsg:
+		if msg.Data == common.ERROR {
+			bm.chat.notice(msg.reason())
+		} else {
+			bm.chat.add(msg)
+		}
+		return bm, nil
 	case screenChangeMsg:
-		// 問題と自分と対戦相手のスコアを取ってくる。
+		bm.connectChat()
+		// TODO: 問題も取ってくる。
+		if bm.battle != nil {
+			if battle, err := shellgame.GetBattle(bm.battle.ID); err == nil {
+				bm.battle = battle
+			}
+		}
 		return bm, nil
 	case shellFinishedMsg:
 		bm.screen = screen("")
@@ -54,6 +81,8 @@ func (bm battleModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 	case tea.KeyMsg:
 		switch msg.String() {
+		case "c":
+			return bm, bm.chat.open()
 		case "enter":
 			i, ok := bm.screens.SelectedItem().(screen)
 			if !ok {
@@ -63,7 +92,7 @@ func (bm battleModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 			switch bm.screen {
 			case "シェル":
-				return bm, ExecShell()
+				return bm, ExecShell(bm.battleID())
 			case "降参":
 				//TODO:　降参した旨を送信する
 				return bm, tea.Quit
@@ -84,6 +113,33 @@ func (bm battleModel) View() string {
 	case "シェル":
 		return ""
 	default:
-		return "\n" + bm.screens.View()
+		return "\n" + bm.teamView() + bm.screens.View() + bm.chat.View()
+	}
+}
+
+func (bm battleModel) battleID() string {
+	if bm.battle == nil {
+		return ""
+	}
+	return bm.battle.ID
+}
+
+// チームごとのメンバーを表示する。個人戦では一人ずつ表示する。
+// 回答を採点する仕組みがまだないため、得点は表示しない。
+func (bm battleModel) teamView() string {
+	if bm.battle == nil {
+		return ""
+	}
+	var b strings.Builder
+	for i, team := range bm.battle.Teams {
+		var names []string
+		for _, p := range team.Members {
+			names = append(names, p.Name)
+		}
+		b.WriteString(fmt.Sprintf("  %d. %s\n", i+1, strings.Join(names, ", ")))
+	}
+	if bm.battle.SharedShell {
+		b.WriteString("  (チームメイトとシェルを共有しています)\n")
 	}
+	return b.String() + "\n"
 }
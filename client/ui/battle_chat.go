@@ -0,0 +1,221 @@
+package ui
+
+import (
+	"fmt"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/gorilla/websocket"
+	shellgame "github.com/taise-hub/shellgame-cli/client"
+	"github.com/taise-hub/shellgame-cli/common"
+	"strings"
+	"time"
+)
+
+// 対戦中に送信できるエモート。サーバで受け付けるものと揃える。
+var emotes = []string{"gg", "hello", "nice", "oops", "thanks"}
+
+// 対戦画面に重ねて表示するチャット欄
+// cで開き、/から始まる入力はエモート(/gg等)やミュート(/mute 名前, /unmute 名前)として扱う。
+type battleChatModel struct {
+	visible  bool
+	input    textinput.Model
+	messages []string
+	unread   int // 閉じている間に受け取ったチャットの数
+	session  *shellgame.Session
+	state    string // 再接続中などの接続の状態。接続している間は空
+	sendChan chan *MatchingMsg // sessionのwritePumpに渡すメッセージ。Sessionごとに作り直す。
+	pending  *shellgame.Pending // ACK, NACKを受け取っていない送信済みのメッセージ
+}
+
+func NewBattleChatModel() battleChatModel {
+	ti := textinput.New()
+	ti.CharLimit = 200
+	ti.Width = 60
+	return battleChatModel{input: ti, pending: shellgame.NewPending()}
+}
+
+func (cm battleChatModel) Update(msg tea.Msg, bm battleModel) (tea.Model, tea.Cmd) {
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch msg.String() {
+		case "ctrl+c":
+			return bm, tea.Quit
+		case "esc":
+			bm.chat.visible = false
+			bm.chat.input.Blur()
+			return bm, nil
+		case "enter":
+			text := strings.TrimSpace(cm.input.Value())
+			bm.chat.input.Reset()
+			if text == "" {
+				return bm, nil
+			}
+			if err := bm.sendChat(text); err != nil {
+				bm.chat.notice(err.Error())
+			}
+			return bm, nil
+		}
+	}
+	var cmd tea.Cmd
+	bm.chat.input, cmd = cm.input.Update(msg)
+	return bm, cmd
+}
+
+func (cm battleChatModel) View() string {
+	if cm.state != "" {
+		return "\n  " + cm.state + cm.view()
+	}
+	return cm.view()
+}
+
+func (cm battleChatModel) view() string {
+	if !cm.visible {
+		if cm.unread > 0 {
+			return fmt.Sprintf("\n  チャット: 未読 %d件 (c: 開く)\n", cm.unread)
+		}
+		return "\n  (c: チャット)\n"
+	}
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("\n  チャット (esc: 閉じる, /%s: エモート, /mute 名前, /unmute 名前)\n", strings.Join(emotes, " /")))
+	for _, m := range cm.messages {
+		b.WriteString("  " + m + "\n")
+	}
+	b.WriteString("  " + cm.input.View() + "\n")
+	return b.String()
+}
+
+func (cm *battleChatModel) open() tea.Cmd {
+	cm.visible = true
+	cm.unread = 0
+	cm.input.Focus()
+	return textinput.Blink
+}
+
+// サーバから受け取ったチャット、エモート、ミュートの結果を表示用に整形する。
+func (cm *battleChatModel) add(msg MatchingMsg) {
+	switch msg.Data {
+	case common.BATTLE_CHAT:
+		cm.append(fmt.Sprintf("[%s] %s", msg.Source.Name, msg.Text))
+	case common.EMOTE:
+		cm.append(fmt.Sprintf("* %s: /%s", msg.Source.Name, msg.Text))
+	case common.MUTE:
+		cm.notice(fmt.Sprintf("%sをミュートしました。", msg.Dest.Name))
+		return
+	case common.UNMUTE:
+		cm.notice(fmt.Sprintf("%sのミュートを解除しました。", msg.Dest.Name))
+		return
+	default:
+		return
+	}
+	if !cm.visible {
+		cm.unread++
+	}
+}
+
+func (cm *battleChatModel) notice(text string) {
+	cm.append("* " + text)
+}
+
+func (cm *battleChatModel) append(line string) {
+	cm.messages = append(cm.messages, line)
+	if len(cm.messages) > CHAT_HISTORY {
+		cm.messages = cm.messages[len(cm.messages)-CHAT_HISTORY:]
+	}
+}
+
+// 入力を解釈して対戦チャンネルに送信する。
+func (bm battleModel) sendChat(text string) error {
+	if bm.chat.session == nil {
+		return fmt.Errorf("対戦チャンネルに接続していません。")
+	}
+	msg := &MatchingMsg{Source: shellgame.GetMyProfile(), Data: common.BATTLE_CHAT, Text: text}
+	if strings.HasPrefix(text, "/") {
+		fields := strings.Fields(strings.TrimPrefix(text, "/"))
+		if len(fields) == 0 {
+			return fmt.Errorf("/ の後にエモートかコマンドを指定してください。")
+		}
+		switch fields[0] {
+		case "mute", "unmute":
+			if len(fields) < 2 {
+				return fmt.Errorf("/%s の後にプレイヤー名を指定してください。", fields[0])
+			}
+			dest := bm.participant(fields[1])
+			if dest == nil {
+				return fmt.Errorf("%sは対戦に参加していません。", fields[1])
+			}
+			msg.Data, msg.Text, msg.Dest = common.MUTE, "", dest
+			if fields[0] == "unmute" {
+				msg.Data = common.UNMUTE
+			}
+		default:
+			msg.Data, msg.Text = common.EMOTE, fields[0]
+		}
+	}
+	if err := send(bm.chat.session, bm.chat.sendChan, msg); err != nil {
+		return fmt.Errorf("対戦チャンネルから切断されています。")
+	}
+	return nil
+}
+
+// 名前から対戦の参加者を探す。
+func (bm battleModel) participant(name string) *common.Profile {
+	if bm.battle == nil {
+		return nil
+	}
+	for _, team := range bm.battle.Teams {
+		for _, p := range team.Members {
+			if p.Name == name {
+				return p
+			}
+		}
+	}
+	return nil
+}
+
+// 対戦チャンネルに接続し、送受信を始める。接続できない場合もチャット以外は続けられるようにする。
+// 切断された場合は自動で接続し直し、接続の状態を画面に通知する。
+func (bm *battleModel) connectChat() {
+	if bm.chat.session != nil || bm.battle == nil {
+		return
+	}
+	id := bm.battle.ID
+	session, err := shellgame.NewSession(func() (*websocket.Conn, error) {
+		return shellgame.ConnectBattleChannel(id)
+	}, bm.chat.pending)
+	if err != nil {
+		bm.chat.notice("対戦チャンネルに接続できませんでした。")
+		return
+	}
+	session.OnStateChange(notifyState)
+	bm.chat.session = session
+	bm.chat.sendChan = make(chan *MatchingMsg)
+	go readPump(session, bm.chat.pending)
+	go bm.chat.writePump()
+}
+
+// 対戦チャンネルから切断された場合は、理由をチャット欄に表示する。チャット以外は続けられる。
+func (cm *battleChatModel) disconnected(msg disconnectedMsg) {
+	if msg.session != cm.session {
+		return
+	}
+	cm.notice(fmt.Sprintf("対戦チャンネルから切断されました: %v", msg.err))
+}
+
+// Update()から受け取ったメッセージをwebsocketに流す。Sessionが閉じたら終了する。
+func (cm battleChatModel) writePump() {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case m := <-cm.sendChan:
+			if err := cm.session.Write((*common.MatchingMessage)(m)); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := cm.session.Ping(); err != nil {
+				return
+			}
+		case <-cm.session.Done():
+			return
+		}
+	}
+}
@@ -5,13 +5,18 @@ import (
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	shellgame "github.com/taise-hub/shellgame-cli/client"
-	"log"
+	"golang.org/x/crypto/ssh"
 )
 
 // initModelはゲーム開始時にユーザ名を登録する画面の実装
+// パスワードを入力しない場合はゲストとして、入力した場合はアカウントでログインする。
+// SHELLGAME_SSH_KEYに秘密鍵が指定されている場合、パスワードを入力しなければSSH公開鍵認証でログインする。
 type initModel struct {
 	textInput textinput.Model
+	password  textinput.Model
+	signer    ssh.Signer
 	top       *topModel
+	err       error // プレイヤー名が使われている場合などにサーバから返されたエラー
 }
 
 func NewInitModel() initModel {
@@ -19,11 +24,19 @@ func NewInitModel() initModel {
 	ti.CharLimit = 156
 	ti.Width = 20
 	ti.Focus()
+	pi := textinput.New()
+	pi.CharLimit = 72
+	pi.Width = 20
+	pi.EchoMode = textinput.EchoPassword
 	tm := NewTopModel()
+	signer, err := shellgame.LoadSigner()
 
 	return initModel{
 		textInput: ti,
+		password:  pi,
+		signer:    signer,
 		top:       &tm,
+		err:       err,
 	}
 }
 
@@ -37,21 +50,57 @@ func (im initModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case screenChangeMsg:
 	case tea.KeyMsg:
 		switch msg.String() {
-		case "q", "ctrl+c":
+		case "ctrl+c":
 			return im, tea.Quit
+		case "tab", "shift+tab":
+			if im.textInput.Focused() {
+				im.textInput.Blur()
+				im.password.Focus()
+			} else {
+				im.password.Blur()
+				im.textInput.Focus()
+			}
+			return im, nil
 		case "enter":
-			if err := shellgame.PostProfile(im.textInput.Value()); err != nil {
-				log.Fatalf("%v\n", err.Error())
+			if err := im.login(); err != nil {
+				im.err = err
+				return im, nil
+			}
+			return im.top, screenChange("init")
+		case "ctrl+r":
+			if err := shellgame.RegisterAccount(im.textInput.Value(), im.password.Value(), im.signer); err != nil {
+				im.err = err
+				return im, nil
 			}
 			return im.top, screenChange("init")
 		}
 	}
-	im.textInput, cmd = im.textInput.Update(msg)
+	if im.textInput.Focused() {
+		im.textInput, cmd = im.textInput.Update(msg)
+	} else {
+		im.password, cmd = im.password.Update(msg)
+	}
 	return im, cmd
 }
 
+func (im initModel) login() error {
+	name, password := im.textInput.Value(), im.password.Value()
+	switch {
+	case password != "":
+		return shellgame.Login(name, password)
+	case im.signer != nil:
+		return shellgame.LoginWithKey(name, im.signer)
+	default:
+		return shellgame.PostProfile(name)
+	}
+}
+
 func (im initModel) View() string {
-	return fmt.Sprintf(
-		"プレイヤー名を入力してください。\n\n%s",
-		im.textInput.View()) + "\n"
+	view := fmt.Sprintf(
+		"プレイヤー名を入力してください。\n\n%s\n\nパスワード(ゲストで遊ぶ場合は空欄)\n\n%s\n\n(tab: 入力欄の切り替え, enter: ログイン, ctrl+r: アカウント登録)",
+		im.textInput.View(), im.password.View())
+	if im.err != nil {
+		view += "\n\n" + im.err.Error()
+	}
+	return view + "\n"
 }
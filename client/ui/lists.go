@@ -38,7 +38,20 @@ func (d screenDelegate) Render(w io.Writer, m list.Model, index int, listItem li
 
 type Profile common.Profile
 
-func (p Profile) FilterValue() string { return "" }
+// ロビーにいるプレイヤーの一覧を扱うリスト
+type Player common.MatchingPlayer
+
+func (p Player) FilterValue() string { return "" }
+
+// 一覧に表示するプレイヤーの状態
+var statusLabels = map[common.MatchingStatus]string{
+	common.WAITING:     "対戦待ち",
+	common.NEGOTIATING: "交渉中",
+	common.IN_BATTLE:   "対戦中",
+	common.QUEUED:      "ランダム対戦待ち",
+	common.IN_PARTY:    "パーティ",
+	common.AWAY:        "離席中",
+}
 
 type profileDelegate struct{}
 
@@ -46,12 +59,42 @@ func (d profileDelegate) Height() int                               { return 1 }
 func (d profileDelegate) Spacing() int                              { return 0 }
 func (d profileDelegate) Update(msg tea.Msg, m *list.Model) tea.Cmd { return nil }
 func (d profileDelegate) Render(w io.Writer, m list.Model, index int, listItem list.Item) {
-	i, ok := listItem.(Profile)
+	i, ok := listItem.(Player)
+	if !ok || i.Profile == nil {
+		return
+	}
+
+	str := fmt.Sprintf("* %s (%d) %s", i.Profile.Name, i.Profile.Rating, statusLabels[i.Status])
+	if !i.Joined.IsZero() {
+		str += fmt.Sprintf(" %sから", i.Joined.Local().Format("15:04"))
+	}
+
+	fn := itemStyle.Render
+	if index == m.Index() {
+		fn = func(s string) string {
+			return selectedItemStyle.Render(">  " + s)
+		}
+	}
+
+	fmt.Fprintf(w, fn(str))
+}
+
+type Room common.Room
+
+func (r Room) FilterValue() string { return "" }
+
+type roomDelegate struct{}
+
+func (d roomDelegate) Height() int                               { return 1 }
+func (d roomDelegate) Spacing() int                              { return 0 }
+func (d roomDelegate) Update(msg tea.Msg, m *list.Model) tea.Cmd { return nil }
+func (d roomDelegate) Render(w io.Writer, m list.Model, index int, listItem list.Item) {
+	i, ok := listItem.(Room)
 	if !ok {
 		return
 	}
 
-	str := fmt.Sprintf("* %s", i.Name)
+	str := fmt.Sprintf("* %s (%d人)", i.Name, i.Players)
 
 	fn := itemStyle.Render
 	if index == m.Index() {
@@ -1,40 +1,53 @@
 package ui
 
 import (
+	"fmt"
 	"github.com/charmbracelet/bubbles/list"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/gorilla/websocket"
 	shellgame "github.com/taise-hub/shellgame-cli/client"
 	"github.com/taise-hub/shellgame-cli/common"
+	"strings"
 	"time"
 )
 
 type matchModel struct {
 	list         list.Model
 	screen       screen
-	conn         *websocket.Conn
-	matchingChan chan *MatchingMsg
+	room         string // 対戦待ちを行うロビー
+	code         string // プライベートルームの招待コード。公開されているロビーでは空
+	host         bool   // 自身が作成したプライベートルームかどうか
+	assigned     []Profile // ホストが次の対戦に割り当てたプレイヤー
+	seq          uint64    // 一覧に反映したロビーの変化の通し番号
+	synced       bool      // SNAPSHOTを受け取り、一覧がサーバと一致しているかどうか
+	session      *shellgame.Session
+	connState    string // 再接続中などの接続の状態。接続している間は空
+	matchingChan chan *MatchingMsg // sessionのwritePumpに渡すメッセージ。Sessionごとに作り直す。
+	pending      *shellgame.Pending // ACK, NACKを受け取っていない送信済みのメッセージ
 
 	parent       *topModel
 	battle		 battleModel
 	received     matchReceivedModel
 	waits        matchWaitModel
+	queue        matchQueueModel
+	party        matchPartyModel
+	chat         matchChatModel
 }
 
 func NewMatchModel() (matchModel, error) {
 	l := list.New(nil, profileDelegate{}, width, 14)
-	l.Title = "対戦相手を選択してください"
+	l.Title = "対戦相手を選択してください (r: ランダム対戦, p: パーティに招待, m: パーティ)"
 	l.Styles.Title = titleStyle
 	l.SetShowStatusBar(false)
 	l.SetFilteringEnabled(false)
 	l.SetShowHelp(false)
-	mc := make(chan *MatchingMsg)
 
 	rm := NewMatchRequestModel()
-	wm := NewMatchWaitModel()
+	wm := NewMatchWaitModel(Profile{})
 	bm := NewBattleModel()
+	cm := NewMatchChatModel()
 
-	return matchModel{list: l, screen: "", received: rm, waits: wm, battle: bm, matchingChan: mc}, nil
+	return matchModel{list: l, screen: "", received: rm, waits: wm, battle: bm, chat: cm, pending: shellgame.NewPending()}, nil
 }
 
 func (mm matchModel) Init() tea.Cmd {
@@ -42,39 +55,120 @@ func (mm matchModel) Init() tea.Cmd {
 }
 
 func (mm matchModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if msg, ok := msg.(sessionStateMsg); ok {
+		mm.connState = msg.String()
+		return mm, nil
+	}
+	if msg, ok := msg.(disconnectedMsg); ok {
+		return mm.disconnectedHandler(msg)
+	}
+	// チャットとロビーの変化はどの画面を表示していても受け取っておく。
+	// 接続し直して再送した承諾や開始のACKに対戦が含まれていれば、どの画面からでも対戦を始める。
+	if msg, ok := msg.(MatchingMsg); ok {
+		switch {
+		case msg.Data == common.ACK:
+			if inBattle(msg.Battle) {
+				return mm.startBattle(msg, "match")
+			}
+			return mm, nil
+		case isChat(msg.Data):
+			mm.chat.add(msg)
+			return mm, nil
+		case isLobby(msg.Data):
+			return mm.lobbyMsgHandler(msg)
+		}
+	}
 	switch mm.screen {
 	case "received":
 		return mm.received.Update(msg, mm)
 	case "waits":
 		return mm.waits.Update(msg, mm)
+	case "queue":
+		return mm.queue.Update(msg, mm)
+	case "party":
+		return mm.party.Update(msg, mm)
 	default:
 		return mm.update(msg)
 	}
 }
 
 func (mm matchModel) update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if _, ok := msg.(tea.KeyMsg); ok && mm.chat.focused() {
+		return mm.chat.Update(msg, mm)
+	}
 	switch msg := msg.(type) {
 	case screenChangeMsg:
 		return mm.screenChangeHandler(msg)
 	case MatchingMsg:
 		return mm.matchingMsgHandler(msg)
-	// case timeoutMsg: // 対戦要求に一定時間返答がない場合に受け取るメッセージ
 	case tea.KeyMsg:
 		switch keypress := msg.String(); keypress {
 		case "ctrl+c":
 			return mm, tea.Quit
 		case "enter":
-			// 送信時に3分後にtimeoutMsgを通知する処理をgoroutineで動かす。
-			// 送信後、matchModelの状態をwaitとかにしてローディング画面でも表示しとく？
-			dest, _ := mm.list.SelectedItem().(Profile)
+			// 回答期限はサーバから送り返される対戦申請で受け取る。
+			dest := mm.selected()
 			if dest.ID == "" {
 				return mm, nil
 			}
-			mm.sendMatchingMessage(dest, common.OFFER)
+			// パーティのリーダー同士であればチーム戦の申請になる。
+			mm.sendBattleMessage(&dest, common.OFFER, &common.Battle{SharedShell: mm.party.shared})
+			mm.waits = NewMatchWaitModel(dest)
 			mm.screen = "waits"
 			return mm, screenChange("match")
+		case "r":
+			// 参加できたかどうかはサーバから送り返される通知で受け取る。
+			mm.sendQueueMessage(common.QUEUE)
+			mm.queue = NewMatchQueueModel()
+			mm.screen = "queue"
+			return mm, screenChange("match")
+		case "p":
+			// 招待できたかどうかはサーバから通知されるパーティの状態で受け取る。
+			dest := mm.selected()
+			if dest.ID == "" {
+				return mm, nil
+			}
+			mm.sendMatchingMessage(dest, common.PARTY_INVITE)
+			mm.screen = "party"
+			return mm, screenChange("match")
+		case "m":
+			mm.screen = "party"
+			return mm, screenChange("match")
+		case "a":
+			// プライベートルームのホストは対戦させるプレイヤーを割り当てる。
+			dest := mm.selected()
+			if !mm.host || dest.ID == "" {
+				return mm, nil
+			}
+			mm.assign(dest)
+			return mm, nil
+		case "s":
+			// 二人であれば1対1、三人以上であれば個人戦を開始する。
+			if !mm.host || len(mm.assigned) < 2 {
+				return mm, nil
+			}
+			battle := &common.Battle{Mode: common.DUEL}
+			if len(mm.assigned) > 2 {
+				battle.Mode = common.FREE_FOR_ALL
+			}
+			for _, p := range mm.assigned {
+				member := common.Profile(p)
+				battle.Teams = append(battle.Teams, &common.Team{Members: []*common.Profile{&member}})
+			}
+			mm.sendBattleMessage(nil, common.START, battle)
+			mm.assigned = nil
+			mm.setTitle()
+			return mm, nil
+		case "tab":
+			return mm, mm.chat.focus(nil)
+		case "w":
+			dest := mm.selected()
+			if dest.ID == "" {
+				return mm, nil
+			}
+			return mm, mm.chat.focus(&dest)
 		case "q":
-			mm.conn.Close()
+			mm.session.Close()
 			return mm.parent, screenChange("match")
 		}
 	}
@@ -84,32 +178,40 @@ func (mm matchModel) update(msg tea.Msg) (tea.Model, tea.Cmd) {
 }
 
 func (mm matchModel) View() string {
+	var state string
+	if mm.connState != "" {
+		state = "\n  " + mm.connState + "\n"
+	}
 	switch mm.screen {
 	case "received":
-		return mm.received.View()
+		return state + mm.received.View()
 	case "waits":
-		return mm.waits.View()
+		return state + mm.waits.View()
+	case "queue":
+		return state + mm.queue.View()
+	case "party":
+		return state + mm.party.View()
 	default:
-		return "\n" + mm.list.View()
+		return state + "\n" + mm.list.View() + mm.chat.View()
 	}
 }
 
 func (mm matchModel) screenChangeHandler(msg screenChangeMsg) (tea.Model, tea.Cmd) {
 	switch msg {
-	case "top": // TOP画面からの遷移。現在対戦待ちのPlayerを取得し、webosocketでコネクションを生成する。
-		if err := mm.updateProfiles(); err != nil {
-			return matchModel{}, tea.Quit
-		}
+	case "rooms": // ロビー選択画面からの遷移。webosocketでコネクションを生成し、参加直後に届くSNAPSHOTで一覧を作る。
+		mm.assigned = nil
+		mm.setTitle()
+		mm.list.SetItems(nil)
+		mm.seq, mm.synced = 0, false
+		mm.connState = ""
 		if err := mm.createConn(); err != nil {
-			return matchModel{}, tea.Quit
+			// サーバがバージョンの不一致などで接続を拒否した場合は、理由をロビーの選択画面に表示する。
+			rm := mm.parent.rooms
+			rm.err = err
+			return rm, screenChange("top")
 		}
 		go mm.matching()
 		return mm, nil
-	case "received" , "waits": // 対戦要求の回答画面からの遷移。現在対戦待ちのPlayerを更新する。
-		if err := mm.updateProfiles(); err != nil {
-			return matchModel{}, tea.Quit
-		}
-		return mm, nil
 	}
 	return mm, nil
 }
@@ -117,104 +219,169 @@ func (mm matchModel) screenChangeHandler(msg screenChangeMsg) (tea.Model, tea.Cm
 func (mm matchModel) matchingMsgHandler(msg MatchingMsg) (tea.Model, tea.Cmd) {
 	switch msg.Data {
 	case common.OFFER:
-		mm.received.from = Profile(*msg.Source)
+		mm.received = NewMatchRequestModel()
+		mm.received.add(msg)
 		mm.screen = "received"
+		return mm, tea.Batch(screenChange("match"), countdown())
+	case common.PARTY_INVITE:
+		mm.party.invitation = msg.Party
+		mm.screen = "party"
 		return mm, screenChange("match")
-	case common.JOIN:
-		mm.appendProfile(Profile(*msg.Source))
-		return mm, nil
-	case common.LEAVE:
-		mm.removeProfile(Profile(*msg.Source))
+	case common.PARTY:
+		mm.party.party = msg.Party
 		return mm, nil
+	case common.ACCEPT, common.START: // パーティのリーダーやプライベートルームのホストが開始した対戦
+		if !inBattle(msg.Battle) { // 自身が参加しない対戦を開始したホスト
+			return mm, nil
+		}
+		return mm.startBattle(msg, "match")
 	case common.ERROR:
+		mm.chat.notice(msg.reason())
+	case common.IDLE_WARNING:
+		if msg.Deadline != nil {
+			mm.chat.notice(fmt.Sprintf("操作がないため%sにロビーから切断されます。チャットや対戦申請を送信すると切断されません。", msg.Deadline.Local().Format("15:04:05")))
+		}
 	}
 	return mm, nil
 }
 
-func (mm *matchModel) appendProfile(p Profile) {
-	i := 0
-	for _, v := range mm.list.Items() {
-		if v == nil {
-			return
-		}
-		if v.(Profile).ID == p.ID {
-			return
+// 選択しているプレイヤー。一覧が空の場合はIDが空のProfileを返す。
+func (mm matchModel) selected() Profile {
+	p, ok := mm.list.SelectedItem().(Player)
+	if !ok || p.Profile == nil {
+		return Profile{}
+	}
+	return Profile(*p.Profile)
+}
+
+func isLobby(data common.MatchingMessageData) bool {
+	return data == common.JOIN || data == common.LEAVE || data == common.STATUS || data == common.SNAPSHOT
+}
+
+// ロビーの変化を通し番号の順に一覧へ反映する。番号が抜けていればSNAPSHOTを要求して一覧を作り直す。
+func (mm matchModel) lobbyMsgHandler(msg MatchingMsg) (tea.Model, tea.Cmd) {
+	if msg.Data == common.SNAPSHOT {
+		mm.setLobby(msg.Lobby)
+		mm.seq, mm.synced = msg.Seq, true
+		return mm, nil
+	}
+	// SNAPSHOTを待っている間の変化や、SNAPSHOTに含まれている変化は反映しない。
+	if !mm.synced || msg.Seq <= mm.seq {
+		return mm, nil
+	}
+	if msg.Seq != mm.seq+1 {
+		mm.synced = false
+		mm.sendQueueMessage(common.SNAPSHOT)
+		return mm, nil
+	}
+	mm.seq = msg.Seq
+	if msg.Data == common.LEAVE {
+		mm.removePlayer(msg.Source.ID)
+	} else {
+		mm.updatePlayer(msg)
+	}
+	return mm, nil
+}
+
+// 自身を除くロビーの全員で一覧を作り直す。
+func (mm *matchModel) setLobby(lobby []*common.MatchingPlayer) {
+	me := shellgame.GetMyProfile()
+	var players []list.Item
+	for _, v := range lobby {
+		if me != nil && v.Profile.ID == me.ID {
+			continue
 		}
-		i++
+		players = append(players, Player(*v))
 	}
-	mm.list.InsertItem(i, p)
+	mm.list.SetItems(players)
 }
 
-func (mm *matchModel) removeProfile(p Profile) {
+// JOINで参加したプレイヤーを一覧の末尾に加え、STATUSで一覧にいるプレイヤーの状態を更新する。
+func (mm *matchModel) updatePlayer(msg MatchingMsg) {
+	p := Player{Profile: msg.Source, Status: common.WAITING}
+	if msg.Player != nil {
+		p = Player(*msg.Player)
+	}
+	if me := shellgame.GetMyProfile(); me != nil && p.Profile.ID == me.ID {
+		return
+	}
 	for i, v := range mm.list.Items() {
-		if v == nil {
+		if v.(Player).Profile.ID == p.Profile.ID {
+			mm.list.SetItem(i, p)
 			return
 		}
-		if v.(Profile).ID == p.ID {
+	}
+	if msg.Data == common.JOIN {
+		mm.list.InsertItem(len(mm.list.Items()), p)
+	}
+}
+
+func (mm *matchModel) removePlayer(id string) {
+	for i, v := range mm.list.Items() {
+		if v.(Player).Profile.ID == id {
 			mm.list.RemoveItem(i)
+			return
 		}
 	}
 }
 
-func (mm *matchModel) updateProfiles() error {
-	ps, err := shellgame.GetMatchingProfiles()
-	if err != nil {
-		return err
-	}
-	var profiles []list.Item
-	for _, v := range ps {
-		profiles = append(profiles, Profile(*v))
+// 接続し直すのを諦めた場合やサーバが接続を閉じた場合は、理由をロビーの選択画面に表示する。
+// 対戦を始めたり退室したりして閉じた古いSessionの通知は無視する。
+func (mm matchModel) disconnectedHandler(msg disconnectedMsg) (tea.Model, tea.Cmd) {
+	if msg.session != mm.session {
+		return mm, nil
 	}
-	mm.list.SetItems(profiles)
-	return nil
+	rm := mm.parent.rooms
+	rm.err = msg.err
+	return rm, screenChange("top")
 }
 
+// 切断された場合は自動で接続し直し、接続の状態を画面に通知する。
+// 前に参加したロビーで応答を受け取れなかったメッセージは再送しない。
+// 古いSessionのwritePumpにメッセージを取られないように、Sessionごとに送信用のチャネルを作る。
 func (mm *matchModel) createConn() error {
-	conn, err := shellgame.ConnectMatchingRoom()
+	room, code := mm.room, mm.code
+	mm.pending = shellgame.NewPending()
+	session, err := shellgame.NewSession(func() (*websocket.Conn, error) {
+		return shellgame.ConnectMatchingRoom(room, code)
+	}, mm.pending)
 	if err != nil {
 		return err
 	}
-	mm.conn = conn
+	session.OnStateChange(notifyState)
+	mm.session = session
+	mm.matchingChan = make(chan *MatchingMsg)
 	return nil
 }
 
 func (mm matchModel) matching() {
-	go mm.readPump()
+	go readPump(mm.session, mm.pending)
 	mm.writePump()
 }
 
-// mm.Update()から受け取ったメッセージをwebsocketに流す。
+// mm.Update()から受け取ったメッセージをwebsocketに流す。Sessionが閉じたら終了する。
 func (mm matchModel) writePump() {
 	ticker := time.NewTicker(10 * time.Second)
-	defer mm.conn.Close()
+	defer ticker.Stop()
 	for {
 		select {
-		case m, ok := <-mm.matchingChan:
-			if !ok {
-				return
-			}
-			if err := shellgame.WriteConn(mm.conn, m); err != nil {
+		case m := <-mm.matchingChan:
+			if err := mm.session.Write((*common.MatchingMessage)(m)); err != nil {
 				return
 			}
 		case <-ticker.C:
-			if err := mm.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+			if err := mm.session.Ping(); err != nil {
 				return
 			}
+		case <-mm.session.Done():
+			return
 		}
 	}
 }
 
-// websocketから受け取ったメッセージをmm.Update()に流す。
-func (mm matchModel) readPump() {
-	defer mm.conn.Close()
-	p := GetProgram()
-	for {
-		msg := &MatchingMsg{}
-		if err := shellgame.ReadConn(mm.conn, msg); err != nil {
-			return
-		}
-		p.Send(*msg)
-	}
+// Sessionが閉じている場合は送信しない。閉じた理由はreadPumpが通知する。
+func (mm matchModel) send(msg *MatchingMsg) {
+	send(mm.session, mm.matchingChan, msg)
 }
 
 func (mm matchModel) sendMatchingMessage(_dest Profile, data common.MatchingMessageData) {
@@ -225,5 +392,78 @@ func (mm matchModel) sendMatchingMessage(_dest Profile, data common.MatchingMess
 		Dest:   &dest,
 		Data:   data,
 	}
-	mm.matchingChan <- msg
+	mm.send(msg)
+}
+
+// 対戦相手を指定しないメッセージ(QUEUE, LEAVE_QUEUE, PARTY_LEAVE, SNAPSHOT)を送信する。
+func (mm matchModel) sendQueueMessage(data common.MatchingMessageData) {
+	mm.send(&MatchingMsg{
+		Source: shellgame.GetMyProfile(),
+		Data:   data,
+	})
+}
+
+// 対戦の形式を指定するメッセージ(OFFER, START)を送信する。STARTでは相手を指定しない。
+func (mm matchModel) sendBattleMessage(_dest *Profile, data common.MatchingMessageData, battle *common.Battle) {
+	msg := &MatchingMsg{
+		Source: shellgame.GetMyProfile(),
+		Data:   data,
+		Battle: battle,
+	}
+	if _dest != nil {
+		dest := common.Profile(*_dest)
+		msg.Dest = &dest
+	}
+	mm.send(msg)
+}
+
+// 対戦開始の通知を受け取ったらマッチングルームから退出し、対戦画面に移行する。
+func (mm matchModel) startBattle(msg MatchingMsg, from screenChangeMsg) (tea.Model, tea.Cmd) {
+	mm.session.Close()
+	mm.battle.battle = msg.Battle
+	return mm.battle, screenChange(from)
+}
+
+// 割り当て済みのプレイヤーであれば割り当てを取り消す。
+func (mm *matchModel) assign(p Profile) {
+	for i, v := range mm.assigned {
+		if v.ID == p.ID {
+			mm.assigned = append(mm.assigned[:i:i], mm.assigned[i+1:]...)
+			mm.setTitle()
+			return
+		}
+	}
+	mm.assigned = append(mm.assigned, p)
+	mm.setTitle()
+}
+
+// プライベートルームでは招待コードを、ホストには割り当てたプレイヤーも表示する。
+func (mm *matchModel) setTitle() {
+	title := "対戦相手を選択してください (r: ランダム対戦, p: パーティに招待, m: パーティ)"
+	if mm.code != "" {
+		title = fmt.Sprintf("招待コード: %s\n%s", mm.code, title)
+	}
+	if mm.host {
+		var names []string
+		for _, p := range mm.assigned {
+			names = append(names, p.Name)
+		}
+		title += fmt.Sprintf("\nホスト (a: 対戦に割り当て, s: 対戦開始) 割り当て: [%s]", strings.Join(names, ", "))
+	}
+	mm.list.Title = title
+}
+
+func inBattle(battle *common.Battle) bool {
+	me := shellgame.GetMyProfile()
+	if battle == nil || me == nil {
+		return false
+	}
+	for _, team := range battle.Teams {
+		for _, p := range team.Members {
+			if p.ID == me.ID {
+				return true
+			}
+		}
+	}
+	return false
 }
@@ -0,0 +1,128 @@
+package ui
+
+import (
+	"fmt"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	shellgame "github.com/taise-hub/shellgame-cli/client"
+	"github.com/taise-hub/shellgame-cli/common"
+	"strings"
+)
+
+const CHAT_HISTORY = 8 // チャット欄に表示する行数
+
+// 対戦相手の一覧の下に表示するチャット欄
+// tabでロビー全体に、wで選択しているプレイヤーのみにチャットを送信する。
+type matchChatModel struct {
+	input    textinput.Model
+	dest     *Profile // ダイレクトメッセージの宛先。ロビー全体へのチャットではnil
+	messages []string
+}
+
+func NewMatchChatModel() matchChatModel {
+	ti := textinput.New()
+	ti.CharLimit = 200
+	ti.Width = 60
+	return matchChatModel{input: ti}
+}
+
+func (cm matchChatModel) Update(msg tea.Msg, mm matchModel) (tea.Model, tea.Cmd) {
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch msg.String() {
+		case "ctrl+c":
+			return mm, tea.Quit
+		case "esc":
+			mm.chat.blur()
+			return mm, nil
+		case "enter":
+			text := strings.TrimSpace(cm.input.Value())
+			if text == "" {
+				return mm, nil
+			}
+			data := common.MatchingMessageData(common.CHAT)
+			if cm.dest != nil {
+				data = common.DIRECT_CHAT
+			}
+			// 送信できたかどうかはサーバから送り返されるチャットで確認する。
+			mm.sendChatMessage(cm.dest, data, text)
+			mm.chat.blur()
+			return mm, nil
+		}
+	}
+	var cmd tea.Cmd
+	mm.chat.input, cmd = cm.input.Update(msg)
+	return mm, cmd
+}
+
+func (cm matchChatModel) View() string {
+	var b strings.Builder
+	b.WriteString("\n  チャット (tab: ロビーに送信, w: 選択したプレイヤーに送信)\n")
+	for _, m := range cm.messages {
+		b.WriteString("  " + m + "\n")
+	}
+	if cm.focused() {
+		to := "ロビー"
+		if cm.dest != nil {
+			to = cm.dest.Name
+		}
+		b.WriteString(fmt.Sprintf("  %s へ %s\n", to, cm.input.View()))
+	}
+	return b.String()
+}
+
+// destがnilであればロビー全体へのチャットを入力する。
+func (cm *matchChatModel) focus(dest *Profile) tea.Cmd {
+	cm.dest = dest
+	cm.input.Reset()
+	cm.input.Focus()
+	return textinput.Blink
+}
+
+func (cm *matchChatModel) blur() {
+	cm.dest = nil
+	cm.input.Reset()
+	cm.input.Blur()
+}
+
+func (cm matchChatModel) focused() bool {
+	return cm.input.Focused()
+}
+
+// 受け取ったチャットを表示用に整形し、直近のCHAT_HISTORY行だけを残す。
+func (cm *matchChatModel) add(msg MatchingMsg) {
+	line := fmt.Sprintf("[%s] %s", msg.Source.Name, msg.Text)
+	if msg.Data == common.DIRECT_CHAT && msg.Dest != nil {
+		line = fmt.Sprintf("[%s → %s] %s", msg.Source.Name, msg.Dest.Name, msg.Text)
+	}
+	cm.append(line)
+}
+
+// サーバに拒否された理由を表示する。
+func (cm *matchChatModel) notice(reason string) {
+	cm.append("* " + reason)
+}
+
+func (cm *matchChatModel) append(line string) {
+	cm.messages = append(cm.messages, line)
+	if len(cm.messages) > CHAT_HISTORY {
+		cm.messages = cm.messages[len(cm.messages)-CHAT_HISTORY:]
+	}
+}
+
+func isChat(data common.MatchingMessageData) bool {
+	return data == common.CHAT || data == common.DIRECT_CHAT
+}
+
+// チャット(CHAT, DIRECT_CHAT)を送信する。ロビー全体へのチャットでは相手を指定しない。
+func (mm matchModel) sendChatMessage(_dest *Profile, data common.MatchingMessageData, text string) {
+	msg := &MatchingMsg{
+		Source: shellgame.GetMyProfile(),
+		Data:   data,
+		Text:   text,
+	}
+	if _dest != nil {
+		dest := common.Profile(*_dest)
+		msg.Dest = &dest
+	}
+	mm.send(msg)
+}
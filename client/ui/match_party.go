@@ -0,0 +1,105 @@
+package ui
+
+import (
+	"fmt"
+	tea "github.com/charmbracelet/bubbletea"
+	shellgame "github.com/taise-hub/shellgame-cli/client"
+	"github.com/taise-hub/shellgame-cli/common"
+	"strings"
+)
+
+// パーティの編成画面の実装
+// パーティのリーダーは対戦相手の選択画面から他のパーティのリーダーに対戦申請を送るとチーム戦になる。
+type matchPartyModel struct {
+	party      *common.Party // 参加しているパーティ。参加していない場合はnil
+	invitation *common.Party // 回答していない招待
+	shared     bool          // チーム戦でチームメイトとシェルを共有するかどうか
+	reason     string        // サーバから拒否された理由
+}
+
+func NewMatchPartyModel() matchPartyModel {
+	return matchPartyModel{}
+}
+
+func (pm matchPartyModel) Update(msg tea.Msg, mm matchModel) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case screenChangeMsg:
+	case MatchingMsg:
+		switch msg.Data {
+		case common.PARTY_INVITE:
+			mm.party.invitation = msg.Party
+		case common.PARTY: // パーティから外れた場合はPartyを含まない。
+			mm.party.party = msg.Party
+			mm.party.reason = ""
+		case common.ACCEPT, common.START:
+			return mm.startBattle(msg, "party")
+		case common.ERROR:
+			mm.party.reason = msg.reason()
+		}
+		return mm, nil
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "y":
+			if pm.invitation == nil {
+				return mm, nil
+			}
+			mm.sendMatchingMessage(Profile(*pm.invitation.Leader), common.PARTY_JOIN)
+			mm.party.invitation = nil
+		case "n":
+			mm.party.invitation = nil
+			if pm.party == nil {
+				mm.screen = ""
+				return mm, screenChange("party")
+			}
+		case "s":
+			if pm.isLeader() {
+				mm.sendBattleMessage(nil, common.START, &common.Battle{Mode: common.FREE_FOR_ALL})
+			}
+		case "t":
+			mm.party.shared = !pm.shared
+		case "l":
+			if pm.party != nil {
+				mm.sendQueueMessage(common.PARTY_LEAVE)
+			}
+		case "q":
+			mm.screen = ""
+			return mm, screenChange("party")
+		}
+	}
+	return mm, nil
+}
+
+func (pm matchPartyModel) View() string {
+	var b strings.Builder
+	if pm.invitation != nil && pm.party == nil {
+		b.WriteString(fmt.Sprintf("\n\n  %sからパーティに招待されました。\n\n  参加する → y\n  断る → n\n", pm.invitation.Leader.Name))
+		return b.String()
+	}
+	if pm.party == nil {
+		b.WriteString("\n\n  パーティに参加していません。\n  対戦相手の選択画面で p を押すと招待できます。\n")
+	} else {
+		b.WriteString(fmt.Sprintf("\n\n  パーティ (リーダー: %s)\n\n", pm.party.Leader.Name))
+		for _, p := range pm.party.Members {
+			b.WriteString(fmt.Sprintf("    %s\n", p.Name))
+		}
+	}
+	shared := "しない"
+	if pm.shared {
+		shared = "する"
+	}
+	b.WriteString(fmt.Sprintf("\n  チーム戦でシェルを共有: %s\n", shared))
+	if pm.reason != "" {
+		b.WriteString(fmt.Sprintf("\n  %s\n", pm.reason))
+	}
+	b.WriteString("\n")
+	if pm.isLeader() {
+		b.WriteString("  個人戦を開始 → s\n")
+	}
+	b.WriteString("  共有の切り替え → t\n  離脱 → l\n  戻る → q\n")
+	return b.String()
+}
+
+func (pm matchPartyModel) isLeader() bool {
+	me := shellgame.GetMyProfile()
+	return pm.party != nil && me != nil && pm.party.Leader.ID == me.ID
+}
@@ -0,0 +1,76 @@
+package ui
+
+import (
+	"fmt"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/taise-hub/shellgame-cli/common"
+	"time"
+)
+
+// ランダム対戦の相手を待つ画面の実装
+type matchQueueModel struct {
+	since   time.Time           // 待ち行列に参加した時刻
+	status  *common.QueueStatus // サーバから通知された順番と目安
+	updated time.Time           // statusを受け取った時刻
+}
+
+func NewMatchQueueModel() matchQueueModel {
+	return matchQueueModel{}
+}
+
+func (qm matchQueueModel) Update(msg tea.Msg, mm matchModel) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case screenChangeMsg:
+	case countdownMsg:
+		return mm, countdown()
+	case MatchingMsg:
+		switch msg.Data {
+		case common.QUEUE: // 参加や待ち行列の変化に応じてサーバから通知される順番と目安
+			first := mm.queue.since.IsZero()
+			if first {
+				mm.queue.since = time.Now()
+			}
+			mm.queue.status = msg.Queue
+			mm.queue.updated = time.Now()
+			if first {
+				return mm, countdown()
+			}
+			return mm, nil
+		case common.MATCHED:
+			return mm.startBattle(msg, "queue")
+		case common.LEAVE_QUEUE, common.ERROR:
+			if msg.Data == common.ERROR {
+				mm.chat.notice(msg.reason())
+			}
+			mm.screen = ""
+			return mm, screenChange("queue")
+		}
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "q":
+			mm.sendQueueMessage(common.LEAVE_QUEUE)
+			return mm, nil
+		}
+	}
+	return mm, nil
+}
+
+func (qm matchQueueModel) View() string {
+	if qm.since.IsZero() {
+		return "\n\n  通信中...\n\n"
+	}
+	d := time.Since(qm.since).Round(time.Second)
+	view := fmt.Sprintf("\n\n  対戦相手を探しています... (%d:%02d経過)\n", int(d.Minutes()), int(d.Seconds())%60)
+	if qm.status != nil {
+		view += fmt.Sprintf("\n  順番: %d / %d人", qm.status.Position, qm.status.Waiting)
+		if qm.status.Estimate >= 0 {
+			// 目安は通知された時点からの残り時間として表示する。
+			deadline := qm.updated.Add(time.Duration(qm.status.Estimate) * time.Second)
+			view += fmt.Sprintf("  (成立の目安: あと %s)", remaining(deadline))
+		} else {
+			view += "  (成立の目安: 不明)"
+		}
+		view += "\n"
+	}
+	return view + "\n  キャンセル → q\n"
+}
@@ -4,11 +4,21 @@ import (
 	"fmt"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/taise-hub/shellgame-cli/common"
+	"strings"
+	"time"
 )
 
+// 受け取った対戦申請
+type receivedOffer struct {
+	from     Profile
+	deadline time.Time // 対戦申請の回答期限
+}
+
 // 対戦要求を受けたり断ったりするときに利用するモデル
+// 複数のプレイヤーから受け取った申請を一覧にし、選択した申請に回答する。
 type matchReceivedModel struct {
-	from Profile
+	offers []receivedOffer
+	cursor int
 }
 
 func NewMatchRequestModel() matchReceivedModel {
@@ -18,28 +28,93 @@ func NewMatchRequestModel() matchReceivedModel {
 func (rm matchReceivedModel) Update(msg tea.Msg, mm matchModel) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case screenChangeMsg:
+	case countdownMsg:
+		return mm, countdown()
 	case MatchingMsg:
 		switch msg.Data {
-		case common.CANCEL_OFFER:
+		case common.OFFER:
+			mm.received.add(msg)
+			return mm, nil
+		case common.CANCEL_OFFER, common.OFFER_EXPIRED:
 			// TODO: キャンセルされたことを通達する画面を挟みたい。
-			mm.screen = ""
-			return mm, screenChange("received")
+			mm.received.remove(msg.Source.ID)
+			if len(mm.received.offers) == 0 {
+				mm.screen = ""
+				return mm, screenChange("received")
+			}
+			return mm, nil
 		}
 	case tea.KeyMsg:
 		switch msg.String() {
+		case "up", "k":
+			if mm.received.cursor > 0 {
+				mm.received.cursor--
+			}
+		case "down", "j":
+			if mm.received.cursor < len(mm.received.offers)-1 {
+				mm.received.cursor++
+			}
 		case "y":
-			mm.sendMatchingMessage(rm.from, common.ACCEPT)
+			// 承諾しなかった申請はサーバが断る。
+			mm.sendMatchingMessage(rm.selected().from, common.ACCEPT)
+			mm.received = NewMatchRequestModel()
+			mm.waits = NewMatchWaitModel(Profile{})
 			mm.screen = "waits"
 			return mm, screenChange("received")
 		case "n":
-			mm.sendMatchingMessage(rm.from, common.DENY)
-			mm.screen = ""
-			return mm, screenChange("received")
+			from := rm.selected().from
+			mm.sendMatchingMessage(from, common.DENY)
+			mm.received.remove(from.ID)
+			if len(mm.received.offers) == 0 {
+				mm.screen = ""
+				return mm, screenChange("received")
+			}
 		}
 	}
 	return mm, nil
 }
 
 func (rm matchReceivedModel) View() string {
-	return fmt.Sprintf("\n\n %sから対戦要求を受け取りました。\n\n  対戦する → y \n  断る → n\n", rm.from.Name)
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("\n\n %d件の対戦要求を受け取りました。\n\n", len(rm.offers)))
+	for i, o := range rm.offers {
+		cursor := "  "
+		if i == rm.cursor {
+			cursor = "> "
+		}
+		b.WriteString(fmt.Sprintf(" %s%s (回答期限まで %s)\n", cursor, o.from.Name, remaining(o.deadline)))
+	}
+	b.WriteString("\n  対戦する → y \n  断る → n\n")
+	return b.String()
+}
+
+// 同じプレイヤーから申請し直された場合は回答期限を更新する。
+func (rm *matchReceivedModel) add(msg MatchingMsg) {
+	o := receivedOffer{from: Profile(*msg.Source)}
+	if msg.Deadline != nil {
+		o.deadline = *msg.Deadline
+	}
+	for i, v := range rm.offers {
+		if v.from.ID == o.from.ID {
+			rm.offers[i] = o
+			return
+		}
+	}
+	rm.offers = append(rm.offers, o)
+}
+
+func (rm *matchReceivedModel) remove(id string) {
+	for i, v := range rm.offers {
+		if v.from.ID == id {
+			rm.offers = append(rm.offers[:i:i], rm.offers[i+1:]...)
+			break
+		}
+	}
+	if rm.cursor >= len(rm.offers) && rm.cursor > 0 {
+		rm.cursor = len(rm.offers) - 1
+	}
+}
+
+func (rm matchReceivedModel) selected() receivedOffer {
+	return rm.offers[rm.cursor]
 }
@@ -1,42 +1,58 @@
 package ui
 
 import (
+	"fmt"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/taise-hub/shellgame-cli/common"
+	"time"
 )
 
 // waitModelは通信待ち画面の実装
 type matchWaitModel struct {
+	dest     Profile   // 対戦を申請した相手。申請を承諾した場合は空。待っている間にロビーの一覧が変わっても、この相手への申請を取り消す。
+	deadline time.Time // 対戦申請の回答期限
 }
 
-func NewMatchWaitModel() matchWaitModel {
-	return matchWaitModel{}
+func NewMatchWaitModel(dest Profile) matchWaitModel {
+	return matchWaitModel{dest: dest}
 }
 
 func (wm matchWaitModel) Update(msg tea.Msg, mm matchModel) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case screenChangeMsg:
+	case countdownMsg:
+		return mm, countdown()
 	case MatchingMsg:
 		switch msg.Data {
+		case common.OFFER: // サーバから送り返された自身の対戦申請
+			if msg.Deadline != nil {
+				mm.waits.deadline = *msg.Deadline
+			}
+			return mm, countdown()
+		case common.OFFER_EXPIRED:
+			// TODO: 期限切れになったことを通達する画面を挟みたい。
+			mm.screen = ""
+			return mm, screenChange("waits")
 		case common.ACCEPT:
-			// battleModelに移行したい
-			mm.conn.Close()
-			return mm.battle, screenChange("wait")
+			return mm.startBattle(msg, "wait")
 		case common.DENY:
 			// TODO: キャンセルされたことを通達する画面を挟みたい。
 			mm.screen = ""
 			return mm, screenChange("waits")
 		case common.ERROR:
-			panic("対戦中なのでエラーです")
+			// 相手が交渉中・対戦中の場合など、申請が拒否された場合は理由を表示して対戦相手の選択に戻る。
+			mm.chat.notice(msg.reason())
+			mm.screen = ""
+			return mm, screenChange("waits")
 		}
 	case tea.KeyMsg:
 		switch msg.String() {
 		case "q":
-			dest, _ := mm.list.SelectedItem().(Profile)
-			if dest.ID == "" {
+			// 申請を承諾して対戦の開始を待っている場合は取り消す申請がない。
+			if mm.waits.dest.ID == "" {
 				return mm, screenChange("waits")
 			}
-			mm.sendMatchingMessage(dest, common.CANCEL_OFFER)
+			mm.sendMatchingMessage(mm.waits.dest, common.CANCEL_OFFER)
 			mm.screen = ""
 			return mm, screenChange("waits")
 		}
@@ -45,5 +61,8 @@ func (wm matchWaitModel) Update(msg tea.Msg, mm matchModel) (tea.Model, tea.Cmd)
 }
 
 func (wm matchWaitModel) View() string {
-	return "\n\n  通信中...\n\n"
+	if wm.deadline.IsZero() {
+		return "\n\n  通信中...\n\n"
+	}
+	return fmt.Sprintf("\n\n  通信中... (回答期限まで %s)\n\n", remaining(wm.deadline))
 }
@@ -1,8 +1,12 @@
 package ui
 
 import (
+	"errors"
+	"fmt"
 	"github.com/taise-hub/shellgame-cli/common"
 	tea "github.com/charmbracelet/bubbletea"
+	shellgame "github.com/taise-hub/shellgame-cli/client"
+	"time"
 )
 
 type errMsg struct{ err error }
@@ -18,4 +22,121 @@ func screenChange(from screenChangeMsg) tea.Cmd {
 	}
 }
 
-type MatchingMsg common.MatchingMessage 
\ No newline at end of file
+type MatchingMsg common.MatchingMessage
+
+// ACK, NACKを受け取った送信済みのメッセージを取り除き、画面に渡す必要があるかどうかを返す。
+// NACKは各画面でERRORと同じように扱えるようにERRORに置き換える。
+// 対戦を含むACKは、接続し直して再送した承諾や開始の結果を受け取れるように画面に渡す。
+func resolve(pending *shellgame.Pending, msg *MatchingMsg) bool {
+	switch msg.Data {
+	case common.ACK:
+		pending.Resolve(msg.ID)
+		return msg.Battle != nil
+	case common.NACK:
+		pending.Resolve(msg.ID)
+		msg.Data = common.ERROR
+	}
+	return true
+}
+
+// サーバが理由を送信しなかった場合に表示するERRORの説明
+var errorReasons = map[common.ErrorCode]string{
+	common.ERR_PLAYER_NOT_FOUND:    "相手のプレイヤーが見つかりません。",
+	common.ERR_ALREADY_NEGOTIATING: "対戦申請の交渉中のため応じられません。",
+	common.ERR_PLAYER_BUSY:         "相手は現在応じられません。",
+	common.ERR_OFFER_NOT_FOUND:     "対戦申請が見つかりません。",
+	common.ERR_OFFER_EXPIRED:       "対戦申請は回答期限を過ぎています。",
+	common.ERR_RATE_LIMITED:        "送信が多すぎます。しばらく待ってから送信してください。",
+	common.ERR_ROOM_FULL:           "ルームの定員に達しています。",
+	common.ERR_PARTY_FULL:          "パーティの人数が上限に達しています。",
+	common.ERR_NOT_PERMITTED:       "この操作は行えません。",
+	common.ERR_IDLE_TIMEOUT:        "しばらく操作がなかったため、ロビーから切断しました。",
+}
+
+// ERRORの理由。画面に表示する。
+func (msg MatchingMsg) reason() string {
+	if msg.Reason != "" {
+		return msg.Reason
+	} else if reason, ok := errorReasons[msg.Code]; ok {
+		return reason
+	}
+	return "エラーが発生しました。"
+}
+
+// ロビーや対戦チャンネルの接続の状態の変化を通知するメッセージ
+type sessionStateMsg shellgame.SessionState
+
+func notifyState(state shellgame.SessionState) {
+	GetProgram().Send(sessionStateMsg(state))
+}
+
+// 画面に表示する接続の状態。接続している場合は空
+func (msg sessionStateMsg) String() string {
+	switch {
+	case msg.Err != nil:
+		return fmt.Sprintf("切断されました: %v", msg.Err)
+	case msg.Reconnecting:
+		return fmt.Sprintf("再接続中... (%d/%d回目, %v後)", msg.Attempt, shellgame.DefaultBackoff.Attempts, msg.Wait.Round(time.Second))
+	}
+	return ""
+}
+
+// ロビーや対戦チャンネルのSessionが閉じ、以降は送受信できなくなったことを通知するメッセージ
+// 画面を移る際に閉じた古いSessionの通知を無視できるように、閉じたSessionを含める。
+type disconnectedMsg struct {
+	session *shellgame.Session
+	err     error
+}
+
+// Sessionが閉じるまでの間だけpumpにmsgを渡す。
+// 閉じたSessionのpumpは受け取らないため、Update()が止まらないように待たずにエラーを返す。
+func send(session *shellgame.Session, ch chan *MatchingMsg, msg *MatchingMsg) error {
+	select {
+	case ch <- msg:
+		return nil
+	case <-session.Done():
+		return shellgame.ErrSessionClosed
+	}
+}
+
+// Sessionから受信したメッセージをUpdate()に流し、Sessionが閉じたらdisconnectedMsgを流す。
+// サーバが接続を閉じる前に送信したエラーがあれば、その理由を閉じた理由とする。
+func readPump(session *shellgame.Session, pending *shellgame.Pending) {
+	p := GetProgram()
+	var final error
+	for {
+		msg := &MatchingMsg{}
+		if err := session.Read((*common.MatchingMessage)(msg)); err != nil {
+			if final != nil {
+				err = final
+			}
+			p.Send(disconnectedMsg{session: session, err: err})
+			return
+		}
+		if msg.Data == common.ERROR && shellgame.IsFinalError(msg.Code) {
+			final = errors.New(msg.reason())
+		}
+		if !resolve(pending, msg) {
+			continue
+		}
+		p.Send(*msg)
+	}
+}
+
+// 対戦申請の回答期限までの残り時間を更新するためのメッセージ
+type countdownMsg time.Time
+
+func countdown() tea.Cmd {
+	return tea.Tick(time.Second, func(t time.Time) tea.Msg {
+		return countdownMsg(t)
+	})
+}
+
+// 回答期限までの残り時間を表示用に整形する。
+func remaining(deadline time.Time) string {
+	d := time.Until(deadline).Round(time.Second)
+	if d < 0 {
+		d = 0
+	}
+	return fmt.Sprintf("%d:%02d", int(d.Minutes()), int(d.Seconds())%60)
+}
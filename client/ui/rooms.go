@@ -0,0 +1,107 @@
+package ui
+
+import (
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	shellgame "github.com/taise-hub/shellgame-cli/client"
+)
+
+// roomsModelは対戦待ちを行うロビー(マッチングルーム)を選択する画面の実装
+type roomsModel struct {
+	list   list.Model
+	screen screen
+	err    error // 直前に選択したロビーに接続できなかった理由
+
+	parent  *topModel
+	match   matchModel
+	private privateRoomModel
+}
+
+func NewRoomsModel() (roomsModel, error) {
+	l := list.New(nil, roomDelegate{}, width, 14)
+	l.Title = "ロビーを選択してください (c: プライベートルームを作成, j: 招待コードで参加)"
+	l.Styles.Title = titleStyle
+	l.SetShowStatusBar(false)
+	l.SetFilteringEnabled(false)
+	l.SetShowHelp(false)
+
+	mm, err := NewMatchModel()
+	if err != nil {
+		return roomsModel{}, err
+	}
+	return roomsModel{list: l, match: mm}, nil
+}
+
+func (rm roomsModel) Init() tea.Cmd {
+	return nil
+}
+
+func (rm roomsModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if rm.screen == "private" {
+		return rm.private.Update(msg, rm)
+	}
+	switch msg := msg.(type) {
+	case screenChangeMsg:
+		return rm.screenChangeHandler(msg)
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "ctrl+c":
+			return rm, tea.Quit
+		case "enter": // 選択したロビーで対戦相手を探す
+			room, ok := rm.list.SelectedItem().(Room)
+			if !ok {
+				return rm, nil
+			}
+			rm.err = nil
+			rm.match.room, rm.match.code, rm.match.host = room.Name, "", false
+			return rm.match, screenChange("rooms")
+		case "c":
+			rm.private = NewPrivateRoomModel(true)
+			rm.screen = "private"
+			return rm, nil
+		case "j":
+			rm.private = NewPrivateRoomModel(false)
+			rm.screen = "private"
+			return rm, textinput.Blink
+		case "q":
+			return rm.parent, screenChange("rooms")
+		}
+	}
+	var cmd tea.Cmd
+	rm.list, cmd = rm.list.Update(msg)
+	return rm, cmd
+}
+
+func (rm roomsModel) View() string {
+	if rm.screen == "private" {
+		return rm.private.View()
+	}
+	if rm.err != nil {
+		return "\n" + rm.list.View() + "\n\n  " + rm.err.Error() + "\n"
+	}
+	return "\n" + rm.list.View()
+}
+
+func (rm roomsModel) screenChangeHandler(msg screenChangeMsg) (tea.Model, tea.Cmd) {
+	switch msg {
+	case "top": // TOP画面からの遷移。現在稼働中のロビーを取得する。
+		if err := rm.updateRooms(); err != nil {
+			return roomsModel{}, tea.Quit
+		}
+	}
+	return rm, nil
+}
+
+func (rm *roomsModel) updateRooms() error {
+	rs, err := shellgame.GetRooms()
+	if err != nil {
+		return err
+	}
+	var rooms []list.Item
+	for _, v := range rs {
+		rooms = append(rooms, Room(*v))
+	}
+	rm.list.SetItems(rooms)
+	return nil
+}
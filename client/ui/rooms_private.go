@@ -0,0 +1,87 @@
+package ui
+
+import (
+	"fmt"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	shellgame "github.com/taise-hub/shellgame-cli/client"
+	"strings"
+)
+
+// プライベートルームの作成時に選ぶことのできる問題の組
+var questionSets = []string{"beginner", "advanced"}
+
+// privateRoomModelはプライベートルームを作成する、または招待コードで参加する画面の実装
+type privateRoomModel struct {
+	code     textinput.Model
+	creating bool // 作成する場合はtrue、招待コードで参加する場合はfalse
+	question int  // 作成するルームで出題する問題の組
+	err      error
+}
+
+func NewPrivateRoomModel(creating bool) privateRoomModel {
+	ti := textinput.New()
+	ti.CharLimit = 16
+	ti.Width = 20
+	ti.Focus()
+	return privateRoomModel{code: ti, creating: creating}
+}
+
+func (pm privateRoomModel) Update(msg tea.Msg, rm roomsModel) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "ctrl+c":
+			return rm, tea.Quit
+		case "esc":
+			rm.screen = ""
+			return rm, nil
+		case "tab":
+			if pm.creating {
+				rm.private.question = (pm.question + 1) % len(questionSets)
+			}
+			return rm, nil
+		case "enter":
+			if pm.creating {
+				room, err := shellgame.CreatePrivateRoom(questionSets[pm.question])
+				if err != nil {
+					rm.private.err = err
+					return rm, nil
+				}
+				rm.match.room, rm.match.code, rm.match.host = "", room.Code, true
+			} else {
+				code := strings.ToUpper(strings.TrimSpace(pm.code.Value()))
+				if code == "" {
+					return rm, nil
+				}
+				// 存在しない招待コードで対戦相手の選択画面に移らないように、先に参加者を取得できるか確認する。
+				if _, err := shellgame.GetMatchingPlayers("", code); err != nil {
+					rm.private.err = fmt.Errorf("招待コード %s のルームが見つかりません。", code)
+					return rm, nil
+				}
+				rm.match.room, rm.match.code, rm.match.host = "", code, false
+			}
+			rm.screen = ""
+			return rm.match, screenChange("rooms")
+		}
+	}
+	if pm.creating {
+		return rm, nil
+	}
+	var cmd tea.Cmd
+	rm.private.code, cmd = pm.code.Update(msg)
+	return rm, cmd
+}
+
+func (pm privateRoomModel) View() string {
+	var view string
+	if pm.creating {
+		view = fmt.Sprintf("\n\n  プライベートルームを作成します。\n\n  問題: %s\n\n  (tab: 問題の切り替え, enter: 作成, esc: 戻る)", questionSets[pm.question])
+	} else {
+		view = fmt.Sprintf("\n\n  招待コードを入力してください。\n\n  %s\n\n  (enter: 参加, esc: 戻る)", pm.code.View())
+	}
+	if pm.err != nil {
+		view += "\n\n  " + pm.err.Error()
+	}
+	return view + "\n"
+}
@@ -3,13 +3,14 @@ package ui
 import (
 	"github.com/charmbracelet/bubbles/list"
 	tea "github.com/charmbracelet/bubbletea"
+	shellgame "github.com/taise-hub/shellgame-cli/client"
 	"log"
 )
 
 type topModel struct {
 	screen  screen
 	screens list.Model
-	match   matchModel
+	rooms   roomsModel
 	help    helpModel
 }
 
@@ -28,7 +29,7 @@ func NewTopModel() topModel {
 	s.SetFilteringEnabled(false)
 	s.SetShowHelp(false)
 
-	mm, err := NewMatchModel()
+	rm, err := NewRoomsModel()
 	if err != nil {
 		log.Fatalf("%v\n", err.Error())
 	}
@@ -36,21 +37,22 @@ func NewTopModel() topModel {
 
 	m.screen = ""
 	m.screens = s
-	m.match = mm
+	m.rooms = rm
 	m.help = h
 
-	m.match.parent = &m // 子モデルであるMatchModelの親ポインタにこのモデルのアドレスを設定する
+	m.rooms.parent = &m       // 子モデルであるRoomsModelの親ポインタにこのモデルのアドレスを設定する
+	m.rooms.match.parent = &m // 対戦相手の選択画面からはTOP画面に戻る
 	return m
 }
 
 func (m topModel) Init() tea.Cmd {
-	return tea.Batch(m.match.Init())
+	return tea.Batch(m.rooms.Init())
 }
 
 func (tm topModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch tm.screen {
 	case "対戦":
-		return tm.match.Update(msg)
+		return tm.rooms.Update(msg)
 	case "ヘルプ":
 		return tm.help.Update(msg, tm)
 	default:
@@ -61,7 +63,7 @@ func (tm topModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 func (tm topModel) View() string {
 	switch tm.screen {
 	case "対戦":
-		return tm.match.View()
+		return tm.rooms.View()
 	case "ヘルプ":
 		return tm.help.View()
 	default:
@@ -85,6 +87,8 @@ func updateTop(msg tea.Msg, tm topModel) (tea.Model, tea.Cmd) {
 			}
 			tm.screen = screen(i)
 			if tm.screen == "終了" {
+				// ログアウトに失敗してもサーバ側のセッションが切れるだけなので終了する。
+				shellgame.DeleteProfile()
 				return tm, tea.Quit
 			}
 			return tm, screenChange("top")
@@ -1,17 +1,126 @@
 package common
 
+import (
+	"time"
+)
+
 type Profile struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Rating int    `json:"rating,omitempty"`
 }
 
-type Message interface {
+// 対戦待ちを行うロビー
+type Room struct {
+	Name    string `json:"name"`
+	Players int    `json:"players"`
 }
 
 type MatchingMessage struct {
-	Source *Profile            `json:"source"`
-	Dest   *Profile            `json:"dest"`
-	Data   MatchingMessageData `json:"data"`
+	ID       string              `json:"id,omitempty"` // クライアントが発行するメッセージのID。ACK, NACKでは応答したメッセージのID
+	Source   *Profile            `json:"source"`
+	Dest     *Profile            `json:"dest"`
+	Data     MatchingMessageData `json:"data"`
+	Code     ErrorCode           `json:"code,omitempty"`     // ERRORの種類
+	Reason   string              `json:"reason,omitempty"`   // ERRORの理由。そのままプレイヤーに表示できる。
+	Deadline *time.Time          `json:"deadline,omitempty"` // OFFERの回答期限、IDLE_WARNINGで切断される時刻。サーバが設定する。
+	Queue    *QueueStatus        `json:"queue,omitempty"`    // QUEUEで通知する待ち行列の状況。サーバが設定する。
+	Party    *Party              `json:"party,omitempty"`    // PARTY_INVITE, PARTYで通知するパーティの状態。サーバが設定する。
+	Battle   *Battle             `json:"battle,omitempty"`   // OFFER, STARTでは対戦の形式を指定する。対戦開始時にサーバが参加者を設定する。
+	Text     string              `json:"text,omitempty"`     // CHAT, DIRECT_CHAT, BATTLE_CHATの本文、EMOTEの種類
+	Player   *MatchingPlayer     `json:"player,omitempty"`   // JOIN, STATUSで通知するプレイヤーの状態。サーバが設定する。
+	Lobby    []*MatchingPlayer   `json:"lobby,omitempty"`    // SNAPSHOTで通知するロビーの全員の状態。サーバが設定する。
+	Seq      uint64              `json:"seq,omitempty"`      // JOIN, LEAVE, STATUS, SNAPSHOTの通し番号。サーバが設定する。
+}
+
+// ERRORの種類
+type ErrorCode string
+
+const (
+	ERR_INVALID_MESSAGE     ErrorCode = "invalid_message"     // 形式が正しくない、または送信できないメッセージ
+	ERR_PLAYER_NOT_FOUND    ErrorCode = "player_not_found"    // 指定したプレイヤーがルームや対戦にいない
+	ERR_ALREADY_NEGOTIATING ErrorCode = "already_negotiating" // 送信者か相手が既に対戦申請の交渉中
+	ERR_PLAYER_BUSY         ErrorCode = "player_busy"         // 送信者か相手が対戦中、待ち行列に並んでいる等で応じられない
+	ERR_OFFER_NOT_FOUND     ErrorCode = "offer_not_found"     // 回答や取り消しをしようとした対戦申請がない
+	ERR_OFFER_EXPIRED       ErrorCode = "offer_expired"       // 対戦申請の回答期限を過ぎている
+	ERR_RATE_LIMITED        ErrorCode = "rate_limited"        // 短い間に送信しすぎている
+	ERR_ROOM_FULL           ErrorCode = "room_full"           // ルームの定員に達している
+	ERR_PARTY_FULL          ErrorCode = "party_full"          // パーティの人数が上限に達している
+	ERR_NOT_PERMITTED       ErrorCode = "not_permitted"       // ホストやパーティのリーダーのみができる操作
+	ERR_INVALID_BATTLE      ErrorCode = "invalid_battle"      // 対戦の形式と参加者が合わない
+	ERR_INVALID_CHAT        ErrorCode = "invalid_chat"        // チャットやエモートの内容が正しくない
+	ERR_IDLE_TIMEOUT        ErrorCode = "idle_timeout"        // 操作がなかったためロビーから切断された
+	ERR_INTERNAL            ErrorCode = "internal"            // サーバの内部のエラー
+)
+
+// ロビーでのプレイヤーの状態
+type MatchingStatus string
+
+const (
+	WAITING     MatchingStatus = "waiting"     // 対戦相手を探している
+	NEGOTIATING MatchingStatus = "negotiating" // 対戦申請を送信して回答を待っている
+	IN_BATTLE   MatchingStatus = "in_battle"   // 対戦中
+	QUEUED      MatchingStatus = "queued"      // ランダム対戦の相手を待っている
+	IN_PARTY    MatchingStatus = "in_party"    // パーティのメンバーとしてリーダーの対戦開始を待っている
+	AWAY        MatchingStatus = "away"        // しばらく操作していない
+)
+
+// ロビーにいるプレイヤーと、その状態
+type MatchingPlayer struct {
+	Profile *Profile       `json:"profile"`
+	Status  MatchingStatus `json:"status"`
+	Joined  time.Time      `json:"joined"`         // ロビーに参加した時刻
+	Conn    string         `json:"conn,omitempty"` // JOIN, LEAVE, RESUME等でプレイヤーの接続を識別する。接続し直すたびに変わる。サーバ間でのみ利用する。
+}
+
+// 対戦申請やランダム対戦に一緒に参加するプレイヤーの集まり
+type Party struct {
+	Leader  *Profile   `json:"leader"`
+	Members []*Profile `json:"members"` // 先頭はリーダー
+}
+
+type BattleMode uint8
+
+const (
+	DUEL         BattleMode = iota // 1対1
+	TEAM                           // パーティ同士のチーム戦
+	FREE_FOR_ALL                   // パーティ内の全員による個人戦
+)
+
+// 対戦の形式と参加者
+// チーム戦以外では一人ずつのチームとして扱い、得点はチームごとに集計する。
+type Battle struct {
+	ID          string        `json:"id"`
+	Mode        BattleMode    `json:"mode"`
+	Teams       []*Team       `json:"teams,omitempty"`
+	SharedShell bool          `json:"shared_shell,omitempty"` // チームメイトで同じコンテナを共有するかどうか
+	QuestionSet string        `json:"question_set,omitempty"` // 出題する問題の組
+	Chat        []*BattleChat `json:"chat,omitempty"`         // 対戦中に送信されたチャットとエモートの記録
+}
+
+// 対戦中に送信されたチャットまたはエモート
+type BattleChat struct {
+	Source *Profile `json:"source"`
+	Emote  bool     `json:"emote,omitempty"`
+	Text   string   `json:"text"` // エモートではエモートの種類
+}
+
+// 招待コードを知っているプレイヤーだけが参加できるロビー
+type PrivateRoom struct {
+	Code        string `json:"code"`
+	QuestionSet string `json:"question_set"`
+}
+
+type Team struct {
+	Members []*Profile `json:"members"`
+	Score   int        `json:"score"`
+}
+
+// ランダム対戦の待ち行列での順番と、組み合わせが成立するまでの目安
+type QueueStatus struct {
+	Position int `json:"position"` // 1から始まる順番
+	Waiting  int `json:"waiting"`  // 待ち行列に並んでいる人数
+	Estimate int `json:"estimate"` // 組み合わせが成立するまでの目安(秒)。見積もれない場合は-1
 }
 
 type MatchingMessageData uint8
@@ -24,4 +133,26 @@ const (
 	ERROR
 	JOIN
 	LEAVE
+	OFFER_EXPIRED
+	QUEUE        // ランダム対戦の待ち行列への参加
+	LEAVE_QUEUE  // ランダム対戦の待ち行列からの離脱
+	MATCHED      // ランダム対戦の組み合わせの成立。サーバが発行する。
+	PARTY_INVITE // パーティへの招待
+	PARTY_JOIN   // 招待されたパーティへの参加
+	PARTY_LEAVE  // パーティからの離脱。リーダーが離脱した場合は解散する。
+	PARTY        // パーティの状態の通知。サーバが発行する。
+	START        // パーティのリーダーによる個人戦、またはプライベートルームのホストが組み合わせを指定した対戦の開始
+	CHAT         // ロビーの全員へのチャット
+	DIRECT_CHAT  // 指定したプレイヤー(Dest)のみへのチャット
+	BATTLE_CHAT  // 対戦の参加者全員へのチャット。Battle.IDで対戦を指定する。
+	EMOTE        // 対戦の参加者全員へのエモート。Textでエモートの種類を指定する。
+	MUTE         // 対戦中に指定したプレイヤー(Dest)のチャットとエモートを受け取らないようにする。
+	UNMUTE       // MUTEの取り消し
+	STATUS       // プレイヤーの状態の変化の通知。サーバが発行する。
+	SNAPSHOT     // ロビーの全員の状態。参加した直後と、クライアントが要求した場合にサーバが送信する。
+	ACK          // IDのあるメッセージを処理したことの通知。サーバが発行する。対戦を開始した場合はBattleを含む。
+	NACK         // IDのあるメッセージを処理できなかったことの通知。Code, Reasonで理由を示す。サーバが発行する。
+	IDLE_WARNING // 操作がないためロビーから切断されることの予告。Deadlineに切断される時刻を含む。サーバが発行する。
+	SYNC         // 購読を開始したサーバによるロビーの状態の要求。各サーバは自身に接続しているプレイヤーの状態をSNAPSHOTで発行する。サーバ間でのみ発行する。
+	RESUME       // 接続し直したプレイヤーによる状態の引き継ぎ。以前の接続は退室させずに閉じる。サーバ間でのみ発行する。
 )
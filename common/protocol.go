@@ -0,0 +1,189 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	PROTOCOL_VERSION     = 1             // このクライアントとサーバが話すプロトコルのバージョン
+	MIN_PROTOCOL_VERSION = 1             // サーバが受け入れる最も古いクライアントのバージョン
+	SUBPROTOCOL_PREFIX   = "shellgame.v" // WebSocketのサブプロトコル名の接頭辞。バージョンを続ける。
+)
+
+var (
+	ErrIncompatibleVersion = errors.New("incompatible protocol version")
+)
+
+// ロビー、対戦チャンネル、シェルの各チャンネルでやり取りするメッセージの種類
+type MessageType string
+
+const (
+	REJECT MessageType = "reject" // 接続時のハンドシェイクの拒否。サーバが発行する。
+)
+
+// メッセージの種類ごとのペイロードが実装する。
+type Message interface {
+	MessageType() MessageType
+	MessageID() string // 送信者が発行したID。IDを持たない場合は空
+}
+
+// 全てのチャンネルで共通のメッセージの外形
+// Payloadの中身はTypeで決まる。
+type Envelope struct {
+	Type    MessageType     `json:"type"`
+	Version int             `json:"version"`
+	ID      string          `json:"id,omitempty"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// msgをこのバージョンのEnvelopeに詰める。
+func Wrap(msg Message) (*Envelope, error) {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return &Envelope{Type: msg.MessageType(), Version: PROTOCOL_VERSION, ID: msg.MessageID(), Payload: payload}, nil
+}
+
+// Payloadをmsgに取り出す。対応していないバージョンや、msgと種類が異なる場合はエラーを返す。
+func (e *Envelope) Unwrap(msg Message) error {
+	if !IsCompatible(e.Version) {
+		return fmt.Errorf("%w: v%d", ErrIncompatibleVersion, e.Version)
+	}
+	if err := json.Unmarshal(e.Payload, msg); err != nil {
+		return err
+	}
+	if msg.MessageType() != e.Type {
+		return fmt.Errorf("unexpected message type: %s", e.Type)
+	} else if msg.MessageID() != e.ID {
+		return fmt.Errorf("unexpected message id: %s", e.ID)
+	}
+	return nil
+}
+
+func IsCompatible(version int) bool {
+	return MIN_PROTOCOL_VERSION <= version && version <= PROTOCOL_VERSION
+}
+
+// WebSocketのサブプロトコル名
+func Subprotocol(version int) string {
+	return SUBPROTOCOL_PREFIX + strconv.Itoa(version)
+}
+
+// クライアントが提示したサブプロトコルから、対応している最も新しいバージョンを選ぶ。
+// 対応しているものがない場合はクライアントに表示する理由を添えたRejectionを返す。
+func Negotiate(subprotocols []string) (int, *Rejection) {
+	selected, offered, found := 0, 0, false
+	for _, s := range subprotocols {
+		s = strings.TrimSpace(s)
+		if !strings.HasPrefix(s, SUBPROTOCOL_PREFIX) {
+			continue
+		}
+		v, err := strconv.Atoi(strings.TrimPrefix(s, SUBPROTOCOL_PREFIX))
+		if err != nil {
+			continue
+		}
+		if !found || v > offered {
+			offered, found = v, true
+		}
+		if IsCompatible(v) && v > selected {
+			selected = v
+		}
+	}
+	if selected > 0 {
+		return selected, nil
+	}
+	r := &Rejection{Version: PROTOCOL_VERSION, MinVersion: MIN_PROTOCOL_VERSION}
+	switch {
+	case !found:
+		r.Reason = "クライアントのプロトコルのバージョンが不明です。クライアントを更新してください。"
+	case offered < MIN_PROTOCOL_VERSION:
+		r.Reason = fmt.Sprintf("クライアントのプロトコルのバージョン(v%d)は古すぎます。v%d以上に対応したクライアントに更新してください。", offered, MIN_PROTOCOL_VERSION)
+	default:
+		r.Reason = fmt.Sprintf("クライアントのプロトコルのバージョン(v%d)にサーバが対応していません。v%dまでに対応したクライアントを利用してください。", offered, PROTOCOL_VERSION)
+	}
+	return 0, r
+}
+
+// ハンドシェイクを拒否した理由と、サーバが対応しているバージョンの範囲
+// シェルのチャンネルを含む全てのチャンネルで、接続の応答の本文として返す。
+type Rejection struct {
+	Reason     string `json:"reason"`
+	Version    int    `json:"version"`
+	MinVersion int    `json:"min_version"`
+}
+
+func (r *Rejection) MessageType() MessageType {
+	return REJECT
+}
+
+func (r *Rejection) MessageID() string {
+	return ""
+}
+
+func (r *Rejection) Error() string {
+	return r.Reason
+}
+
+// マッチングのメッセージの種類。Dataの名前を用いる。
+func (m *MatchingMessage) MessageType() MessageType {
+	return MessageType(m.Data.String())
+}
+
+func (m *MatchingMessage) MessageID() string {
+	return m.ID
+}
+
+var matchingMessageNames = map[MatchingMessageData]string{
+	OFFER:         "offer",
+	CANCEL_OFFER:  "cancel_offer",
+	ACCEPT:        "accept",
+	DENY:          "deny",
+	ERROR:         "error",
+	JOIN:          "join",
+	LEAVE:         "leave",
+	OFFER_EXPIRED: "offer_expired",
+	QUEUE:         "queue",
+	LEAVE_QUEUE:   "leave_queue",
+	MATCHED:       "matched",
+	PARTY_INVITE:  "party_invite",
+	PARTY_JOIN:    "party_join",
+	PARTY_LEAVE:   "party_leave",
+	PARTY:         "party",
+	START:         "start",
+	CHAT:          "chat",
+	DIRECT_CHAT:   "direct_chat",
+	BATTLE_CHAT:   "battle_chat",
+	EMOTE:         "emote",
+	MUTE:          "mute",
+	UNMUTE:        "unmute",
+	STATUS:        "status",
+	SNAPSHOT:      "snapshot",
+	ACK:           "ack",
+	NACK:          "nack",
+	IDLE_WARNING:  "idle_warning",
+	SYNC:          "sync",
+	RESUME:        "resume",
+}
+
+func (d MatchingMessageData) String() string {
+	if name, ok := matchingMessageNames[d]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", uint8(d))
+}
+
+// 定義されている全ての種類を返す。
+func MatchingMessageDataList() []MatchingMessageData {
+	list := make([]MatchingMessageData, 0, len(matchingMessageNames))
+	for d := MatchingMessageData(OFFER); ; d++ {
+		if _, ok := matchingMessageNames[d]; !ok {
+			return list
+		}
+		list = append(list, d)
+	}
+}
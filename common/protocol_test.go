@@ -0,0 +1,148 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Envelopeに詰めてJSONで送受信し、取り出したメッセージを返す。
+func roundTrip(t *testing.T, msg Message, decoded Message) *Envelope {
+	t.Helper()
+	env, err := Wrap(msg)
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+	b, err := json.Marshal(env)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	received := &Envelope{}
+	if err := json.Unmarshal(b, received); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if err := received.Unwrap(decoded); err != nil {
+		t.Fatalf("Unwrap: %v", err)
+	}
+	return received
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	deadline := time.Date(2022, 4, 1, 12, 0, 0, 0, time.UTC)
+	source := &Profile{ID: "0", Name: "bob", Rating: 1500}
+	dest := &Profile{ID: "1", Name: "alice"}
+	player := &MatchingPlayer{Profile: source, Status: WAITING, Joined: deadline}
+	for _, data := range MatchingMessageDataList() {
+		t.Run(data.String()+"はEnvelopeを経由しても同じ内容で受け取れる。", func(t *testing.T) {
+			msg := &MatchingMessage{
+				ID:       "42",
+				Source:   source,
+				Dest:     dest,
+				Data:     data,
+				Code:     ERR_INTERNAL,
+				Reason:   "理由",
+				Deadline: &deadline,
+				Queue:    &QueueStatus{Position: 1, Waiting: 2, Estimate: -1},
+				Party:    &Party{Leader: source, Members: []*Profile{source, dest}},
+				Battle:   &Battle{ID: "b", Mode: TEAM, Teams: []*Team{{Members: []*Profile{source}, Score: 3}}, Chat: []*BattleChat{{Source: source, Emote: true, Text: "gg"}}},
+				Text:     "こんにちは",
+				Player:   player,
+				Lobby:    []*MatchingPlayer{player},
+				Seq:      7,
+			}
+			actual := &MatchingMessage{}
+			env := roundTrip(t, msg, actual)
+			if env.Type != MessageType(data.String()) || env.Version != PROTOCOL_VERSION || env.ID != "42" {
+				t.Errorf("Expected: %s v%d 42\n\t\t Actual: %s v%d %s \n", data, PROTOCOL_VERSION, env.Type, env.Version, env.ID)
+			}
+			if !reflect.DeepEqual(msg, actual) {
+				t.Errorf("Expected: %+v\n\t\t Actual: %+v \n", msg, actual)
+			}
+		})
+	}
+	t.Run("rejectはEnvelopeを経由しても同じ内容で受け取れる。", func(t *testing.T) {
+		_, msg := Negotiate(nil)
+		actual := &Rejection{}
+		if env := roundTrip(t, msg, actual); env.Type != REJECT || env.ID != "" {
+			t.Errorf("Expected: %s\n\t\t Actual: %s %s \n", REJECT, env.Type, env.ID)
+		}
+		if !reflect.DeepEqual(msg, actual) {
+			t.Errorf("Expected: %+v\n\t\t Actual: %+v \n", msg, actual)
+		}
+	})
+}
+
+func TestMatchingMessageDataList(t *testing.T) {
+	list := MatchingMessageDataList()
+	if len(list) != len(matchingMessageNames) || list[len(list)-1] != RESUME {
+		t.Errorf("Expected: %d types up to %s\n\t\t Actual: %v \n", len(matchingMessageNames), MatchingMessageData(RESUME), list)
+	}
+	names := map[string]bool{}
+	for _, data := range list {
+		if names[data.String()] {
+			t.Errorf("Expected: unique name\n\t\t Actual: %s \n", data)
+		}
+		names[data.String()] = true
+	}
+}
+
+func TestEnvelopeUnwrap(t *testing.T) {
+	payload, _ := json.Marshal(&MatchingMessage{Data: OFFER})
+	tests := []struct {
+		name     string
+		envelope *Envelope
+		err      bool
+	}{
+		{name: "対応しているバージョンは受け取れる。", envelope: &Envelope{Type: "offer", Version: PROTOCOL_VERSION, Payload: payload}},
+		{name: "新しすぎるバージョンは拒否する。", envelope: &Envelope{Type: "offer", Version: PROTOCOL_VERSION + 1, Payload: payload}, err: true},
+		{name: "古すぎるバージョンは拒否する。", envelope: &Envelope{Type: "offer", Version: MIN_PROTOCOL_VERSION - 1, Payload: payload}, err: true},
+		{name: "種類とペイロードが一致しない場合は拒否する。", envelope: &Envelope{Type: "accept", Version: PROTOCOL_VERSION, Payload: payload}, err: true},
+		{name: "IDとペイロードが一致しない場合は拒否する。", envelope: &Envelope{Type: "offer", Version: PROTOCOL_VERSION, ID: "42", Payload: payload}, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.envelope.Unwrap(&MatchingMessage{})
+			if (err != nil) != tt.err {
+				t.Errorf("Expected: %v\n\t\t Actual: %v \n", tt.err, err)
+			}
+		})
+	}
+	t.Run("バージョンが異なる場合はErrIncompatibleVersionを返す。", func(t *testing.T) {
+		err := (&Envelope{Type: "offer", Version: PROTOCOL_VERSION + 1, Payload: payload}).Unwrap(&MatchingMessage{})
+		if !errors.Is(err, ErrIncompatibleVersion) {
+			t.Errorf("Expected: %v\n\t\t Actual: %v \n", ErrIncompatibleVersion, err)
+		}
+	})
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name         string
+		subprotocols []string
+		expected     int
+		reason       string
+	}{
+		{name: "対応しているバージョンを選ぶ。", subprotocols: []string{Subprotocol(PROTOCOL_VERSION)}, expected: PROTOCOL_VERSION},
+		{name: "新しいバージョンと併せて提示された場合は対応しているものを選ぶ。", subprotocols: []string{Subprotocol(PROTOCOL_VERSION + 1), Subprotocol(PROTOCOL_VERSION)}, expected: PROTOCOL_VERSION},
+		{name: "バージョンを提示しない古いクライアントは拒否する。", subprotocols: nil, reason: "不明"},
+		{name: "関係のないサブプロトコルは無視する。", subprotocols: []string{"chat", "shellgame.vx"}, reason: "不明"},
+		{name: "古すぎるクライアントは更新を促す。", subprotocols: []string{Subprotocol(MIN_PROTOCOL_VERSION - 1)}, reason: "古すぎます"},
+		{name: "新しすぎるクライアントは拒否する。", subprotocols: []string{Subprotocol(PROTOCOL_VERSION + 1)}, reason: "サーバが対応していません"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, rejection := Negotiate(tt.subprotocols)
+			if actual != tt.expected {
+				t.Errorf("Expected: %d\n\t\t Actual: %d \n", tt.expected, actual)
+			}
+			if tt.reason == "" && rejection != nil {
+				t.Errorf("Expected: nil\n\t\t Actual: %v \n", rejection)
+			} else if tt.reason != "" && (rejection == nil || !strings.Contains(rejection.Reason, tt.reason)) {
+				t.Errorf("Expected: %s\n\t\t Actual: %v \n", tt.reason, rejection)
+			}
+		})
+	}
+}
@@ -7,6 +7,9 @@ import (
 	"github.com/taise-hub/shellgame-cli/server/usecase"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 )
 
 func main() {
@@ -16,17 +19,91 @@ func main() {
 		return
 	}
 	consoleRepo := interfaces.NewContainerRepository(containerHandler)
-	gameUsecase := usecase.NewGameInteractor(consoleRepo)
-	gameController := interfaces.NewGameController(gameUsecase)
+	handler := newStoreHandler()
+	matchingRoomRepo := interfaces.NewMatchingRoomRepository(handler)
+	matchingEventBus := interfaces.NewMatchingEventBus(handler)
+	privateRoomRepo := interfaces.NewPrivateRoomRepository(handler)
+	gameUsecase := usecase.NewGameInteractor(consoleRepo, matchingRoomRepo, matchingEventBus, privateRoomRepo)
+	sessionStore := newSessionStore(handler)
+	gameController := interfaces.NewGameController(gameUsecase, sessionStore)
+	profileRepo := interfaces.NewProfileRepository(handler)
+	accountRepo := interfaces.NewAccountRepository(handler)
+	challengeRepo := interfaces.NewChallengeRepository(handler)
+	profileUsecase := usecase.NewProfileInteractor(profileRepo, accountRepo)
+	profileController := interfaces.NewProfileController(profileUsecase, sessionStore)
+	accountUsecase := usecase.NewAccountInteractor(accountRepo, challengeRepo, profileRepo)
+	accountController := interfaces.NewAccountController(accountUsecase, profileUsecase, sessionStore)
 
-	go model.GetMatchingRoom().Run()
+	if spec := os.Getenv("MATCHMAKING_WINDOW"); spec != "" {
+		window, err := model.ParseRatingWindow(spec)
+		if err != nil {
+			log.Fatal(err)
+		}
+		gameUsecase.SetRatingWindow(window)
+	}
+	if spec := os.Getenv("LOBBY_IDLE"); spec != "" {
+		policy, err := model.ParseIdlePolicy(spec)
+		if err != nil {
+			log.Fatal(err)
+		}
+		gameUsecase.SetIdlePolicy(policy)
+	}
+	if spec := os.Getenv("ROOM_CAPACITY"); spec != "" {
+		capacity, err := strconv.Atoi(spec)
+		if err != nil || capacity <= 0 {
+			log.Fatalf("invalid ROOM_CAPACITY: %s", spec)
+		}
+		gameUsecase.SetRoomCapacity(capacity)
+	}
+	for _, name := range roomNames() {
+		if err := gameUsecase.OpenRoom(name); err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/profiles", gameController.Profile)
+	mux.HandleFunc("/profiles", profileController.Profile)
+	mux.HandleFunc("/accounts", accountController.Accounts)
+	mux.HandleFunc("/login", accountController.Login)
+	mux.HandleFunc("/login/challenge", accountController.Challenge)
+	mux.HandleFunc("/rooms", gameController.Rooms)
+	mux.HandleFunc("/private-rooms", gameController.PrivateRooms)
 	mux.HandleFunc("/players", gameController.Match)
 	mux.HandleFunc("/waitmatch", gameController.WaitMatch)
 	mux.HandleFunc("/shell", gameController.Start)
+	mux.HandleFunc("/battle", gameController.Battle)
+	mux.HandleFunc("/battle/channel", gameController.BattleChannel)
 
 	log.Println("[+] Start listening.")
 	http.ListenAndServe(":80", mux)
 }
+
+type storeHandler interface {
+	interfaces.SetHandler
+	interfaces.HashHandler
+	interfaces.StringHandler
+	interfaces.PubSubHandler
+}
+
+// REDIS_ADDRが設定されている場合はRedisを、そうでない場合はインメモリでマッチングルームの状態を保持する。
+// Redisを利用した場合、同じRedisに接続している全てのサーバでマッチングルームを共有する。
+func newStoreHandler() storeHandler {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		return infrastructure.NewMemoryHandler()
+	}
+	h, err := infrastructure.NewRedisHandler(addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return h
+}
+
+// MATCHING_ROOMSにカンマ区切りでマッチングルーム名を指定する。
+func roomNames() []string {
+	names := os.Getenv("MATCHING_ROOMS")
+	if names == "" {
+		return []string{usecase.DEFAULT_ROOM, "advanced"}
+	}
+	return strings.Split(names, ",")
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/taise-hub/shellgame-cli/server/interfaces"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	DEFAULT_SESSION_MAX_AGE = 86400 // 1日
+)
+
+// SESSION_STOREにredisを指定した場合はセッションの値をRedisに、そうでない場合はクッキーに保持する。
+// 複数のサーバで運用する場合でも、SESSION_KEYSを揃えればどちらの方式でもセッションを共有できる。
+func newSessionStore(handler interfaces.StringHandler) sessions.Store {
+	keyPairs, err := sessionKeyPairs()
+	if err != nil {
+		log.Fatal(err)
+	}
+	maxAge, err := sessionMaxAge()
+	if err != nil {
+		log.Fatal(err)
+	}
+	switch os.Getenv("SESSION_STORE") {
+	case "", "cookie":
+		store := sessions.NewCookieStore(keyPairs...)
+		store.Options.HttpOnly = true
+		store.MaxAge(maxAge)
+		return store
+	case "redis":
+		if os.Getenv("REDIS_ADDR") == "" {
+			log.Fatal("SESSION_STORE=redis requires REDIS_ADDR")
+		}
+		return interfaces.NewKVSSessionStore(handler, maxAge, keyPairs...)
+	default:
+		log.Fatalf("unknown SESSION_STORE: %s", os.Getenv("SESSION_STORE"))
+	}
+	return nil
+}
+
+// SESSION_KEYSには「ハッシュキー:ブロックキー」をbase64でエンコードしてカンマ区切りで指定する。(ブロックキーは省略可)
+// 先頭の鍵で署名・暗号化を行い、以降の鍵はローテーション前に発行されたクッキーの検証に利用する。
+// 指定がない場合は起動ごとに鍵を生成するため、再起動するとセッションは全て無効になる。
+func sessionKeyPairs() ([][]byte, error) {
+	keys := os.Getenv("SESSION_KEYS")
+	if keys == "" {
+		log.Println("[!] SESSION_KEYS is not set. Sessions will be invalidated on restart.")
+		return [][]byte{securecookie.GenerateRandomKey(64), securecookie.GenerateRandomKey(32)}, nil
+	}
+	var pairs [][]byte
+	for _, pair := range strings.Split(keys, ",") {
+		hashKey, blockKey, _ := strings.Cut(strings.TrimSpace(pair), ":")
+		hash, err := base64.StdEncoding.DecodeString(hashKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hash key in SESSION_KEYS: %w", err)
+		}
+		if len(hash) < 32 {
+			return nil, fmt.Errorf("hash key in SESSION_KEYS must be at least 32 bytes")
+		}
+		var block []byte
+		if blockKey != "" {
+			if block, err = base64.StdEncoding.DecodeString(blockKey); err != nil {
+				return nil, fmt.Errorf("invalid block key in SESSION_KEYS: %w", err)
+			}
+			if l := len(block); l != 16 && l != 24 && l != 32 {
+				return nil, fmt.Errorf("block key in SESSION_KEYS must be 16, 24 or 32 bytes")
+			}
+		}
+		pairs = append(pairs, hash, block)
+	}
+	return pairs, nil
+}
+
+// SESSION_MAX_AGEにセッションの有効期限を秒で指定する。
+func sessionMaxAge() (int, error) {
+	v := os.Getenv("SESSION_MAX_AGE")
+	if v == "" {
+		return DEFAULT_SESSION_MAX_AGE, nil
+	}
+	maxAge, err := strconv.Atoi(v)
+	if err != nil || maxAge <= 0 {
+		return 0, fmt.Errorf("invalid SESSION_MAX_AGE: %s", v)
+	}
+	return maxAge, nil
+}
@@ -0,0 +1,67 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	MIN_PASSWORD_LENGTH = 8
+	MAX_PASSWORD_LENGTH = 72 // bcryptはこれを超える部分を無視する。
+)
+
+var (
+	ErrPasswordTooShort     = fmt.Errorf("パスワードは%d文字以上で入力してください。", MIN_PASSWORD_LENGTH)
+	ErrPasswordTooLong      = errors.New("パスワードが長すぎます。")
+	ErrInvalidPublicKey     = errors.New("SSH公開鍵の形式が正しくありません。")
+	ErrNoCredential         = errors.New("パスワードかSSH公開鍵を指定してください。")
+	ErrAuthenticationFailed = errors.New("プレイヤー名かパスワード(署名)が正しくありません。")
+)
+
+// パスワードを検証し、保存するためのハッシュを返す。
+func HashPassword(password string) ([]byte, error) {
+	if len(password) < MIN_PASSWORD_LENGTH {
+		return nil, ErrPasswordTooShort
+	}
+	if len(password) > MAX_PASSWORD_LENGTH {
+		return nil, ErrPasswordTooLong
+	}
+	return bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+}
+
+func VerifyPassword(hash []byte, password string) error {
+	if len(hash) == 0 || bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return ErrAuthenticationFailed
+	}
+	return nil
+}
+
+// authorized_keys形式のSSH公開鍵を検証し、コメント等を除いた形式に揃える。
+func NormalizePublicKey(authorizedKey string) (string, error) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(authorizedKey))
+	if err != nil {
+		return "", ErrInvalidPublicKey
+	}
+	return string(ssh.MarshalAuthorizedKey(key)), nil
+}
+
+// signatureはssh.Marshalでエンコードされた、challengeに対する署名
+// publicKeysのいずれかで検証できればよい。
+func VerifySignature(publicKeys []string, challenge []byte, signature []byte) error {
+	sig := &ssh.Signature{}
+	if err := ssh.Unmarshal(signature, sig); err != nil {
+		return ErrAuthenticationFailed
+	}
+	for _, k := range publicKeys {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(k))
+		if err != nil {
+			continue
+		}
+		if key.Verify(challenge, sig) == nil {
+			return nil
+		}
+	}
+	return ErrAuthenticationFailed
+}
@@ -0,0 +1,103 @@
+package model
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"golang.org/x/crypto/ssh"
+	"testing"
+)
+
+func TestHashPassword(t *testing.T) {
+	tests := map[string]struct {
+		password    string
+		expectedErr error
+	}{
+		"8文字以上のパスワードは利用できる。": {
+			password:    "password",
+			expectedErr: nil,
+		},
+		"8文字未満のパスワードは利用できない。": {
+			password:    "passwor",
+			expectedErr: ErrPasswordTooShort,
+		},
+		"72バイトを超えるパスワードは利用できない。": {
+			password:    string(make([]byte, MAX_PASSWORD_LENGTH+1)),
+			expectedErr: ErrPasswordTooLong,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			hash, err := HashPassword(tt.password)
+			if err != tt.expectedErr {
+				t.Fatalf("Expected: %v\n\t\t Actual: %v \n", tt.expectedErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if err := VerifyPassword(hash, tt.password); err != nil {
+				t.Errorf("Expected: %v\n\t\t Actual: %v \n", nil, err)
+			}
+			if err := VerifyPassword(hash, tt.password+"x"); err != ErrAuthenticationFailed {
+				t.Errorf("Expected: %v\n\t\t Actual: %v \n", ErrAuthenticationFailed, err)
+			}
+		})
+	}
+}
+
+func newTestSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey(): %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("NewSignerFromKey(): %v", err)
+	}
+	return signer
+}
+
+func TestVerifySignature(t *testing.T) {
+	signer, other := newTestSigner(t), newTestSigner(t)
+	key, err := NormalizePublicKey(string(ssh.MarshalAuthorizedKey(signer.PublicKey())) + " bob@example")
+	if err != nil {
+		t.Fatalf("NormalizePublicKey(): %v", err)
+	}
+	challenge := []byte("challenge")
+	sign := func(s ssh.Signer, data []byte) []byte {
+		sig, err := s.Sign(rand.Reader, data)
+		if err != nil {
+			t.Fatalf("Sign(): %v", err)
+		}
+		return ssh.Marshal(sig)
+	}
+
+	tests := map[string]struct {
+		signature   []byte
+		expectedErr error
+	}{
+		"登録した公開鍵に対応する秘密鍵の署名は検証できる。": {
+			signature:   sign(signer, challenge),
+			expectedErr: nil,
+		},
+		"別の秘密鍵の署名は検証できない。": {
+			signature:   sign(other, challenge),
+			expectedErr: ErrAuthenticationFailed,
+		},
+		"別のチャレンジに対する署名は検証できない。": {
+			signature:   sign(signer, []byte("old challenge")),
+			expectedErr: ErrAuthenticationFailed,
+		},
+		"署名の形式が正しくない場合は検証できない。": {
+			signature:   []byte("invalid"),
+			expectedErr: ErrAuthenticationFailed,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := VerifySignature([]string{key}, challenge, tt.signature); err != tt.expectedErr {
+				t.Errorf("Expected: %v\n\t\t Actual: %v \n", tt.expectedErr, err)
+			}
+		})
+	}
+}
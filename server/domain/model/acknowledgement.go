@@ -0,0 +1,101 @@
+package model
+
+import (
+	"github.com/taise-hub/shellgame-cli/common"
+	"log"
+)
+
+const (
+	MAX_MESSAGE_ID_LENGTH  = 64  // クライアントが発行するメッセージのIDの最大長
+	HANDLED_HISTORY_SIZE   = 32  // プレイヤーごとに処理した結果を覚えておくメッセージ数
+	DEPARTED_HISTORY_LIMIT = 100 // 退室したプレイヤーの処理した結果を覚えておく人数
+)
+
+// IDのあるメッセージを処理した結果。同じIDで再送されたメッセージには処理し直さずにreplyを送り直す。
+type handledMessage struct {
+	id    string
+	reply *common.MatchingMessage
+}
+
+// 処理の結果を送信者に返す。IDのあるメッセージにはACKかNACKを返し、再送に備えて結果を覚えておく。
+// IDのないメッセージには処理できなかった場合のみERRORを返す。
+func (mr *MatchingRoom) acknowledge(msg *common.MatchingMessage, err error) {
+	if err != nil {
+		log.Printf("[-] %s: %v\n", msg.Source.Name, err)
+	}
+	var reply *common.MatchingMessage
+	switch {
+	case err != nil:
+		reply = newErrorMessage(msg.ID, err)
+	case msg.ID != "":
+		reply = &common.MatchingMessage{ID: msg.ID, Data: common.ACK}
+		// 承諾や開始の結果を受け取れずに再送したクライアントが対戦を始められるように、開始した対戦を含める。
+		if (msg.Data == common.ACCEPT || msg.Data == common.START) && msg.Battle != nil {
+			if battle, ok := mr.GetBattle(msg.Battle.ID); ok {
+				reply.Battle = battle.Summary()
+			}
+		}
+	default:
+		return
+	}
+	// 覚えておく人数が増え続けないように、ロビーにいるプレイヤーの結果のみ覚えておく。
+	if msg.ID != "" && mr.Players[msg.Source.ID] != nil {
+		mr.remember(msg.Source.ID, &handledMessage{id: msg.ID, reply: reply})
+	}
+	mr.reply(msg, reply)
+}
+
+// 既に処理したIDのメッセージであれば、前回の結果を送信者に送り直してtrueを返す。
+func (mr *MatchingRoom) replay(msg *common.MatchingMessage) bool {
+	if msg.ID == "" || msg.Source == nil {
+		return false
+	}
+	for _, h := range mr.handled[msg.Source.ID] {
+		if h.id == msg.ID {
+			log.Printf("[+] %s resent the message %s.\n", msg.Source.Name, msg.ID)
+			mr.reply(msg, h.reply)
+			return true
+		}
+	}
+	return false
+}
+
+// 対戦チャンネルで送信されたメッセージには対戦チャンネルで、それ以外はロビーで返す。
+func (mr *MatchingRoom) reply(msg *common.MatchingMessage, reply *common.MatchingMessage) {
+	if battleMessageData[msg.Data] {
+		mr.sendFighter(msg.Source.ID, reply)
+		return
+	}
+	mr.send(msg.Source.ID, reply)
+}
+
+func (mr *MatchingRoom) remember(id string, h *handledMessage) {
+	history := append(mr.handled[id], h)
+	if len(history) > HANDLED_HISTORY_SIZE {
+		history = history[len(history)-HANDLED_HISTORY_SIZE:]
+	}
+	mr.handled[id] = history
+}
+
+// 接続し直したプレイヤーが再送したメッセージを処理し直さないように、退室したプレイヤーの結果もしばらく覚えておく。
+// 全てのサーバで同じ順序で呼び出されるため、忘れるプレイヤーも全てのサーバで一致する。
+func (mr *MatchingRoom) depart(id string) {
+	if _, ok := mr.handled[id]; !ok {
+		return
+	}
+	mr.departed = append(mr.departed, id)
+	if len(mr.departed) > DEPARTED_HISTORY_LIMIT {
+		delete(mr.handled, mr.departed[0])
+		mr.departed = mr.departed[1:]
+	}
+}
+
+// 再び参加したプレイヤーの結果は、退室するまで忘れないようにする。
+func (mr *MatchingRoom) arrive(id string) {
+	for i, departed := range mr.departed {
+		if departed == id {
+			mr.departed = append(mr.departed[:i], mr.departed[i+1:]...)
+			return
+		}
+	}
+}
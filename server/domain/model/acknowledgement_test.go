@@ -0,0 +1,107 @@
+package model
+
+import (
+	"github.com/taise-hub/shellgame-cli/common"
+	"testing"
+	"time"
+)
+
+// dataのメッセージが届くまで待ち、それまでに届いたメッセージにunexpectedがあれば失敗する。
+func (c *fakeConn) expectWithout(t *testing.T, data common.MatchingMessageData, unexpected common.MatchingMessageData) *common.MatchingMessage {
+	t.Helper()
+	for {
+		select {
+		case msg := <-c.written:
+			if msg.Data == unexpected {
+				t.Fatalf("Expected: no %s before %s\n\t\t Actual: %+v \n", unexpected, data, msg)
+			} else if msg.Data == data {
+				return msg
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for message %s", data)
+			return nil
+		}
+	}
+}
+
+func TestMatchingRoomAcknowledgement(t *testing.T) {
+	bus := newFakeBus()
+	mr := startTestRoom(t, "beginner", bus)
+	bob, bobConn := joinTestRoom(mr, "1", "bob")
+	alice, aliceConn := joinTestRoom(mr, "2", "alice")
+	carol, carolConn := joinTestRoom(mr, "3", "carol")
+	bobConn.expect(t, common.JOIN)
+	bobConn.expect(t, common.JOIN)
+
+	offer := &common.MatchingMessage{ID: "offer-1", Dest: alice.GetProfile(), Data: common.OFFER}
+	t.Run("IDのあるメッセージを処理するとACKを返す。", func(t *testing.T) {
+		mr.message <- &playerMessage{bob, offer}
+		aliceConn.expect(t, common.OFFER)
+		if msg := bobConn.expect(t, common.ACK); msg.ID != offer.ID {
+			t.Errorf("Expected: %s\n\t\t Actual: %s \n", offer.ID, msg.ID)
+		}
+	})
+	t.Run("同じIDで再送されたメッセージは処理し直さずにACKを返す。", func(t *testing.T) {
+		resent := *offer
+		mr.message <- &playerMessage{bob, &resent}
+		if msg := bobConn.expect(t, common.ACK); msg.ID != offer.ID {
+			t.Errorf("Expected: %s\n\t\t Actual: %s \n", offer.ID, msg.ID)
+		}
+		mr.message <- &playerMessage{bob, &common.MatchingMessage{Data: common.CHAT, Text: "hi"}}
+		aliceConn.expectWithout(t, common.CHAT, common.OFFER)
+	})
+
+	accept := &common.MatchingMessage{ID: "accept-1", Dest: bob.GetProfile(), Data: common.ACCEPT}
+	var battleID string
+	t.Run("承諾のACKには開始した対戦を含む。", func(t *testing.T) {
+		mr.message <- &playerMessage{alice, accept}
+		bobConn.expect(t, common.ACCEPT)
+		msg := aliceConn.expect(t, common.ACK)
+		if msg.ID != accept.ID || msg.Battle == nil || msg.Battle.ID == "" {
+			t.Fatalf("Expected: %s with battle\n\t\t Actual: %+v \n", accept.ID, msg)
+		}
+		battleID = msg.Battle.ID
+	})
+	t.Run("接続し直してから承諾を再送しても、対戦は一つだけ開始される。", func(t *testing.T) {
+		alice2, alice2Conn := joinTestRoom(mr, alice.GetID(), alice.GetName())
+		alice2Conn.expect(t, common.SNAPSHOT)
+		resent := *accept
+		mr.message <- &playerMessage{alice2, &resent}
+		msg := alice2Conn.expect(t, common.ACK)
+		if msg.ID != accept.ID || msg.Battle == nil || msg.Battle.ID != battleID {
+			t.Errorf("Expected: %s\n\t\t Actual: %+v \n", battleID, msg.Battle)
+		}
+		mr.mu.RLock()
+		n := len(mr.battles)
+		mr.mu.RUnlock()
+		if n != 1 {
+			t.Errorf("Expected: 1\n\t\t Actual: %d \n", n)
+		}
+	})
+
+	tests := []struct {
+		name     string
+		msg      *common.MatchingMessage
+		expected common.ErrorCode
+	}{
+		{name: "処理できなかったメッセージにはNACKを返す。", msg: &common.MatchingMessage{ID: "offer-2", Dest: carol.GetProfile(), Data: common.OFFER}, expected: common.ERR_INVALID_MESSAGE},
+		{name: "発行する前に拒否したメッセージにもNACKを返す。", msg: &common.MatchingMessage{ID: "matched-1", Data: common.MATCHED}, expected: common.ERR_INVALID_MESSAGE},
+		{name: "再送された処理できなかったメッセージにも同じNACKを返す。", msg: &common.MatchingMessage{ID: "offer-2", Dest: carol.GetProfile(), Data: common.OFFER}, expected: common.ERR_INVALID_MESSAGE},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr.message <- &playerMessage{carol, tt.msg}
+			msg := carolConn.expect(t, common.NACK)
+			if msg.ID != tt.msg.ID || msg.Code != tt.expected || msg.Reason == "" {
+				t.Errorf("Expected: %s %s\n\t\t Actual: %s %s %q \n", tt.msg.ID, tt.expected, msg.ID, msg.Code, msg.Reason)
+			}
+		})
+	}
+	t.Run("SNAPSHOTの要求にもACKを返す。", func(t *testing.T) {
+		mr.message <- &playerMessage{carol, &common.MatchingMessage{ID: "snapshot-1", Data: common.SNAPSHOT}}
+		carolConn.expect(t, common.SNAPSHOT)
+		if msg := carolConn.expect(t, common.ACK); msg.ID != "snapshot-1" {
+			t.Errorf("Expected: snapshot-1\n\t\t Actual: %s \n", msg.ID)
+		}
+	})
+}
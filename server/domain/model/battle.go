@@ -0,0 +1,109 @@
+package model
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/taise-hub/shellgame-cli/common"
+	"sync"
+	"time"
+)
+
+const (
+	BATTLE_LIFETIME = 30 * time.Minute // 開始した対戦の情報を保持する時間
+)
+
+// 対戦の参加者と得点
+// 全てのサーバで同じ対戦を扱えるように、IDは対戦開始のイベントを発行する前に決める。
+type Battle struct {
+	ID          string
+	Mode        common.BattleMode
+	Teams       [][]*common.Profile // チームごとの参加者。個人戦では一人ずつのチームとする。
+	SharedShell bool                // チームメイトで同じコンテナを共有するかどうか
+	QuestionSet string              // 出題する問題の組
+	started     time.Time
+	scores      map[string]int             // プレイヤーごとの得点
+	chat        []*common.BattleChat       // 対戦中に送信されたチャットとエモート。古いものから並ぶ。
+	muted       map[string]map[string]bool // プレイヤーごとにチャットを受け取らない相手。受信者、送信者のIDの順に引く。
+	mu          sync.Mutex                 // scores, chat, mutedを保護する。
+}
+
+func newBattleID() string {
+	return uuid.NewString()
+}
+
+func newBattle(id string, mode common.BattleMode, teams [][]*common.Profile, shared bool, started time.Time) *Battle {
+	return &Battle{
+		ID:          id,
+		Mode:        mode,
+		Teams:       teams,
+		SharedShell: shared,
+		started:     started,
+		scores:      make(map[string]int),
+		muted:       make(map[string]map[string]bool),
+	}
+}
+
+// idのプレイヤーが所属するチームの番号を返す。参加していない場合は-1を返す。
+func (b *Battle) TeamOf(id string) int {
+	for i, team := range b.Teams {
+		for _, p := range team {
+			if p.ID == id {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// idのプレイヤーが対戦で利用するコンソールの名前を返す。参加していない場合は空文字列を返す。
+// チームメイトでコンテナを共有する対戦ではチームごとに、それ以外はプレイヤーごとに一つのコンソールを利用する。
+func (b *Battle) ConsoleName(id string) string {
+	team := b.TeamOf(id)
+	if team < 0 {
+		return ""
+	} else if b.SharedShell {
+		return fmt.Sprintf("%s-%d", b.ID, team)
+	}
+	return fmt.Sprintf("%s-player-%s", b.ID, id)
+}
+
+// 対戦の参加者が利用する全てのコンソールの名前を返す。
+func (b *Battle) ConsoleNames() []string {
+	var names []string
+	for i, team := range b.Teams {
+		if b.SharedShell {
+			names = append(names, fmt.Sprintf("%s-%d", b.ID, i))
+			continue
+		}
+		for _, p := range team {
+			names = append(names, b.ConsoleName(p.ID))
+		}
+	}
+	return names
+}
+
+func (b *Battle) AddScore(id string, points int) error {
+	if b.TeamOf(id) < 0 {
+		return fmt.Errorf("player %s is not in the battle", id)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.scores[id] += points
+	return nil
+}
+
+// チームごとに得点を集計した対戦の状況を返す。
+func (b *Battle) Summary() *common.Battle {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	summary := &common.Battle{ID: b.ID, Mode: b.Mode, SharedShell: b.SharedShell, QuestionSet: b.QuestionSet}
+	summary.Chat = append(summary.Chat, b.chat...)
+	for _, members := range b.Teams {
+		team := &common.Team{Members: members}
+		for _, p := range members {
+			team.Score += b.scores[p.ID]
+		}
+		summary.Teams = append(summary.Teams, team)
+	}
+	return summary
+}
@@ -0,0 +1,209 @@
+package model
+
+import (
+	"github.com/taise-hub/shellgame-cli/common"
+	"log"
+	"time"
+)
+
+const (
+	BATTLE_CHAT_RECORD_SIZE = 500 // 対戦ごとに記録するチャットとエモートの最大数。超えた場合は古いものから捨てる。
+)
+
+// 対戦中に送信できるエモート
+var EMOTES = map[string]bool{
+	"gg":     true,
+	"hello":  true,
+	"nice":   true,
+	"oops":   true,
+	"thanks": true,
+}
+
+// 対戦チャンネルに接続したプレイヤーが送信することのできるメッセージ
+var battleMessageData = map[common.MatchingMessageData]bool{
+	common.BATTLE_CHAT: true,
+	common.EMOTE:       true,
+	common.MUTE:        true,
+	common.UNMUTE:      true,
+}
+
+// 対戦チャンネルに接続したプレイヤーを登録する。対戦の参加者でなければ切断する。
+// 同じプレイヤーが接続し直した場合は古い接続を切断する。
+func (mr *MatchingRoom) enterBattleChannel(p *MatchingPlayer) {
+	battle, ok := mr.GetBattle(p.GetBattleID())
+	if !ok || battle.TeamOf(p.GetID()) < 0 {
+		log.Printf("[-] %s: cannot join the battle %s\n", p.GetName(), p.GetBattleID())
+		close(p.outbox)
+		p.conn.Close()
+		return
+	}
+	if old, ok := mr.fighters[p.GetID()]; ok {
+		old.conn.Close()
+		mr.exitBattleChannel(old)
+	}
+	mr.fighters[p.GetID()] = p
+	delete(mr.finishing, p.GetID())
+}
+
+// outboxを閉じた後に送信しないように、ここ以外で対戦チャンネルのプレイヤーのoutboxを閉じてはならない。
+func (mr *MatchingRoom) exitBattleChannel(p *MatchingPlayer) {
+	if fp, ok := mr.fighters[p.GetID()]; !ok || fp != p {
+		return
+	}
+	close(p.outbox)
+	delete(mr.fighters, p.GetID())
+}
+
+// 対戦チャンネルから切断されたプレイヤーは、mr.graceの間に接続し直さなければ対戦を終えたものとする。
+// 接続し直して古い接続が切断された場合は何もしない。
+func (mr *MatchingRoom) leaveBattleChannel(p *MatchingPlayer) {
+	if fp, ok := mr.fighters[p.GetID()]; !ok || fp != p {
+		return
+	}
+	mr.exitBattleChannel(p)
+	mr.finishing[p.GetID()] = &droppedPlayer{player: p, deadline: mr.now().Add(mr.grace)}
+}
+
+// 対戦チャンネルに接続し直さなかった参加者が、全てのサーバでWAITINGに戻るようにSTATUSを発行する。
+// 既に破棄した対戦の参加者は、破棄するときに発行しているため何もしない。
+func (mr *MatchingRoom) finishBattles(now time.Time) {
+	for id, f := range mr.finishing {
+		if now.Before(f.deadline) {
+			continue
+		}
+		delete(mr.finishing, id)
+		if _, ok := mr.GetBattle(f.player.GetBattleID()); ok {
+			mr.publishStatus(f.player, common.WAITING)
+		}
+	}
+}
+
+// 対戦チャンネルのプレイヤーが送信したメッセージを確認し、MatchingEventBusに発行する。
+// 送信者と対戦はクライアントが送信した値ではなく、接続時に特定したものとする。
+func (mr *MatchingRoom) publishBattleMessage(pm *playerMessage) error {
+	p, msg := pm.player, pm.msg
+	if !battleMessageData[msg.Data] {
+		return newMatchingError(common.ERR_INVALID_MESSAGE, "%sは対戦中に送信できません。", msg.Data)
+	}
+	if len(msg.ID) > MAX_MESSAGE_ID_LENGTH {
+		return newMatchingError(common.ERR_INVALID_MESSAGE, "メッセージのIDは%d文字以内にしてください。", MAX_MESSAGE_ID_LENGTH)
+	}
+	reply := &common.MatchingMessage{
+		ID:     msg.ID,
+		Source: p.GetProfile(),
+		Data:   msg.Data,
+		Battle: &common.Battle{ID: p.GetBattleID()},
+		Text:   msg.Text,
+	}
+	switch msg.Data {
+	case common.BATTLE_CHAT:
+		if err := mr.limitChat(p, reply); err != nil {
+			return err
+		}
+	case common.EMOTE:
+		if !EMOTES[msg.Text] {
+			return newMatchingError(common.ERR_INVALID_CHAT, "エモート%sはありません。", msg.Text)
+		} else if !p.allowChat(mr.now()) {
+			return newMatchingError(common.ERR_RATE_LIMITED, "チャットの送信が多すぎます。しばらく待ってから送信してください。")
+		}
+	case common.MUTE, common.UNMUTE:
+		if msg.Dest == nil {
+			return newMatchingError(common.ERR_INVALID_MESSAGE, "相手のプレイヤーを指定してください。")
+		}
+		reply.Dest = &common.Profile{ID: msg.Dest.ID}
+		reply.Text = ""
+	}
+	mr.publish(reply)
+	return nil
+}
+
+// 対戦中のチャット処理
+// BATTLE_CHAT, EMOTEは対戦の記録に残し、送信者をミュートしていない参加者に届ける。
+// MUTE, UNMUTEは送信者(Source)が受信者(Dest)からのチャットを受け取るかどうかを切り替え、送信者にのみ結果を送り返す。
+func (mr *MatchingRoom) battleChat(msg *common.MatchingMessage) error {
+	if msg.Battle == nil {
+		return newMatchingError(common.ERR_INVALID_MESSAGE, "対戦を指定してください。")
+	}
+	battle, ok := mr.GetBattle(msg.Battle.ID)
+	if !ok {
+		return newMatchingError(common.ERR_INVALID_BATTLE, "対戦が見つかりません。既に終了した可能性があります。")
+	} else if battle.TeamOf(msg.Source.ID) < 0 {
+		return newMatchingError(common.ERR_PLAYER_NOT_FOUND, "対戦に参加していません。")
+	}
+	switch msg.Data {
+	case common.MUTE, common.UNMUTE:
+		if msg.Dest == nil || battle.TeamOf(msg.Dest.ID) < 0 {
+			return newMatchingError(common.ERR_PLAYER_NOT_FOUND, "相手のプレイヤーは対戦に参加していません。")
+		} else if msg.Dest.ID == msg.Source.ID {
+			return newMatchingError(common.ERR_INVALID_MESSAGE, "自分をミュートすることはできません。")
+		}
+		battle.setMuted(msg.Source.ID, msg.Dest.ID, msg.Data == common.MUTE)
+		reply := *msg
+		reply.Dest = battle.participant(msg.Dest.ID)
+		mr.sendFighter(msg.Source.ID, &reply)
+		return nil
+	}
+	battle.record(&common.BattleChat{Source: msg.Source, Emote: msg.Data == common.EMOTE, Text: msg.Text})
+	for _, team := range battle.Teams {
+		for _, p := range team {
+			if battle.isMuted(p.ID, msg.Source.ID) {
+				continue
+			}
+			mr.sendFighter(p.ID, msg)
+		}
+	}
+	return nil
+}
+
+// 対戦チャンネルでこのサーバに接続しているプレイヤーにのみ送信する。受け取れないプレイヤーは切断する。
+func (mr *MatchingRoom) sendFighter(id string, msg *common.MatchingMessage) {
+	p, ok := mr.fighters[id]
+	if !ok {
+		return
+	}
+	if !deliver(p, msg) {
+		log.Printf("[+] %s is too slow to receive messages.\n", p.GetName())
+		p.conn.Close()
+		mr.exitBattleChannel(p)
+	}
+}
+
+func (b *Battle) record(chat *common.BattleChat) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.chat = append(b.chat, chat)
+	if len(b.chat) > BATTLE_CHAT_RECORD_SIZE {
+		b.chat = b.chat[len(b.chat)-BATTLE_CHAT_RECORD_SIZE:]
+	}
+}
+
+func (b *Battle) setMuted(receiver, source string, muted bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !muted {
+		delete(b.muted[receiver], source)
+		return
+	}
+	if _, ok := b.muted[receiver]; !ok {
+		b.muted[receiver] = make(map[string]bool)
+	}
+	b.muted[receiver][source] = true
+}
+
+func (b *Battle) isMuted(receiver, source string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.muted[receiver][source]
+}
+
+// 参加者のプロフィールを返す。参加していない場合はnilを返す。
+func (b *Battle) participant(id string) *common.Profile {
+	for _, team := range b.Teams {
+		for _, p := range team {
+			if p.ID == id {
+				return p
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package model
+
+import (
+	"context"
+	"github.com/taise-hub/shellgame-cli/common"
+	"testing"
+	"time"
+)
+
+func joinBattleChannel(mr *MatchingRoom, id string, name string, battleID string) (*MatchingPlayer, *fakeConn) {
+	conn := newFakeConn()
+	p := NewMatchingPlayer(id, name, conn)
+	p.SetBattleID(battleID)
+	mr.register <- p
+	go p.WritePump(context.Background())
+	return p, conn
+}
+
+func TestMatchingRoomBattleChat(t *testing.T) {
+	bus := newFakeBus()
+	roomA := startTestRoom(t, "beginner", bus)
+	roomB := startTestRoom(t, "beginner", bus)
+	bob, bobConn := joinTestRoom(roomA, "1", "bob")
+	alice, aliceConn := joinTestRoom(roomB, "2", "alice")
+	joinTestRoom(roomB, "3", "carol")
+	bobConn.expect(t, common.JOIN)
+	bobConn.expect(t, common.JOIN)
+
+	// bobとaliceの対戦を開始する。
+	roomA.message <- &playerMessage{bob, &common.MatchingMessage{Dest: alice.GetProfile(), Data: common.OFFER}}
+	aliceConn.expect(t, common.OFFER)
+	roomB.message <- &playerMessage{alice, &common.MatchingMessage{Dest: bob.GetProfile(), Data: common.ACCEPT}}
+	id := bobConn.expect(t, common.ACCEPT).Battle.ID
+	aliceConn.expect(t, common.ACCEPT)
+
+	bobChan, bobChanConn := joinBattleChannel(roomA, "1", "bob", id)
+	aliceChan, aliceChanConn := joinBattleChannel(roomB, "2", "alice", id)
+	send := func(mr *MatchingRoom, p *MatchingPlayer, data common.MatchingMessageData, text string, dest *MatchingPlayer) {
+		msg := &common.MatchingMessage{Data: data, Text: text}
+		if dest != nil {
+			msg.Dest = dest.GetProfile()
+		}
+		mr.message <- &playerMessage{p, msg}
+	}
+
+	t.Run("対戦の参加者でなければ対戦チャンネルに接続できない。", func(t *testing.T) {
+		_, conn := joinBattleChannel(roomB, "3", "carol", id)
+		select {
+		case <-conn.closed:
+		case <-time.After(time.Second):
+			t.Fatalf("Expected: closed\n\t\t Actual: connected \n")
+		}
+	})
+	t.Run("チャットとエモートは他のサーバに接続している対戦相手にも届く。", func(t *testing.T) {
+		send(roomA, bobChan, common.BATTLE_CHAT, "よろしく", nil)
+		send(roomA, bobChan, common.EMOTE, "hello", nil)
+		for _, conn := range []*fakeConn{bobChanConn, aliceChanConn} {
+			if msg := conn.expect(t, common.BATTLE_CHAT); msg.Text != "よろしく" || msg.Battle.ID != id {
+				t.Errorf("Expected: よろしく in %s\n\t\t Actual: %q in %s \n", id, msg.Text, msg.Battle.ID)
+			}
+			if msg := conn.expect(t, common.EMOTE); msg.Source.ID != bob.GetID() || msg.Text != "hello" {
+				t.Errorf("Expected: hello from %s\n\t\t Actual: %q from %s \n", bob.GetID(), msg.Text, msg.Source.ID)
+			}
+		}
+	})
+	t.Run("存在しないエモートは拒否される。", func(t *testing.T) {
+		send(roomA, bobChan, common.EMOTE, "unknown", nil)
+		if msg := bobChanConn.expect(t, common.ERROR); msg.Reason == "" {
+			t.Errorf("Expected: reason\n\t\t Actual: %q \n", msg.Reason)
+		}
+	})
+	t.Run("ロビーのメッセージは対戦チャンネルから送信できない。", func(t *testing.T) {
+		send(roomA, bobChan, common.OFFER, "", alice)
+		bobChanConn.expect(t, common.ERROR)
+	})
+	t.Run("ミュートした相手のチャットは届かないが、対戦の記録には残る。", func(t *testing.T) {
+		send(roomB, aliceChan, common.MUTE, "", bob)
+		if msg := aliceChanConn.expect(t, common.MUTE); msg.Dest.Name != bob.GetName() {
+			t.Errorf("Expected: %s\n\t\t Actual: %s \n", bob.GetName(), msg.Dest.Name)
+		}
+		send(roomA, bobChan, common.BATTLE_CHAT, "muted", nil)
+		bobChanConn.expect(t, common.BATTLE_CHAT)
+		send(roomB, aliceChan, common.UNMUTE, "", bob)
+		aliceChanConn.expect(t, common.UNMUTE)
+		send(roomA, bobChan, common.BATTLE_CHAT, "unmuted", nil)
+		if msg := aliceChanConn.expect(t, common.BATTLE_CHAT); msg.Text != "unmuted" {
+			t.Errorf("Expected: unmuted\n\t\t Actual: %q \n", msg.Text)
+		}
+		battle, _ := roomB.GetBattle(id)
+		var texts []string
+		for _, c := range battle.Summary().Chat {
+			texts = append(texts, c.Text)
+		}
+		expected := []string{"よろしく", "hello", "muted", "unmuted"}
+		if len(texts) != len(expected) {
+			t.Fatalf("Expected: %v\n\t\t Actual: %v \n", expected, texts)
+		}
+		for i := range expected {
+			if texts[i] != expected[i] {
+				t.Errorf("Expected: %v\n\t\t Actual: %v \n", expected, texts)
+			}
+		}
+	})
+}
+
+func TestMatchingRoomFinishesBattle(t *testing.T) {
+	bus := newFakeBus()
+	clock := &fakeClock{now: time.Now()}
+	roomA := NewMatchingRoom("beginner", bus)
+	roomB := NewMatchingRoom("beginner", bus)
+	for _, mr := range []*MatchingRoom{roomA, roomB} {
+		mr.now = clock.Now
+		mr.sweep = 10 * time.Millisecond
+		mr.grace = 10 * time.Second
+		runTestRoom(t, mr, bus)
+	}
+	bob, bobConn := joinTestRoom(roomA, "1", "bob")
+	alice, aliceConn := joinTestRoom(roomB, "2", "alice")
+	bobConn.expect(t, common.JOIN)
+	roomA.message <- &playerMessage{bob, &common.MatchingMessage{Dest: alice.GetProfile(), Data: common.OFFER}}
+	aliceConn.expect(t, common.OFFER)
+	roomB.message <- &playerMessage{alice, &common.MatchingMessage{Dest: bob.GetProfile(), Data: common.ACCEPT}}
+	id := bobConn.expect(t, common.ACCEPT).Battle.ID
+	aliceConn.expect(t, common.ACCEPT)
+	bobChan, _ := joinBattleChannel(roomA, "1", "bob", id)
+	joinBattleChannel(roomB, "2", "alice", id)
+
+	t.Run("対戦チャンネルに接続し直した参加者は対戦中のままとなる。", func(t *testing.T) {
+		roomA.unregister <- bobChan
+		bobChan, _ = joinBattleChannel(roomA, "1", "bob", id)
+		clock.Advance(time.Minute)
+		time.Sleep(5 * roomA.sweep)
+		for _, mr := range []*MatchingRoom{roomA, roomB} {
+			if s := statusOf(mr, bob.GetID()); s != IN_BATTLE {
+				t.Errorf("Expected: %s\n\t\t Actual: %s \n", IN_BATTLE, s)
+			}
+		}
+	})
+	t.Run("対戦チャンネルから切断されて接続し直さなかった参加者は、対戦の破棄を待たずに全てのサーバでWAITINGに戻る。", func(t *testing.T) {
+		roomA.unregister <- bobChan
+		// 切断を処理してから時計を進める。
+		joinTestRoom(roomA, "3", "carol")
+		bobConn.expect(t, common.JOIN)
+		clock.Advance(time.Minute)
+		bobConn.expectStatus(t, bob.GetID(), common.WAITING)
+		aliceConn.expectStatus(t, bob.GetID(), common.WAITING)
+		for _, mr := range []*MatchingRoom{roomA, roomB} {
+			if s := statusOf(mr, bob.GetID()); s != WAITING {
+				t.Errorf("Expected: %s\n\t\t Actual: %s \n", WAITING, s)
+			}
+			if s := statusOf(mr, alice.GetID()); s != IN_BATTLE {
+				t.Errorf("Expected: %s\n\t\t Actual: %s \n", IN_BATTLE, s)
+			}
+		}
+	})
+}
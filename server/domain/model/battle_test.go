@@ -0,0 +1,150 @@
+package model
+
+import (
+	"github.com/taise-hub/shellgame-cli/common"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBattleSummary(t *testing.T) {
+	bob := &common.Profile{ID: "1", Name: "bob"}
+	alice := &common.Profile{ID: "2", Name: "alice"}
+	carol := &common.Profile{ID: "3", Name: "carol"}
+	dave := &common.Profile{ID: "4", Name: "dave"}
+	battle := newBattle("battle", common.TEAM, [][]*common.Profile{{bob, alice}, {carol, dave}}, false, time.Now())
+
+	tests := []struct {
+		name     string
+		id       string
+		points   int
+		expected []int
+		err      bool
+	}{
+		{name: "得点はチームごとに集計される。", id: bob.ID, points: 10, expected: []int{10, 0}},
+		{name: "チームメイトの得点は合算される。", id: alice.ID, points: 5, expected: []int{15, 0}},
+		{name: "相手チームの得点は別に集計される。", id: dave.ID, points: 20, expected: []int{15, 20}},
+		{name: "参加していないプレイヤーの得点は記録しない。", id: "5", points: 100, expected: []int{15, 20}, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := battle.AddScore(tt.id, tt.points); (err != nil) != tt.err {
+				t.Errorf("Expected: %t\n\t\t Actual: %v \n", tt.err, err)
+			}
+			summary := battle.Summary()
+			for i, team := range summary.Teams {
+				if team.Score != tt.expected[i] {
+					t.Errorf("Expected: %d\n\t\t Actual: %d \n", tt.expected[i], team.Score)
+				}
+			}
+		})
+	}
+}
+
+func TestBattleConsoleName(t *testing.T) {
+	bob := &common.Profile{ID: "1", Name: "bob"}
+	alice := &common.Profile{ID: "2", Name: "alice"}
+	carol := &common.Profile{ID: "3", Name: "carol"}
+	teams := [][]*common.Profile{{bob, alice}, {carol}}
+
+	tests := []struct {
+		name     string
+		shared   bool
+		id       string
+		expected string
+		all      []string
+	}{
+		{name: "コンテナを共有する対戦では、チームごとのコンソールを利用する。", shared: true, id: alice.ID, expected: "battle-0", all: []string{"battle-0", "battle-1"}},
+		{name: "コンテナを共有しない対戦では、プレイヤーごとのコンソールを利用する。", shared: false, id: alice.ID, expected: "battle-player-2", all: []string{"battle-player-1", "battle-player-2", "battle-player-3"}},
+		{name: "参加していないプレイヤーのコンソールはない。", shared: false, id: "4", expected: "", all: []string{"battle-player-1", "battle-player-2", "battle-player-3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			battle := newBattle("battle", common.TEAM, teams, tt.shared, time.Now())
+			if name := battle.ConsoleName(tt.id); name != tt.expected {
+				t.Errorf("Expected: %s\n\t\t Actual: %s \n", tt.expected, name)
+			}
+			if names := battle.ConsoleNames(); !reflect.DeepEqual(names, tt.all) {
+				t.Errorf("Expected: %v\n\t\t Actual: %v \n", tt.all, names)
+			}
+		})
+	}
+}
+
+// fakeConsolesはテスト用のConsoleRepositoryの実装
+// 削除したコンソールの名前をremovedに流す。
+type fakeConsoles struct {
+	removed chan string
+}
+
+func (c *fakeConsoles) StartShell() (net.Conn, error) {
+	return nil, nil
+}
+
+func (c *fakeConsoles) JoinShell(string) (net.Conn, error) {
+	return nil, nil
+}
+
+func (c *fakeConsoles) RemoveShell(name string) error {
+	c.removed <- name
+	return nil
+}
+
+func TestMatchingRoomSweepsBattles(t *testing.T) {
+	bus := newFakeBus()
+	clock := &fakeClock{now: time.Now()}
+	consoles := &fakeConsoles{removed: make(chan string, 8)}
+	roomA := NewMatchingRoom("beginner", bus)
+	roomA.SetConsoles(consoles)
+	roomB := NewMatchingRoom("beginner", bus)
+	for _, mr := range []*MatchingRoom{roomA, roomB} {
+		mr.now = clock.Now
+		mr.sweep = 10 * time.Millisecond
+		runTestRoom(t, mr, bus)
+	}
+	bob, bobConn := joinTestRoom(roomA, "1", "bob")
+	alice, aliceConn := joinTestRoom(roomB, "2", "alice")
+	bobConn.expect(t, common.JOIN)
+	roomA.message <- &playerMessage{bob, &common.MatchingMessage{Dest: alice.GetProfile(), Data: common.OFFER}}
+	aliceConn.expect(t, common.OFFER)
+	roomB.message <- &playerMessage{alice, &common.MatchingMessage{Dest: bob.GetProfile(), Data: common.ACCEPT}}
+	bobConn.expect(t, common.ACCEPT)
+	aliceConn.expect(t, common.ACCEPT)
+
+	t.Run("対戦が破棄されると、ロビーに残っている参加者は全てのサーバでWAITINGに戻る。", func(t *testing.T) {
+		clock.Advance(BATTLE_LIFETIME + time.Minute)
+		bobConn.expectStatus(t, bob.GetID(), common.WAITING)
+		bobConn.expectStatus(t, alice.GetID(), common.WAITING)
+		aliceConn.expectStatus(t, bob.GetID(), common.WAITING)
+		aliceConn.expectStatus(t, alice.GetID(), common.WAITING)
+		for _, mr := range []*MatchingRoom{roomA, roomB} {
+			if s := statusOf(mr, bob.GetID()); s != WAITING {
+				t.Errorf("Expected: %s\n\t\t Actual: %s \n", WAITING, s)
+			}
+		}
+	})
+	t.Run("破棄した対戦の参加者のコンソールを削除する。", func(t *testing.T) {
+		var removed []string
+		for len(removed) < 2 {
+			select {
+			case name := <-consoles.removed:
+				removed = append(removed, name)
+			case <-time.After(time.Second):
+				t.Fatalf("timed out: removed=%v", removed)
+			}
+		}
+		sort.Strings(removed)
+		for i, id := range []string{bob.GetID(), alice.GetID()} {
+			if !strings.HasSuffix(removed[i], "-player-"+id) {
+				t.Errorf("Expected: %s\n\t\t Actual: %s \n", "*-player-"+id, removed[i])
+			}
+		}
+	})
+	t.Run("WAITINGに戻った参加者には改めて対戦を申請できる。", func(t *testing.T) {
+		roomA.message <- &playerMessage{bob, &common.MatchingMessage{Dest: alice.GetProfile(), Data: common.OFFER}}
+		aliceConn.expect(t, common.OFFER)
+	})
+}
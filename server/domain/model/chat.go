@@ -0,0 +1,86 @@
+package model
+
+import (
+	"github.com/taise-hub/shellgame-cli/common"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	MAX_CHAT_LENGTH  = 200              // チャットの本文の最大文字数
+	CHAT_RATE_LIMIT  = 5                // CHAT_RATE_WINDOWの間に送信できるチャットの数
+	CHAT_RATE_WINDOW = 10 * time.Second // チャットの送信数を数える期間
+)
+
+// チャットの本文から制御文字を取り除き、長さと送信の頻度を確認する。
+// 送信者が接続しているサーバでのみ確認できるように、MatchingRoom.Run()から発行前に呼び出す。
+func (mr *MatchingRoom) limitChat(p *MatchingPlayer, msg *common.MatchingMessage) error {
+	text := strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, msg.Text))
+	if text == "" {
+		return newMatchingError(common.ERR_INVALID_CHAT, "チャットの本文が空です。")
+	} else if n := utf8.RuneCountInString(text); n > MAX_CHAT_LENGTH {
+		return newMatchingError(common.ERR_INVALID_CHAT, "チャットは%d文字以内で入力してください。(%d文字)", MAX_CHAT_LENGTH, n)
+	}
+	if !p.allowChat(mr.now()) {
+		return newMatchingError(common.ERR_RATE_LIMITED, "チャットの送信が多すぎます。しばらく待ってから送信してください。")
+	}
+	msg.Text = text
+	return nil
+}
+
+// CHAT_RATE_WINDOWの間に送信したチャットがCHAT_RATE_LIMIT未満であれば送信を記録する。
+func (p *MatchingPlayer) allowChat(now time.Time) bool {
+	recent := p.chats[:0]
+	for _, sent := range p.chats {
+		if now.Sub(sent) < CHAT_RATE_WINDOW {
+			recent = append(recent, sent)
+		}
+	}
+	p.chats = recent
+	if len(p.chats) >= CHAT_RATE_LIMIT {
+		return false
+	}
+	p.chats = append(p.chats, now)
+	return true
+}
+
+// チャットの配信処理
+// CHATはロビーの全員に、DIRECT_CHATは受信者(Dest)のみに届ける。送信者にも確認のために同じメッセージを送り返す。
+func (mr *MatchingRoom) chat(msg *common.MatchingMessage) error {
+	mr.mu.RLock()
+	src := mr.Players[msg.Source.ID]
+	var dest *MatchingPlayer
+	if msg.Dest != nil {
+		dest = mr.Players[msg.Dest.ID]
+	}
+	mr.mu.RUnlock()
+	if src == nil {
+		return newMatchingError(common.ERR_PLAYER_NOT_FOUND, "ルームに参加していません。")
+	}
+	if msg.Data == common.CHAT {
+		reply := *msg
+		reply.Dest = nil
+		for id := range mr.locals {
+			mr.send(id, &reply)
+		}
+		return nil
+	}
+	if dest == nil {
+		return newMatchingError(common.ERR_PLAYER_NOT_FOUND, "相手のプレイヤーが見つかりません。ルームから退出した可能性があります。")
+	} else if dest.GetID() == src.GetID() {
+		return newMatchingError(common.ERR_INVALID_CHAT, "自分にチャットを送信することはできません。")
+	}
+	// 受信者の名前はクライアントが送信した値ではなく、ルームに参加しているプレイヤーのものとする。
+	reply := *msg
+	reply.Dest = dest.GetProfile()
+	mr.send(dest.GetID(), &reply)
+	mr.send(src.GetID(), &reply)
+	return nil
+}
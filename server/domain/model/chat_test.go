@@ -0,0 +1,91 @@
+package model
+
+import (
+	"github.com/taise-hub/shellgame-cli/common"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatchingRoomChat(t *testing.T) {
+	bus := newFakeBus()
+	clock := &fakeClock{now: time.Now()}
+	roomA := NewMatchingRoom("beginner", bus)
+	roomA.now = clock.Now
+	runTestRoom(t, roomA, bus)
+	roomB := startTestRoom(t, "beginner", bus)
+
+	bob, bobConn := joinTestRoom(roomA, "1", "bob")
+	alice, aliceConn := joinTestRoom(roomB, "2", "alice")
+	_, carolConn := joinTestRoom(roomB, "3", "carol")
+	bobConn.expect(t, common.JOIN)
+	bobConn.expect(t, common.JOIN)
+
+	chat := func(data common.MatchingMessageData, dest *MatchingPlayer, text string) {
+		msg := &common.MatchingMessage{Data: data, Text: text}
+		if dest != nil {
+			msg.Dest = dest.GetProfile()
+		}
+		roomA.message <- &playerMessage{bob, msg}
+	}
+
+	t.Run("ロビーのチャットは他のサーバに接続しているプレイヤーを含む全員に届く。", func(t *testing.T) {
+		chat(common.CHAT, nil, "  よろしく\n")
+		for _, conn := range []*fakeConn{bobConn, aliceConn, carolConn} {
+			msg := conn.expect(t, common.CHAT)
+			if msg.Source.ID != bob.GetID() || msg.Text != "よろしく" {
+				t.Errorf("Expected: %s よろしく\n\t\t Actual: %s %q \n", bob.GetID(), msg.Source.ID, msg.Text)
+			}
+		}
+	})
+	t.Run("ダイレクトメッセージは受信者と送信者にのみ届く。", func(t *testing.T) {
+		chat(common.DIRECT_CHAT, alice, "対戦しよう")
+		for _, conn := range []*fakeConn{bobConn, aliceConn} {
+			msg := conn.expect(t, common.DIRECT_CHAT)
+			if msg.Dest.ID != alice.GetID() || msg.Text != "対戦しよう" {
+				t.Errorf("Expected: %s 対戦しよう\n\t\t Actual: %s %q \n", alice.GetID(), msg.Dest.ID, msg.Text)
+			}
+		}
+		// carolにはダイレクトメッセージの後に送信したチャットが先に届く。
+		chat(common.CHAT, nil, "またね")
+		if msg := carolConn.expect(t, common.CHAT); msg.Text != "またね" {
+			t.Errorf("Expected: またね\n\t\t Actual: %q \n", msg.Text)
+		}
+		bobConn.expect(t, common.CHAT)
+	})
+
+	tests := []struct {
+		name string
+		data common.MatchingMessageData
+		dest *MatchingPlayer
+		text string
+	}{
+		{name: "空のチャットは拒否される。", data: common.CHAT, text: " \t"},
+		{name: "長すぎるチャットは拒否される。", data: common.CHAT, text: strings.Repeat("あ", MAX_CHAT_LENGTH+1)},
+		{name: "自分へのダイレクトメッセージは拒否される。", data: common.DIRECT_CHAT, dest: bob, text: "test"},
+		{name: "ルームにいないプレイヤーへのダイレクトメッセージは拒否される。", data: common.DIRECT_CHAT, dest: NewMatchingPlayer("4", "dave", nil), text: "test"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chat(tt.data, tt.dest, tt.text)
+			if msg := bobConn.expect(t, common.ERROR); msg.Reason == "" {
+				t.Errorf("Expected: reason\n\t\t Actual: %q \n", msg.Reason)
+			}
+		})
+	}
+
+	t.Run("短時間に送信しすぎたチャットは拒否され、時間が経つと再び送信できる。", func(t *testing.T) {
+		clock.Advance(CHAT_RATE_WINDOW)
+		for i := 0; i < CHAT_RATE_LIMIT; i++ {
+			chat(common.CHAT, nil, "spam")
+			bobConn.expect(t, common.CHAT)
+		}
+		chat(common.CHAT, nil, "spam")
+		bobConn.expect(t, common.ERROR)
+		clock.Advance(CHAT_RATE_WINDOW)
+		chat(common.CHAT, nil, "ok")
+		if msg := bobConn.expect(t, common.CHAT); msg.Text != "ok" {
+			t.Errorf("Expected: ok\n\t\t Actual: %q \n", msg.Text)
+		}
+	})
+}
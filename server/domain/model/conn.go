@@ -1,9 +1,13 @@
 package model
 
 import (
+	"errors"
 	"github.com/taise-hub/shellgame-cli/common"
 )
 
+// 相手からの応答が途絶えた場合にReadが返すエラー
+var ErrHeartbeatTimeout = errors.New("heartbeat timeout")
+
 type Conn interface {
 	Close() error
 	Write(common.Message) error
@@ -0,0 +1,111 @@
+package model
+
+import (
+	"fmt"
+	"github.com/taise-hub/shellgame-cli/common"
+	"log"
+	"strings"
+	"time"
+)
+
+// ロビーで操作のないプレイヤーの扱い。いずれも最後に操作した時刻からの経過時間で判断する。
+type IdlePolicy struct {
+	Away       time.Duration // AWAYにするまでの時間
+	Disconnect time.Duration // ロビーから切断するまでの時間
+	Warning    time.Duration // 切断をどれだけ前に予告するか
+}
+
+var DEFAULT_IDLE_POLICY = IdlePolicy{Away: 5 * time.Minute, Disconnect: 30 * time.Minute, Warning: time.Minute}
+
+// "AWAYにするまでの時間:切断するまでの時間:予告"の形式で指定する。
+// 例: "5m:30m:1m"
+func ParseIdlePolicy(spec string) (IdlePolicy, error) {
+	fields := strings.Split(spec, ":")
+	if len(fields) != 3 {
+		return IdlePolicy{}, fmt.Errorf("invalid idle policy %q", spec)
+	}
+	var ds []time.Duration
+	for _, f := range fields {
+		d, err := time.ParseDuration(f)
+		if err != nil || d <= 0 {
+			return IdlePolicy{}, fmt.Errorf("invalid idle policy %q", spec)
+		}
+		ds = append(ds, d)
+	}
+	policy := IdlePolicy{Away: ds[0], Disconnect: ds[1], Warning: ds[2]}
+	if policy.Away >= policy.Disconnect || policy.Warning >= policy.Disconnect {
+		return IdlePolicy{}, fmt.Errorf("invalid idle policy %q: away and warning must be shorter than disconnect", spec)
+	}
+	return policy, nil
+}
+
+// プレイヤーが操作したことを記録する。AWAYにしていた場合はWAITINGに戻す。
+// 操作したメッセージより先に届くように、メッセージを発行する前に呼び出す。
+func (mr *MatchingRoom) touch(p *MatchingPlayer) {
+	p.active = mr.now()
+	p.warned = false
+	if p.away {
+		p.away = false
+		mr.publishStatus(p, common.WAITING)
+	}
+}
+
+// このサーバに接続しているプレイヤーのうち、操作のないプレイヤーをAWAYにし、切断を予告し、切断する。
+// 対戦申請やランダム対戦、パーティ、対戦の最中は相手を待っている状態のため、操作がなくても活動中とみなす。
+func (mr *MatchingRoom) sweepIdle(now time.Time) {
+	for _, p := range mr.locals {
+		mr.mu.RLock()
+		s := p.GetStatus()
+		mr.mu.RUnlock()
+		if s != WAITING && s != AWAY {
+			p.active = now
+			p.warned = false
+			p.away = false
+			continue
+		}
+		idle := now.Sub(p.active)
+		switch {
+		case idle >= mr.idle.Disconnect:
+			log.Printf("[+] %s was idle for %v and disconnected.\n", p.GetName(), idle.Round(time.Second))
+			// 送信済みのメッセージを書き込んだ後にWritePumpが接続を閉じ、ReadPumpの終了で登録が解除される。
+			mr.send(p.GetID(), newErrorMessage("", newMatchingError(common.ERR_IDLE_TIMEOUT, "しばらく操作がなかったため、ロビーから切断しました。")))
+			mr.leave(p)
+			continue
+		case idle >= mr.idle.Disconnect-mr.idle.Warning && !p.warned:
+			p.warned = true
+			deadline := p.active.Add(mr.idle.Disconnect)
+			mr.send(p.GetID(), &common.MatchingMessage{Data: common.IDLE_WARNING, Deadline: &deadline})
+		}
+		if s == WAITING && !p.away && idle >= mr.idle.Away {
+			p.away = true
+			mr.publishStatus(p, common.AWAY)
+		}
+	}
+}
+
+// 全てのサーバでプレイヤーの状態を変えられるように、状態の変化をSTATUSとして発行する。
+func (mr *MatchingRoom) publishStatus(p *MatchingPlayer, status common.MatchingStatus) {
+	mr.publish(&common.MatchingMessage{
+		Source: p.GetProfile(),
+		Data:   common.STATUS,
+		Player: &common.MatchingPlayer{Profile: p.GetProfile(), Status: status},
+	})
+}
+
+// WAITINGのプレイヤー(Source)をAWAYに、AWAYのプレイヤーと対戦を終えたIN_BATTLEのプレイヤーをWAITINGに戻す。
+// 発行してから届くまでに対戦申請などで状態が変わっていた場合は何もしない。
+func (mr *MatchingRoom) HandleStatus(msg *common.MatchingMessage) error {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	p, ok := mr.Players[msg.Source.ID]
+	if !ok || msg.Player == nil {
+		return nil
+	}
+	switch {
+	case msg.Player.Status == common.AWAY && p.GetStatus() == WAITING:
+		p.SetStatus(AWAY)
+	case msg.Player.Status == common.WAITING && (p.GetStatus() == AWAY || p.GetStatus() == IN_BATTLE):
+		p.SetStatus(WAITING)
+	}
+	return nil
+}
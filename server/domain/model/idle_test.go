@@ -0,0 +1,114 @@
+package model
+
+import (
+	"github.com/taise-hub/shellgame-cli/common"
+	"testing"
+	"time"
+)
+
+// idのプレイヤーがstatusになったことを通知するSTATUSが届くまで待つ。
+func (c *fakeConn) expectStatus(t *testing.T, id string, status common.MatchingStatus) *common.MatchingMessage {
+	t.Helper()
+	for {
+		msg := c.expect(t, common.STATUS)
+		if msg.Player != nil && msg.Player.Profile.ID == id && msg.Player.Status == status {
+			return msg
+		}
+	}
+}
+
+func TestMatchingRoomIdle(t *testing.T) {
+	bus := newFakeBus()
+	clock := &fakeClock{now: time.Now()}
+	roomA := NewMatchingRoom("beginner", bus)
+	roomA.SetIdlePolicy(IdlePolicy{Away: 5 * time.Minute, Disconnect: 30 * time.Minute, Warning: time.Minute})
+	roomA.now = clock.Now
+	roomA.sweep = 10 * time.Millisecond
+	runTestRoom(t, roomA, bus)
+	roomB := startTestRoom(t, "beginner", bus)
+
+	bob, bobConn := joinTestRoom(roomA, "1", "bob")
+	_, aliceConn := joinTestRoom(roomB, "2", "alice")
+	carol, carolConn := joinTestRoom(roomA, "3", "carol")
+	bobConn.expect(t, common.JOIN)
+	bobConn.expect(t, common.JOIN)
+	aliceConn.expect(t, common.JOIN)
+	roomA.message <- &playerMessage{carol, &common.MatchingMessage{Data: common.QUEUE}}
+	carolConn.expect(t, common.QUEUE)
+
+	t.Run("操作のないプレイヤーはAWAYになり、他のサーバのプレイヤーにも通知される。", func(t *testing.T) {
+		clock.Advance(5 * time.Minute)
+		aliceConn.expectStatus(t, bob.GetID(), common.AWAY)
+		bobConn.expectStatus(t, bob.GetID(), common.AWAY)
+	})
+	t.Run("AWAYのプレイヤーが操作するとWAITINGに戻ってから処理される。", func(t *testing.T) {
+		roomA.message <- &playerMessage{bob, &common.MatchingMessage{Data: common.CHAT, Text: "ただいま"}}
+		aliceConn.expectStatus(t, bob.GetID(), common.WAITING)
+		if msg := aliceConn.expect(t, common.CHAT); msg.Text != "ただいま" {
+			t.Errorf("Expected: ただいま\n\t\t Actual: %s \n", msg.Text)
+		}
+	})
+	active := clock.Now()
+	t.Run("切断される前に予告が届く。", func(t *testing.T) {
+		clock.Advance(29 * time.Minute)
+		msg := bobConn.expect(t, common.IDLE_WARNING)
+		if expected := active.Add(30 * time.Minute); msg.Deadline == nil || !msg.Deadline.Equal(expected) {
+			t.Errorf("Expected: %v\n\t\t Actual: %v \n", expected, msg.Deadline)
+		}
+	})
+	t.Run("操作のないまま切断されるプレイヤーには理由が届き、接続が閉じられる。", func(t *testing.T) {
+		clock.Advance(time.Minute)
+		if msg := bobConn.expect(t, common.ERROR); msg.Code != common.ERR_IDLE_TIMEOUT {
+			t.Errorf("Expected: %s\n\t\t Actual: %s \n", common.ERR_IDLE_TIMEOUT, msg.Code)
+		}
+		select {
+		case <-bobConn.closed:
+		case <-time.After(time.Second):
+			t.Errorf("Expected: closed\n\t\t Actual: open \n")
+		}
+	})
+	t.Run("切断されたプレイヤーの退室が他のサーバのプレイヤーにも届く。", func(t *testing.T) {
+		if msg := aliceConn.expect(t, common.LEAVE); msg.Source.ID != bob.GetID() {
+			t.Errorf("Expected: %s\n\t\t Actual: %s \n", bob.GetID(), msg.Source.ID)
+		}
+	})
+	t.Run("ランダム対戦の相手を待っているプレイヤーは操作がなくてもAWAYにならず、切断されない。", func(t *testing.T) {
+		roomA.mu.RLock()
+		p, ok := roomA.Players[carol.GetID()]
+		var s MatchingStatus
+		if ok {
+			s = p.GetStatus()
+		}
+		roomA.mu.RUnlock()
+		if !ok || s != QUEUED {
+			t.Errorf("Expected: %s\n\t\t Actual: %v %s \n", QUEUED, ok, s)
+		}
+	})
+}
+
+func TestParseIdlePolicy(t *testing.T) {
+	tests := []struct {
+		spec     string
+		expected IdlePolicy
+		err      bool
+	}{
+		{spec: "5m:30m:1m", expected: IdlePolicy{Away: 5 * time.Minute, Disconnect: 30 * time.Minute, Warning: time.Minute}},
+		{spec: "10m:1h:2m", expected: IdlePolicy{Away: 10 * time.Minute, Disconnect: time.Hour, Warning: 2 * time.Minute}},
+		{spec: "30m:5m:1m", err: true},
+		{spec: "5m:30m:30m", err: true},
+		{spec: "5m:30m", err: true},
+		{spec: "5m:30m:-1m", err: true},
+		{spec: "five:30m:1m", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			actual, err := ParseIdlePolicy(tt.spec)
+			if (err != nil) != tt.err {
+				t.Fatalf("Expected: %v\n\t\t Actual: %v \n", tt.err, err)
+			}
+			if actual != tt.expected {
+				t.Errorf("Expected: %+v\n\t\t Actual: %+v \n", tt.expected, actual)
+			}
+		})
+	}
+}
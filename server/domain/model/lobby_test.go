@@ -0,0 +1,98 @@
+package model
+
+import (
+	"github.com/taise-hub/shellgame-cli/common"
+	"testing"
+	"time"
+)
+
+func TestMatchingRoomLobby(t *testing.T) {
+	bus := newFakeBus()
+	clock := &fakeClock{now: time.Date(2022, 7, 1, 12, 0, 0, 0, time.UTC)}
+	roomA := NewMatchingRoom("beginner", bus)
+	roomA.now = clock.Now
+	runTestRoom(t, roomA, bus)
+	roomB := startTestRoom(t, "beginner", bus)
+
+	// carolは他のサーバで後から参加した扱いになる。
+	_, carolConn := joinTestRoom(roomB, "3", "carol")
+	bob, bobConn := joinRatedTestRoom(roomA, "1", "bob", 1600)
+	carolConn.expect(t, common.JOIN)
+	clock.Advance(time.Minute)
+	alice, _ := joinTestRoom(roomA, "2", "alice")
+
+	t.Run("参加の通知には状態と参加した時刻が含まれる。", func(t *testing.T) {
+		msg := carolConn.expect(t, common.JOIN)
+		if p := msg.Player; p == nil || p.Profile.ID != alice.GetID() || p.Status != common.WAITING || !p.Joined.Equal(clock.Now()) {
+			t.Errorf("Expected: %s waiting at %v\n\t\t Actual: %+v \n", alice.GetID(), clock.Now(), p)
+		}
+	})
+	t.Run("全てのサーバで同じ順序、同じ参加時刻のロビーを返す。", func(t *testing.T) {
+		// bobにaliceの参加が届けば、どちらのサーバもcarol, bob, aliceの参加を処理している。
+		for bobConn.expect(t, common.JOIN).Source.ID != alice.GetID() {
+		}
+		for _, mr := range []*MatchingRoom{roomA, roomB} {
+			lobby := mr.GetLobby()
+			if len(lobby) != 3 || lobby[0].Profile.ID != bob.GetID() || lobby[1].Profile.ID != alice.GetID() {
+				t.Fatalf("Expected: bob, alice, carol\n\t\t Actual: %+v \n", lobby)
+			}
+			if lobby[0].Profile.Rating != 1600 || !lobby[1].Joined.Equal(clock.Now()) {
+				t.Errorf("Expected: rating 1600, joined at %v\n\t\t Actual: %+v, %+v \n", clock.Now(), lobby[0], lobby[1])
+			}
+		}
+	})
+	t.Run("状態が変化したプレイヤーは他のサーバのプレイヤーにも通知される。", func(t *testing.T) {
+		roomA.message <- &playerMessage{bob, &common.MatchingMessage{Dest: alice.GetProfile(), Data: common.OFFER}}
+		msg := carolConn.expect(t, common.STATUS)
+		if p := msg.Player; p == nil || p.Profile.ID != bob.GetID() || p.Status != common.NEGOTIATING {
+			t.Errorf("Expected: %s negotiating\n\t\t Actual: %+v \n", bob.GetID(), p)
+		}
+		roomA.message <- &playerMessage{alice, &common.MatchingMessage{Dest: bob.GetProfile(), Data: common.ACCEPT}}
+		for _, id := range []string{bob.GetID(), alice.GetID()} {
+			msg := carolConn.expect(t, common.STATUS)
+			if p := msg.Player; p.Status != common.IN_BATTLE {
+				t.Errorf("%s Expected: %s\n\t\t Actual: %s \n", id, common.IN_BATTLE, p.Status)
+			}
+		}
+		bobConn.expect(t, common.ACCEPT)
+	})
+}
+
+func TestMatchingRoomSnapshot(t *testing.T) {
+	bus := newFakeBus()
+	mr := startTestRoom(t, "beginner", bus)
+	bob, bobConn := joinTestRoom(mr, "1", "bob")
+
+	t.Run("参加したプレイヤーには自身を含むロビーの全員の状態が届く。", func(t *testing.T) {
+		msg := bobConn.expect(t, common.SNAPSHOT)
+		if len(msg.Lobby) != 1 || msg.Lobby[0].Profile.ID != bob.GetID() || msg.Seq != 1 {
+			t.Errorf("Expected: [bob] seq 1\n\t\t Actual: %+v seq %d \n", msg.Lobby, msg.Seq)
+		}
+	})
+	alice, aliceConn := joinTestRoom(mr, "2", "alice")
+	t.Run("ロビーの変化には連続した通し番号が付く。", func(t *testing.T) {
+		if msg := bobConn.expect(t, common.JOIN); msg.Seq != 2 {
+			t.Errorf("Expected: 2\n\t\t Actual: %d \n", msg.Seq)
+		}
+		if msg := aliceConn.expect(t, common.SNAPSHOT); len(msg.Lobby) != 2 || msg.Seq != 2 {
+			t.Errorf("Expected: 2 players seq 2\n\t\t Actual: %d players seq %d \n", len(msg.Lobby), msg.Seq)
+		}
+		mr.message <- &playerMessage{bob, &common.MatchingMessage{Dest: alice.GetProfile(), Data: common.OFFER}}
+		// 状態が変化したプレイヤー自身にも通知される。
+		if msg := bobConn.expect(t, common.STATUS); msg.Seq != 3 || msg.Player.Status != common.NEGOTIATING {
+			t.Errorf("Expected: negotiating seq 3\n\t\t Actual: %s seq %d \n", msg.Player.Status, msg.Seq)
+		}
+	})
+	t.Run("要求したプレイヤーにのみロビーの全員の状態が送り直される。", func(t *testing.T) {
+		mr.message <- &playerMessage{alice, &common.MatchingMessage{Data: common.SNAPSHOT}}
+		msg := aliceConn.expect(t, common.SNAPSHOT)
+		if msg.Seq != 3 || len(msg.Lobby) != 2 || msg.Lobby[0].Status != common.NEGOTIATING {
+			t.Errorf("Expected: bob negotiating seq 3\n\t\t Actual: %+v seq %d \n", msg.Lobby, msg.Seq)
+		}
+		for len(bobConn.written) > 0 {
+			if msg := <-bobConn.written; msg.Data == common.SNAPSHOT {
+				t.Errorf("unexpected message: %#v", msg)
+			}
+		}
+	})
+}
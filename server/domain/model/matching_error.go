@@ -0,0 +1,54 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"github.com/taise-hub/shellgame-cli/common"
+)
+
+// プレイヤーに返すエラー
+// Codeでクライアントが種類を判別し、Reasonをそのまま表示する。
+type MatchingError struct {
+	Code   common.ErrorCode
+	Reason string
+}
+
+func newMatchingError(code common.ErrorCode, format string, a ...any) *MatchingError {
+	return &MatchingError{Code: code, Reason: fmt.Sprintf(format, a...)}
+}
+
+func (e *MatchingError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Reason)
+}
+
+// WAITING以外の状態のプレイヤーが応じられない理由
+var busyReasons = map[MatchingStatus]string{
+	NEGOTIATING: "対戦申請の交渉中の",
+	IN_BATTLE:   "対戦中の",
+	QUEUED:      "ランダム対戦の相手を待っている",
+	IN_PARTY:    "パーティに参加している",
+	AWAY:        "離席中の",
+}
+
+// whoのプレイヤーがsの状態のために応じられないことを示す。
+func busyError(who string, s MatchingStatus) *MatchingError {
+	code := common.ERR_PLAYER_BUSY
+	if s == NEGOTIATING {
+		code = common.ERR_ALREADY_NEGOTIATING
+	}
+	return newMatchingError(code, "%sは%sため応じられません。", who, busyReasons[s])
+}
+
+// IDのあるメッセージに対するエラーはNACKとして、IDのないメッセージに対するエラーはERRORとして返す。
+// MatchingError以外のエラーは内容をプレイヤーに見せず、サーバの内部のエラーとして返す。
+func newErrorMessage(id string, err error) *common.MatchingMessage {
+	var me *MatchingError
+	if !errors.As(err, &me) {
+		me = newMatchingError(common.ERR_INTERNAL, "サーバでエラーが発生しました。")
+	}
+	msg := &common.MatchingMessage{ID: id, Data: common.ERROR, Code: me.Code, Reason: me.Reason}
+	if id != "" {
+		msg.Data = common.NACK
+	}
+	return msg
+}
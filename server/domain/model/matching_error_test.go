@@ -0,0 +1,77 @@
+package model
+
+import (
+	"github.com/taise-hub/shellgame-cli/common"
+	"testing"
+	"time"
+)
+
+func TestMatchingRoomErrorCodes(t *testing.T) {
+	bus := newFakeBus()
+	clock := &fakeClock{now: time.Now()}
+	mr := NewMatchingRoom("beginner", bus)
+	mr.now = clock.Now
+	mr.capacity = 3
+	runTestRoom(t, mr, bus)
+	bob, bobConn := joinTestRoom(mr, "1", "bob")
+	alice, aliceConn := joinTestRoom(mr, "2", "alice")
+	carol, carolConn := joinTestRoom(mr, "3", "carol")
+	bobConn.expect(t, common.JOIN)
+	bobConn.expect(t, common.JOIN)
+
+	t.Run("定員に達したルームには参加できず、切断される。", func(t *testing.T) {
+		_, daveConn := joinTestRoom(mr, "4", "dave")
+		msg := daveConn.expect(t, common.ERROR)
+		if msg.Code != common.ERR_ROOM_FULL || msg.Reason == "" {
+			t.Errorf("Expected: %s\n\t\t Actual: %s %q \n", common.ERR_ROOM_FULL, msg.Code, msg.Reason)
+		}
+		select {
+		case <-daveConn.closed:
+		case <-time.After(time.Second):
+			t.Errorf("Expected: closed\n\t\t Actual: open \n")
+		}
+	})
+
+	t.Run("回答期限はルームの時計から決める。", func(t *testing.T) {
+		mr.message <- &playerMessage{bob, &common.MatchingMessage{Dest: alice.GetProfile(), Data: common.OFFER}}
+		msg := aliceConn.expect(t, common.OFFER)
+		if expected := clock.Now().Add(OFFER_TIMEOUT); msg.Deadline == nil || !msg.Deadline.Equal(expected) {
+			t.Errorf("Expected: %v\n\t\t Actual: %v \n", expected, msg.Deadline)
+		}
+	})
+
+	tests := []struct {
+		name     string
+		src      *MatchingPlayer
+		conn     *fakeConn
+		msg      *common.MatchingMessage
+		advance  time.Duration
+		expected common.ErrorCode
+	}{
+		{name: "自分には対戦を申請できない。", src: carol, conn: carolConn, msg: &common.MatchingMessage{Dest: carol.GetProfile(), Data: common.OFFER}, expected: common.ERR_INVALID_MESSAGE},
+		{name: "交渉中のプレイヤーには対戦を申請できない。", src: carol, conn: carolConn, msg: &common.MatchingMessage{Dest: bob.GetProfile(), Data: common.OFFER}, expected: common.ERR_ALREADY_NEGOTIATING},
+		{name: "ルームにいないプレイヤーには対戦を申請できない。", src: carol, conn: carolConn, msg: &common.MatchingMessage{Dest: &common.Profile{ID: "9"}, Data: common.OFFER}, expected: common.ERR_PLAYER_NOT_FOUND},
+		{name: "受け取っていない対戦申請は承諾できない。", src: carol, conn: carolConn, msg: &common.MatchingMessage{Dest: bob.GetProfile(), Data: common.ACCEPT}, expected: common.ERR_OFFER_NOT_FOUND},
+		{name: "パーティのリーダー以外は個人戦を開始できない。", src: carol, conn: carolConn, msg: &common.MatchingMessage{Data: common.START, Battle: &common.Battle{Mode: common.FREE_FOR_ALL}}, expected: common.ERR_NOT_PERMITTED},
+		{name: "プレイヤーが送信できないメッセージは拒否する。", src: carol, conn: carolConn, msg: &common.MatchingMessage{Data: common.MATCHED}, expected: common.ERR_INVALID_MESSAGE},
+		{name: "回答期限を過ぎた対戦申請は期限切れの前でも承諾できない。", src: alice, conn: aliceConn, msg: &common.MatchingMessage{Dest: bob.GetProfile(), Data: common.ACCEPT}, advance: OFFER_TIMEOUT + time.Minute, expected: common.ERR_OFFER_EXPIRED},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock.Advance(tt.advance)
+			mr.message <- &playerMessage{tt.src, tt.msg}
+			msg := tt.conn.expect(t, common.ERROR)
+			if msg.Code != tt.expected || msg.Reason == "" {
+				t.Errorf("Expected: %s\n\t\t Actual: %s %q \n", tt.expected, msg.Code, msg.Reason)
+			}
+		})
+	}
+	t.Run("チャットを送信しすぎると制限される。", func(t *testing.T) {
+		for i := 0; i <= CHAT_RATE_LIMIT; i++ {
+			mr.message <- &playerMessage{carol, &common.MatchingMessage{Data: common.CHAT, Text: "hi"}}
+		}
+		if msg := carolConn.expect(t, common.ERROR); msg.Code != common.ERR_RATE_LIMITED {
+			t.Errorf("Expected: %s\n\t\t Actual: %s \n", common.ERR_RATE_LIMITED, msg.Code)
+		}
+	})
+}
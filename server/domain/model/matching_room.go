@@ -1,44 +1,219 @@
 package model
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/taise-hub/shellgame-cli/common"
+	"github.com/taise-hub/shellgame-cli/server/domain/repository"
 	"log"
+	"sort"
 	"sync"
+	"time"
 )
 
-var (
-	mu sync.Mutex
-	matchingRoom *MatchingRoom = &MatchingRoom{
+// ロビー(beginner, advanced等)ごとに存在し、名前で識別する。
+// 対戦待ち状態の管理を行う。
+// 複数のサーバでイベントを共有する場合、各サーバのMatchingRoomはMatchingEventBusから同じ順序でイベントを受け取り、
+// 全てのプレイヤーの状態を同じように更新する。メッセージの送信は自身に接続しているプレイヤーに対してのみ行う。
+// 購読を開始する前に参加していたプレイヤーは、SYNCに応えて他のサーバが発行するSNAPSHOTで受け取る。
+type MatchingRoom struct {
+	Name       string
+	Players    map[string]*MatchingPlayer   // 誰がMatchigRoomにいるのか把握するために利用。他のサーバに接続しているプレイヤーも含む。
+	locals     map[string]*MatchingPlayer   // このサーバに接続しているプレイヤー
+	offers     map[string]*offer            // 交渉中の申請。申請者のIDから引く。
+	inbox      map[string]map[string]*offer // 受信者ごとの回答待ちの申請。受信者、申請者のIDの順に引く。
+	queue      []*queueEntry                // ランダム対戦の待ち行列。参加した順に並ぶ。
+	parties    map[string]*party            // パーティ。メンバーのIDから引く。
+	battles    map[string]*Battle           // このルームで開始した対戦。対戦のIDから引く。
+	fighters   map[string]*MatchingPlayer   // 対戦チャンネルでこのサーバに接続しているプレイヤー
+	handled    map[string][]*handledMessage // プレイヤーごとのIDのあるメッセージを処理した結果。Run()でのみ扱う。
+	departed   []string                     // 処理した結果を覚えている退室したプレイヤー。退室した順に並ぶ。Run()でのみ扱う。
+	mu         sync.RWMutex                 // Players, offers, inbox, queue, parties, battlesとプレイヤーのステータスを保護する。
+	bus        repository.MatchingEventBus
+	timeout    time.Duration    // 対戦申請の回答期限
+	sweep      time.Duration    // 回答期限を過ぎた対戦申請を確認する間隔
+	capacity   int              // ルームに参加できるプレイヤー数の上限
+	window     RatingWindow     // ランダム対戦で組み合わせを許容するレーティング差
+	idle       IdlePolicy       // ロビーで操作のないプレイヤーの扱い
+	host       string           // プライベートルームを作成したプレイヤーのID。公開されているルームでは空
+	questions  string           // 対戦で出題する問題の組
+	seq        uint64           // このサーバで処理したロビーの変化(JOIN, LEAVE, STATUS)の通し番号。Run()でのみ扱う。
+	now        func() time.Time // ランダム対戦の待ち時間を計る時計
+	message    chan *playerMessage
+	register   chan *MatchingPlayer
+	unregister chan *MatchingPlayer
+	members    repository.MatchingRoomRepository // このサーバに接続しているプレイヤーの参加を記録する。nilであれば記録しない。
+	leaving    map[string]string                 // 退室を発行したこのサーバのプレイヤーの接続の識別子。IDから引く。Run()でのみ扱う。
+	consoles   repository.ConsoleRepository      // 破棄した対戦のコンソールを削除する。nilであれば削除しない。
+	restored   map[string]bool                   // 起動時に記録から読み込んだ、接続し直すのを待っているプレイヤーのID。Run()でのみ扱う。
+	restoring  time.Time                         // restoredのプレイヤーを待つ期限。Run()でのみ扱う。
+	dropped    map[string]*droppedPlayer         // 切断されて接続し直すのを待っているこのサーバのプレイヤー。IDから引く。Run()でのみ扱う。
+	grace      time.Duration                     // 切断されたプレイヤーが接続し直すのを待つ時間。0であればすぐに退室させる。
+	vacancy    time.Duration                     // 誰もいない状態がこの時間続いたらRun()を終了する。0であれば終了しない。
+	emptied    time.Time                         // 誰もいなくなった時刻。誰かいる場合はゼロ値。Run()でのみ扱う。
+	done       chan struct{}                     // Run()が終了すると閉じる。
+	finishing  map[string]*droppedPlayer         // 対戦チャンネルから切断されて接続し直すのを待っているこのサーバの対戦の参加者。IDから引く。Run()でのみ扱う。
+}
+
+// 終了したルームに参加しようとした場合のエラー
+var ErrRoomClosed = errors.New("matching room is closed")
+
+const (
+	OFFER_TIMEOUT        = 3 * time.Minute  // 対戦申請の回答期限
+	OFFER_SWEEP_INTERVAL = time.Second      // 回答期限を過ぎた対戦申請を確認する間隔
+	MAX_ROOM_PLAYERS     = 200              // ルームに参加できるプレイヤー数の上限
+	RESTORE_GRACE_PERIOD = time.Minute      // 起動前に参加していたプレイヤーが接続し直すのを待つ時間
+	RESUME_GRACE_PERIOD  = 30 * time.Second // 切断されたプレイヤーが接続し直すのを待つ時間
+)
+
+// プレイヤーがこのサーバに送信したメッセージ
+type playerMessage struct {
+	player *MatchingPlayer
+	msg    *common.MatchingMessage
+}
+
+// プレイヤーが送信することのできるメッセージ
+var playerMessageData = map[common.MatchingMessageData]bool{
+	common.OFFER:        true,
+	common.CANCEL_OFFER: true,
+	common.ACCEPT:       true,
+	common.DENY:         true,
+	common.QUEUE:        true,
+	common.LEAVE_QUEUE:  true,
+	common.PARTY_INVITE: true,
+	common.PARTY_JOIN:   true,
+	common.PARTY_LEAVE:  true,
+	common.START:        true,
+	common.CHAT:         true,
+	common.DIRECT_CHAT:  true,
+}
+
+// 申請者(from)から受信者(to)への対戦申請
+type offer struct {
+	from     string
+	to       string
+	deadline time.Time
+	shared   bool // 承諾された場合にチームメイトで同じコンテナを共有するかどうか
+	expiring bool // 期限切れのイベントを発行済みかどうか
+}
+
+func NewMatchingRoom(name string, bus repository.MatchingEventBus) *MatchingRoom {
+	return &MatchingRoom{
+		Name:       name,
 		Players:    make(map[string]*MatchingPlayer),
-		message:    make(chan *common.MatchingMessage),
+		locals:     make(map[string]*MatchingPlayer),
+		offers:     make(map[string]*offer),
+		inbox:      make(map[string]map[string]*offer),
+		parties:    make(map[string]*party),
+		battles:    make(map[string]*Battle),
+		fighters:   make(map[string]*MatchingPlayer),
+		handled:    make(map[string][]*handledMessage),
+		leaving:    make(map[string]string),
+		dropped:    make(map[string]*droppedPlayer),
+		finishing:  make(map[string]*droppedPlayer),
+		done:       make(chan struct{}),
+		grace:      RESUME_GRACE_PERIOD,
+		bus:        bus,
+		timeout:    OFFER_TIMEOUT,
+		sweep:      OFFER_SWEEP_INTERVAL,
+		capacity:   MAX_ROOM_PLAYERS,
+		window:     DEFAULT_RATING_WINDOW,
+		idle:       DEFAULT_IDLE_POLICY,
+		questions:  defaultQuestionSet(name),
+		now:        time.Now,
+		message:    make(chan *playerMessage),
 		register:   make(chan *MatchingPlayer),
 		unregister: make(chan *MatchingPlayer),
 	}
-)
+}
 
-// shellgame-cliサーバ上で一つだけ存在。
-// 対戦待ち状態の管理を行う。
-type MatchingRoom struct {
-	Players    map[string]*MatchingPlayer // 誰がMatchigRoomにいるのか把握するために利用。
-	message    chan *common.MatchingMessage
-	register   chan *MatchingPlayer
-	unregister chan *MatchingPlayer
+// Run()を呼び出す前に設定する。
+func (mr *MatchingRoom) SetRatingWindow(w RatingWindow) {
+	mr.window = w
+}
+
+// Run()を呼び出す前に設定する。
+func (mr *MatchingRoom) SetIdlePolicy(p IdlePolicy) {
+	mr.idle = p
+}
+
+// Run()を呼び出す前に設定する。
+func (mr *MatchingRoom) SetMembers(repo repository.MatchingRoomRepository) {
+	mr.members = repo
+}
+
+// Run()を呼び出す前に設定する。
+func (mr *MatchingRoom) SetConsoles(repo repository.ConsoleRepository) {
+	mr.consoles = repo
+}
+
+// Run()を呼び出す前に設定する。
+func (mr *MatchingRoom) SetCapacity(n int) {
+	mr.capacity = n
+}
+
+// Run()を呼び出す前に設定する。
+func (mr *MatchingRoom) SetHost(id string) {
+	mr.host = id
 }
 
-func GetMatchingRoom() *MatchingRoom {
-	return matchingRoom
+func (mr *MatchingRoom) IsPrivate() bool {
+	return mr.host != ""
 }
 
-func (mr *MatchingRoom) GetRegisterChan() chan<- *MatchingPlayer {
-	return mr.register
+// Run()を呼び出す前に設定する。
+func (mr *MatchingRoom) SetQuestionSet(name string) {
+	mr.questions = name
+}
+
+// 公開されているルームでは、ルーム名と同じ名前の問題の組を出題する。
+func defaultQuestionSet(room string) string {
+	if IsQuestionSet(room) {
+		return room
+	}
+	return DEFAULT_QUESTION_SET
+}
+
+// Run()を呼び出す前に設定する。
+func (mr *MatchingRoom) SetVacancyTimeout(d time.Duration) {
+	mr.vacancy = d
+}
+
+// プレイヤーをルームに登録する。Run()が終了している場合はErrRoomClosedを返す。
+func (mr *MatchingRoom) Register(p *MatchingPlayer) error {
+	select {
+	case mr.register <- p:
+		return nil
+	case <-mr.done:
+		return ErrRoomClosed
+	}
 }
 
 func (mr *MatchingRoom) GetUnregisterChan() chan<- *MatchingPlayer {
 	return mr.unregister
 }
 
+// ロビーにいるプレイヤーの状態を参加した順に返す。
+func (mr *MatchingRoom) GetLobby() []*common.MatchingPlayer {
+	mr.mu.RLock()
+	defer mr.mu.RUnlock()
+	lobby := []*common.MatchingPlayer{}
+	for _, player := range mr.Players {
+		lobby = append(lobby, player.Summary())
+	}
+	sort.Slice(lobby, func(i, j int) bool {
+		if !lobby[i].Joined.Equal(lobby[j].Joined) {
+			return lobby[i].Joined.Before(lobby[j].Joined)
+		}
+		return lobby[i].Profile.ID < lobby[j].Profile.ID
+	})
+	return lobby
+}
+
 func (mr *MatchingRoom) GetMatchingPlayers() []*MatchingPlayer {
+	mr.mu.RLock()
+	defer mr.mu.RUnlock()
 	var players []*MatchingPlayer
 	for _, player := range mr.Players {
 		players = append(players, player)
@@ -46,174 +221,934 @@ func (mr *MatchingRoom) GetMatchingPlayers() []*MatchingPlayer {
 	return players
 }
 
-func (mr *MatchingRoom) Run() {
+// このサーバに接続しているプレイヤーの入退室とメッセージをMatchingEventBusに発行し、
+// MatchingEventBusから受け取ったイベントでルームの状態を更新する。
+// ctxが終了するまで、またはmr.vacancyの間誰もいない状態が続くまで処理を続ける。
+func (mr *MatchingRoom) Run(ctx context.Context) error {
+	defer close(mr.done)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	events, err := mr.bus.Subscribe(ctx, mr.Name)
+	if err != nil {
+		return err
+	}
+	// 購読を開始する前に他のサーバで参加していたプレイヤーを教えてもらう。
+	mr.publish(&common.MatchingMessage{Data: common.SYNC})
+	mr.restore()
+	ticker := time.NewTicker(mr.sweep)
+	defer ticker.Stop()
 	for {
 		select {
+		case <-ctx.Done():
+			return ctx.Err()
 		case player := <-mr.register:
-			log.Printf("[+] %s entered the room.\n", player.GetName())
-			for _, p := range mr.Players {
-				var msg = &common.MatchingMessage{
-					Source: player.GetProfile(),
-					Dest:   nil,
-					Data:   common.JOIN,
-				}
-				// 参加は全員に送信する
-				p.matchingChan <- msg
+			if player.GetBattleID() != "" {
+				mr.enterBattleChannel(player)
+				continue
+			}
+			// ルームにいるプレイヤーが接続し直した場合は、古い接続を切断して状態を引き継ぐ。
+			if _, ok := mr.locals[player.GetID()]; ok || mr.dropped[player.GetID()] != nil || mr.hasPlayer(player.GetID()) {
+				mr.resume(player)
+				continue
+			} else if err := mr.admit(player); err != nil {
+				log.Printf("[-] %s: %v\n", player.GetName(), err)
+				deliver(player, newErrorMessage("", err))
+				close(player.outbox)
+				continue
 			}
-			mr.enterRoom(player)
+			mr.locals[player.GetID()] = player
+			player.active = mr.now()
+			player.connID = newConnID()
+			player.pending = true
+			mr.publish(&common.MatchingMessage{
+				Source: player.GetProfile(),
+				Dest:   nil,
+				Data:   common.JOIN,
+				Player: &common.MatchingPlayer{Profile: player.GetProfile(), Status: common.WAITING, Joined: mr.now(), Conn: player.connID},
+			})
 		case player := <-mr.unregister:
-			mr.exitRoom(player)
-			for _, p := range mr.Players {
-				var msg = &common.MatchingMessage{
-					Source: player.GetProfile(),
-					Dest:   nil,
-					Data:   common.LEAVE,
+			if player.GetBattleID() != "" {
+				mr.leaveBattleChannel(player)
+				continue
+			}
+			mr.disconnect(player)
+		case pm := <-mr.message:
+			if pm.player.GetBattleID() != "" {
+				if fp, ok := mr.fighters[pm.player.GetID()]; !ok || fp != pm.player {
+					continue
+				}
+				if err := mr.publishBattleMessage(pm); err != nil {
+					log.Printf("[-] %s: %v\n", pm.player.GetName(), err)
+					mr.sendFighter(pm.player.GetID(), newErrorMessage(pm.msg.ID, err))
+				}
+				continue
+			}
+			// 既に退出したプレイヤーが送信したメッセージは破棄する。
+			if lp, ok := mr.locals[pm.player.GetID()]; !ok || lp != pm.player {
+				continue
+			}
+			// 通し番号の抜けに気付いたクライアントにはロビーの全員の状態を送り直す。他のサーバには発行しない。
+			// クライアントが自動で要求するため、操作としては扱わない。
+			if pm.msg.Data == common.SNAPSHOT {
+				mr.send(pm.player.GetID(), mr.snapshot())
+				if pm.msg.ID != "" {
+					mr.send(pm.player.GetID(), &common.MatchingMessage{ID: pm.msg.ID, Data: common.ACK})
 				}
-				// 退室は全員に送信する
-				p.matchingChan <- msg
+				continue
+			}
+			mr.touch(pm.player)
+			msg, err := mr.authenticate(pm)
+			if err != nil {
+				log.Printf("[-] %s: %v\n", pm.player.GetName(), err)
+				mr.send(pm.player.GetID(), newErrorMessage(pm.msg.ID, err))
+				continue
 			}
-		case msg := <-mr.message:
+			// 全てのサーバで同じ回答期限や対戦のIDを扱えるように、発行前に設定する。
+			// チャットの制限は送信者が接続しているサーバで確認する。
 			switch msg.Data {
+			case common.CHAT, common.DIRECT_CHAT:
+				if err := mr.limitChat(pm.player, msg); err != nil {
+					log.Printf("[-] %s: %v\n", pm.player.GetName(), err)
+					mr.send(pm.player.GetID(), newErrorMessage(msg.ID, err))
+					continue
+				}
 			case common.OFFER:
-				log.Printf("[+] OFFER: %s to %s\n", mr.Players[msg.Source.ID].GetName(), mr.Players[msg.Dest.ID].GetName())
-				mr.HandleOffer(msg)
-				mr.Players[msg.Dest.ID].matchingChan <- msg
-			case common.CANCEL_OFFER:
-				log.Printf("[+] CANCEL OFFER: %s to %s\n", mr.Players[msg.Source.ID].GetName(), mr.Players[msg.Dest.ID].GetName())
-				mr.HandleCancelOffer(msg)
-				mr.Players[msg.Dest.ID].matchingChan <- msg
-			case common.ACCEPT:
-				log.Printf("[+] ACCEPT OFFER: %s to %s\n", mr.Players[msg.Source.ID].GetName(), mr.Players[msg.Dest.ID].GetName())
-				mr.HandleAccept(msg)
-				mr.Players[msg.Source.ID].matchingChan <- msg
-				mr.Players[msg.Dest.ID].matchingChan <- msg
-			case common.DENY:
-				log.Printf("[+] DENY OFFER: %s to %s\n", mr.Players[msg.Source.ID].GetName(), mr.Players[msg.Dest.ID].GetName())
-				mr.HandleDeny(msg)
-				mr.Players[msg.Dest.ID].matchingChan <- msg
-			default:
-				err := &common.MatchingMessage{Data: common.ERROR}
-				mr.Players[msg.Source.ID].matchingChan <- err
+				deadline := mr.now().Add(mr.timeout)
+				msg.Deadline = &deadline
+			case common.ACCEPT, common.START:
+				if err := mr.checkDeadline(msg); err != nil {
+					log.Printf("[-] %s: %v\n", pm.player.GetName(), err)
+					mr.send(pm.player.GetID(), newErrorMessage(msg.ID, err))
+					continue
+				}
+				if msg.Battle == nil {
+					msg.Battle = &common.Battle{}
+				}
+				msg.Battle.ID = newBattleID()
+			}
+			mr.publish(msg)
+		case <-ticker.C:
+			mr.expireOffers(mr.now())
+			mr.proposeMatches()
+			mr.sweepBattles(mr.now())
+			mr.sweepIdle(mr.now())
+			mr.reconcile(mr.now())
+			mr.expireDropped(mr.now())
+			mr.finishBattles(mr.now())
+			if mr.vacant(mr.now()) {
+				log.Printf("[+] the room %s was closed because nobody was there.\n", mr.Name)
+				return nil
 			}
+		case event, ok := <-events:
+			if !ok {
+				return fmt.Errorf("matching event bus is closed")
+			}
+			mr.dispatch(event)
 		}
 	}
 }
 
-func (mr *MatchingRoom) enterRoom(p *MatchingPlayer) {
-	mr.Players[p.GetID()] = p
+func (mr *MatchingRoom) hasPlayer(id string) bool {
+	mr.mu.RLock()
+	defer mr.mu.RUnlock()
+	_, ok := mr.Players[id]
+	return ok
 }
 
-func (mr *MatchingRoom) exitRoom(p *MatchingPlayer) {
-	log.Printf("[+] %s exited the room.\n", p.GetName())
-	if _, ok := mr.Players[p.GetID()]; ok {
-		close(p.matchingChan)
-		delete(mr.Players, p.GetID())
+// ルームが定員に達している場合は参加させない。起動前に参加していたプレイヤーも定員を超えて接続し直すことはできない。
+// 起動前に参加していてまだ戻っていないプレイヤーの分は、新しく参加するプレイヤーには空けておく。
+// 各サーバは発行前に確認するため、同時に参加したプレイヤーによって定員をわずかに超える場合がある。
+func (mr *MatchingRoom) admit(player *MatchingPlayer) error {
+	mr.mu.RLock()
+	defer mr.mu.RUnlock()
+	if _, ok := mr.Players[player.GetID()]; ok {
+		return nil
 	}
+	seats := len(mr.Players)
+	if !mr.restored[player.GetID()] {
+		seats += mr.awaited()
+	}
+	if seats >= mr.capacity {
+		return newMatchingError(common.ERR_ROOM_FULL, "ルーム%sは定員(%d人)に達しています。しばらく待ってから参加してください。", mr.Name, mr.capacity)
+	}
+	return nil
 }
 
-// 対戦申請処理
-func (mr *MatchingRoom) HandleOffer(msg *common.MatchingMessage) {
-	// 受信者がRoomにいることを確認
-	_, ok := mr.Players[msg.Dest.ID]
+// 起動前に参加していて、まだ戻っていないプレイヤーの数
+// 他のサーバに接続しているプレイヤーはSNAPSHOTで届いた時点で数えなくなる。
+// mr.muをロックして呼び出す。
+func (mr *MatchingRoom) awaited() int {
+	n := 0
+	for id := range mr.restored {
+		if _, ok := mr.Players[id]; !ok {
+			n++
+		}
+	}
+	return n
+}
+
+// 回答期限を過ぎた対戦申請の承諾は、期限切れのイベントが届く前でも受け付けない。
+// 回答期限は申請者が接続しているサーバの時計で決まるため、承諾した受信者が接続しているサーバで発行前に確認する。
+func (mr *MatchingRoom) checkDeadline(msg *common.MatchingMessage) error {
+	if msg.Data != common.ACCEPT || msg.Dest == nil {
+		return nil
+	}
+	mr.mu.RLock()
+	defer mr.mu.RUnlock()
+	if o, ok := mr.offers[msg.Dest.ID]; ok && o.to == msg.Source.ID && !mr.now().Before(o.deadline) {
+		return newMatchingError(common.ERR_OFFER_EXPIRED, "%sからの対戦申請は回答期限を過ぎています。", mr.Players[o.from].GetName())
+	}
+	return nil
+}
+
+// メッセージの送信者(Source)はクライアントが送信した値ではなく、セッションから特定したプレイヤーとする。
+// 他のプレイヤーになりすましたメッセージや、サーバのみが発行するメッセージ(JOIN, LEAVE等)は拒否する。
+func (mr *MatchingRoom) authenticate(pm *playerMessage) (*common.MatchingMessage, error) {
+	msg := pm.msg
+	if msg.Source != nil && msg.Source.ID != pm.player.GetID() {
+		return nil, newMatchingError(common.ERR_INVALID_MESSAGE, "他のプレイヤーとしてメッセージを送信することはできません。")
+	}
+	if !playerMessageData[msg.Data] {
+		return nil, newMatchingError(common.ERR_INVALID_MESSAGE, "%sはプレイヤーから送信できません。", msg.Data)
+	} else if len(msg.ID) > MAX_MESSAGE_ID_LENGTH {
+		return nil, newMatchingError(common.ERR_INVALID_MESSAGE, "メッセージのIDは%d文字以内にしてください。", MAX_MESSAGE_ID_LENGTH)
+	}
+	msg.Source = pm.player.GetProfile()
+	msg.Deadline = nil
+	msg.Party = nil
+	// 対戦の形式のみ受け付け、IDはサーバが設定する。
+	// 参加者はプライベートルームのホストが組み合わせを指定する場合のみ、IDだけを受け付ける。
+	if msg.Battle != nil {
+		battle := &common.Battle{Mode: msg.Battle.Mode, SharedShell: msg.Battle.SharedShell}
+		for _, team := range msg.Battle.Teams {
+			t := &common.Team{}
+			for _, p := range team.Members {
+				if p != nil {
+					t.Members = append(t.Members, &common.Profile{ID: p.ID})
+				}
+			}
+			battle.Teams = append(battle.Teams, t)
+		}
+		msg.Battle = battle
+	}
+	return msg, nil
+}
+
+func (mr *MatchingRoom) publish(msg *common.MatchingMessage) error {
+	err := mr.bus.Publish(mr.Name, msg)
+	if err != nil {
+		log.Printf("Error in MatchingEventBus.Publish(): %v\n", err)
+	}
+	return err
+}
+
+// MatchingEventBusから受け取ったイベントを処理し、状態が変化したプレイヤーを全員に通知する。
+// 既に処理したIDのメッセージは処理し直さず、前回の結果を送信者に送り直す。
+func (mr *MatchingRoom) dispatch(msg *common.MatchingMessage) {
+	before := mr.statuses()
+	if !mr.replay(msg) {
+		mr.acknowledge(msg, mr.handle(msg))
+	}
+	mr.notifyStatus(before)
+}
+
+// プレイヤーが送信したメッセージを処理できなかった場合はエラーを返す。
+func (mr *MatchingRoom) handle(msg *common.MatchingMessage) error {
+	switch msg.Data {
+	case common.JOIN:
+		mr.join(msg)
+	case common.RESUME:
+		mr.HandleResume(msg)
+	case common.LEAVE:
+		mr.unrecord(msg)
+		// 接続し直したプレイヤーの古い接続の退室では、ルームから退室させない。
+		if mr.superseded(msg) {
+			log.Printf("[+] %s left the superseded connection.\n", msg.Source.Name)
+			return nil
+		}
+		mr.exitRoom(msg.Source)
+		mr.depart(msg.Source.ID)
+		// 退室は全員に送信する
+		mr.seq++
+		reply := *msg
+		reply.Player = nil
+		reply.Seq = mr.seq
+		mr.broadcast(&reply)
+		mr.notifyQueue()
+	case common.OFFER, common.CANCEL_OFFER, common.ACCEPT, common.DENY, common.OFFER_EXPIRED:
+		return mr.negotiate(msg)
+	case common.QUEUE, common.LEAVE_QUEUE:
+		err := mr.queueing(msg)
+		mr.notifyQueue()
+		return err
+	case common.PARTY_INVITE, common.PARTY_JOIN, common.PARTY_LEAVE, common.START:
+		return mr.organizeParty(msg)
+	case common.CHAT, common.DIRECT_CHAT:
+		return mr.chat(msg)
+	case common.BATTLE_CHAT, common.EMOTE, common.MUTE, common.UNMUTE:
+		return mr.battleChat(msg)
+	case common.STATUS:
+		return mr.HandleStatus(msg)
+	case common.SYNC:
+		mr.answerSync()
+	case common.SNAPSHOT:
+		mr.seed(msg)
+	case common.MATCHED:
+		// 他のサーバの提案と競合した場合は先に届いた方を採用する。後から届いた提案の失敗はプレイヤーに通知しない。
+		if err := mr.HandleMatched(msg); err != nil {
+			log.Printf("[-] MATCHED: %v\n", err)
+		}
+		mr.notifyQueue()
+	default:
+		return newMatchingError(common.ERR_INVALID_MESSAGE, "%sは送信できません。", msg.Data)
+	}
+	return nil
+}
+
+func (mr *MatchingRoom) negotiate(msg *common.MatchingMessage) error {
+	// SYNCへの応答が届くまでは購読開始前に参加したプレイヤーを把握できていないため、対戦申請に関するイベントでは両者がルームにいることを確認する。
+	if mr.Players[msg.Source.ID] == nil {
+		return newMatchingError(common.ERR_PLAYER_NOT_FOUND, "ルームに参加していません。")
+	} else if msg.Dest == nil || mr.Players[msg.Dest.ID] == nil {
+		return newMatchingError(common.ERR_PLAYER_NOT_FOUND, "相手のプレイヤーが見つかりません。ルームから退出した可能性があります。")
+	}
+	switch msg.Data {
+	case common.OFFER:
+		return mr.HandleOffer(msg)
+	case common.CANCEL_OFFER:
+		return mr.HandleCancelOffer(msg)
+	case common.ACCEPT:
+		return mr.HandleAccept(msg)
+	case common.DENY:
+		return mr.HandleDeny(msg)
+	case common.OFFER_EXPIRED:
+		return mr.HandleOfferExpired(msg)
+	}
+	return nil
+}
+
+// 自身の参加イベントを受け取る前のプレイヤーにも届くように、このサーバに接続している送信者以外の全員に送信する。
+func (mr *MatchingRoom) broadcast(msg *common.MatchingMessage) {
+	for id := range mr.locals {
+		if id == msg.Source.ID {
+			continue
+		}
+		mr.send(id, msg)
+	}
+}
+
+// このサーバに接続しているプレイヤーにのみ送信する。受け取れないプレイヤーは退出させる。
+func (mr *MatchingRoom) send(id string, msg *common.MatchingMessage) {
+	p, ok := mr.locals[id]
 	if !ok {
-		err := &common.MatchingMessage{Data: common.ERROR}
-		mr.Players[msg.Source.ID].matchingChan <- err
 		return
 	}
-	mr.changeToNegotiating(msg.Source, msg.Dest)
+	if !deliver(p, msg) {
+		log.Printf("[+] %s is too slow to receive messages.\n", p.GetName())
+		p.conn.Close()
+		mr.leave(p)
+	}
 }
 
-// 対戦申請キャンセル処理
-func (mr *MatchingRoom) HandleCancelOffer(msg *common.MatchingMessage) {
-	_, ok := mr.Players[msg.Dest.ID]
-	if !ok {
-		err := &common.MatchingMessage{Data: common.ERROR}
-		mr.Players[msg.Source.ID].matchingChan <- err
+// 一人の遅いプレイヤーによってルーム全体が止まらないように、outboxが一杯のプレイヤーはSLOW_CONSUMER_TIMEOUTだけ待つ。
+// 待っても空きができなかった場合はfalseを返す。
+func deliver(p *MatchingPlayer, msg *common.MatchingMessage) bool {
+	select {
+	case p.outbox <- msg:
+		return true
+	default:
+	}
+	timer := time.NewTimer(SLOW_CONSUMER_TIMEOUT)
+	defer timer.Stop()
+	select {
+	case p.outbox <- msg:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// このサーバに接続しているプレイヤーをルームから退出させる。
+// outboxを閉じた後に送信しないように、ここと接続を引き継ぐ場合以外でoutboxを閉じてはならない。
+// 接続し直したプレイヤーの古い接続はlocalsから取り除かれているため、退室させない。
+func (mr *MatchingRoom) leave(p *MatchingPlayer) {
+	if lp, ok := mr.locals[p.GetID()]; !ok || lp != p {
 		return
 	}
+	close(p.outbox)
+	delete(mr.locals, p.GetID())
+	mr.publishLeave(p)
+}
 
-	mr.changeToWaiting(msg.Source, msg.Dest)
+func (mr *MatchingRoom) publishLeave(p *MatchingPlayer) {
+	mr.leaving[p.GetID()] = p.connID
+	mr.publish(&common.MatchingMessage{
+		Source: p.GetProfile(),
+		Dest:   nil,
+		Data:   common.LEAVE,
+		Player: &common.MatchingPlayer{Profile: p.GetProfile(), Conn: p.connID},
+	})
 }
 
-// 対戦申請に対する承諾処理
-func (mr *MatchingRoom) HandleAccept(msg *common.MatchingMessage) {
-	_, ok := mr.Players[msg.Dest.ID]
-	if !ok {
-		err := &common.MatchingMessage{Data: common.ERROR}
-		mr.Players[msg.Source.ID].matchingChan <- err
+// 切断されたがまだ退室させていないプレイヤーと、接続し直すのを待つ期限
+type droppedPlayer struct {
+	player   *MatchingPlayer
+	deadline time.Time
+}
+
+// 切断されたこのサーバのプレイヤーを、mr.graceの間は退室させずに接続し直すのを待つ。
+// 待っている間も対戦申請やランダム対戦の待ち行列、パーティは残し、期限までに接続し直さなければ退室させる。
+func (mr *MatchingRoom) disconnect(p *MatchingPlayer) {
+	if lp, ok := mr.locals[p.GetID()]; !ok || lp != p {
+		return
+	} else if mr.grace <= 0 {
+		mr.leave(p)
 		return
 	}
+	log.Printf("[+] %s was disconnected from the room %s.\n", p.GetName(), mr.Name)
+	close(p.outbox)
+	delete(mr.locals, p.GetID())
+	mr.dropped[p.GetID()] = &droppedPlayer{player: p, deadline: mr.now().Add(mr.grace)}
+}
 
-	isNego := func(src, dst *common.Profile) error {
-		mu.Lock()
-		defer mu.Unlock()
-		if mr.Players[src.ID].GetStatus() == WAITING {
-			return fmt.Errorf("souce player is not NEGOTIATING")
-		} else if mr.Players[dst.ID].GetStatus() == WAITING {
-			return fmt.Errorf("destination player is not NEGOTIATING")
+// 誰もいない状態がmr.vacancy続いたかどうか。対戦中のプレイヤーや、接続し直すのを待っているプレイヤーがいる場合も誰かいるとみなす。
+func (mr *MatchingRoom) vacant(now time.Time) bool {
+	if mr.vacancy <= 0 {
+		return false
+	}
+	mr.mu.RLock()
+	empty := len(mr.Players) == 0 && len(mr.battles) == 0
+	mr.mu.RUnlock()
+	if !empty || len(mr.locals) > 0 || len(mr.dropped) > 0 || len(mr.fighters) > 0 {
+		mr.emptied = time.Time{}
+		return false
+	} else if mr.emptied.IsZero() {
+		mr.emptied = now
+	}
+	return now.Sub(mr.emptied) >= mr.vacancy
+}
+
+// 期限までに接続し直さなかったプレイヤーを退室させる。
+func (mr *MatchingRoom) expireDropped(now time.Time) {
+	for id, d := range mr.dropped {
+		if now.Before(d.deadline) {
+			continue
 		}
+		log.Printf("[+] %s did not resume the session in time.\n", d.player.GetName())
+		delete(mr.dropped, id)
+		mr.publishLeave(d.player)
+	}
+}
+
+// 退室したのが接続し直す前の古い接続かどうか。
+func (mr *MatchingRoom) superseded(msg *common.MatchingMessage) bool {
+	mr.mu.RLock()
+	defer mr.mu.RUnlock()
+	p, ok := mr.Players[msg.Source.ID]
+	return ok && msg.Player != nil && p.connID != "" && p.connID != msg.Player.Conn
+}
+
+// ルームにいるプレイヤーが接続し直した場合に、退室させずに新しい接続(player)で状態を引き継ぐ。
+// 古い接続がこのサーバにあればすぐに閉じ、他のサーバにあればRESUMEを受け取ったそのサーバが閉じる。
+// 対戦申請やランダム対戦の待ち行列、パーティはそのまま続けられる。他のプレイヤーには参加や退室を通知しない。
+func (mr *MatchingRoom) resume(player *MatchingPlayer) {
+	log.Printf("[+] %s resumed the session in the room %s.\n", player.GetName(), mr.Name)
+	player.active = mr.now()
+	if old, ok := mr.locals[player.GetID()]; ok {
+		old.conn.Close()
+		close(old.outbox)
+		mr.inherit(player, old)
+	} else if d, ok := mr.dropped[player.GetID()]; ok {
+		delete(mr.dropped, player.GetID())
+		mr.inherit(player, d.player)
+	}
+	mr.locals[player.GetID()] = player
+	player.connID = newConnID()
+	player.pending = true
+	mr.publish(&common.MatchingMessage{
+		Source: player.GetProfile(),
+		Data:   common.RESUME,
+		Player: &common.MatchingPlayer{Profile: player.GetProfile(), Status: common.WAITING, Joined: mr.now(), Conn: player.connID},
+	})
+}
+
+// 接続し直したプレイヤー(player)に古い接続のプレイヤー(old)の状態を引き継ぐ。
+func (mr *MatchingRoom) inherit(player *MatchingPlayer, old *MatchingPlayer) {
+	mr.mu.RLock()
+	player.Profile = old.Profile
+	player.Status = old.Status
+	player.Joined = old.Joined
+	mr.mu.RUnlock()
+	player.chats = old.chats
+	player.active, player.warned, player.away = old.active, old.warned, old.away
+}
+
+// 接続し直したプレイヤー(Source)の接続を、全てのサーバでRESUMEの接続に切り替える。
+// 古い接続がこのサーバにあれば退室させずに閉じ、新しい接続がこのサーバにあればロビーの状態を引き継いで全員の状態を送り直す。
+// 発行してから届くまでに古い接続が退室していた場合は、新しい接続の参加として扱う。
+func (mr *MatchingRoom) HandleResume(msg *common.MatchingMessage) {
+	if msg.Player == nil {
+		return
+	}
+	id := msg.Source.ID
+	// このサーバで更に接続し直していた場合は、その接続のRESUMEが後から届くため閉じない。
+	if local, ok := mr.locals[id]; ok && local.connID != msg.Player.Conn && !local.pending {
+		log.Printf("[+] %s resumed the session on another server.\n", msg.Source.Name)
+		local.conn.Close()
+		close(local.outbox)
+		delete(mr.locals, id)
+	}
+	// 切断されて待っていたプレイヤーが他のサーバで接続し直した場合は、退室させずに待つのをやめる。
+	if d, ok := mr.dropped[id]; ok && d.player.connID != msg.Player.Conn {
+		delete(mr.dropped, id)
+	}
+	if !mr.hasPlayer(id) {
+		mr.join(msg)
+		return
+	}
+	local := mr.current(msg)
+	mr.mu.Lock()
+	p := mr.Players[id]
+	if local != nil && p != local {
+		local.Profile, local.Status, local.Joined = p.Profile, p.Status, p.Joined
+		mr.Players[id] = local
+		p = local
+	}
+	p.connID = msg.Player.Conn
+	mr.mu.Unlock()
+	if local != nil {
+		mr.record(id)
+		mr.send(id, mr.snapshot())
+	}
+}
+
+// JOIN, RESUMEで参加・接続し直したのがこのサーバの接続であれば、その接続を返して発行済みのイベントが届いたことにする。
+func (mr *MatchingRoom) current(msg *common.MatchingMessage) *MatchingPlayer {
+	local, ok := mr.locals[msg.Source.ID]
+	if !ok || msg.Player == nil || local.connID != msg.Player.Conn {
 		return nil
 	}
+	local.pending = false
+	return local
+}
 
-	if err := isNego(msg.Source, msg.Dest); err != nil {
-		err := &common.MatchingMessage{Data: common.ERROR}
-		mr.Players[msg.Source.ID].matchingChan <- err
+// 参加したプレイヤー(Source)をロビーに加え、全員に送信する。参加したプレイヤーには代わりにロビーの全員の状態を送信する。
+func (mr *MatchingRoom) join(msg *common.MatchingMessage) {
+	log.Printf("[+] %s entered the room %s.\n", msg.Source.Name, mr.Name)
+	local := mr.current(msg)
+	mr.enterRoom(msg, local)
+	mr.arrive(msg.Source.ID)
+	if local != nil {
+		mr.record(msg.Source.ID)
 	}
+	mr.seq++
+	reply := *msg
+	reply.Data = common.JOIN
+	reply.Player = clientPlayer(msg.Player)
+	reply.Seq = mr.seq
+	mr.broadcast(&reply)
+	mr.send(msg.Source.ID, mr.snapshot())
 }
 
-// 対戦申請に対する不承諾処理
-func (mr *MatchingRoom) HandleDeny(msg *common.MatchingMessage) {
-	_, ok := mr.Players[msg.Dest.ID]
-	if !ok {
-		err := &common.MatchingMessage{Data: common.ERROR}
-		mr.Players[msg.Source.ID].matchingChan <- err
+// このサーバに接続しているプレイヤーの参加を記録する。
+func (mr *MatchingRoom) record(id string) {
+	if mr.members == nil {
+		return
+	}
+	if err := mr.members.SetID(mr.Name, id); err != nil {
+		log.Printf("Error in SetID(): %v\n", err)
+	}
+}
+
+// 起動前に記録していた参加者を読み込み、RESTORE_GRACE_PERIODの間は接続し直すのを待つ。
+// 他のサーバに接続しているプレイヤーは、その間にSNAPSHOTで届く。
+func (mr *MatchingRoom) restore() {
+	if mr.members == nil {
+		return
+	}
+	ids, err := mr.members.GetAll(mr.Name)
+	if err != nil {
+		log.Printf("Error in GetAll(): %v\n", err)
+		return
+	}
+	mr.restored = make(map[string]bool)
+	for _, id := range ids {
+		mr.restored[id] = true
+	}
+	mr.restoring = mr.now().Add(RESTORE_GRACE_PERIOD)
+}
+
+// 待つ期限を過ぎても戻らなかった起動前の参加者を、記録から外す。
+func (mr *MatchingRoom) reconcile(now time.Time) {
+	if mr.restored == nil || now.Before(mr.restoring) {
 		return
 	}
+	for id := range mr.restored {
+		if _, ok := mr.locals[id]; ok || mr.hasPlayer(id) {
+			continue
+		}
+		log.Printf("[+] %s did not come back to the room %s.\n", id, mr.Name)
+		if err := mr.members.RemoveID(mr.Name, id); err != nil {
+			log.Printf("Error in RemoveID(): %v\n", err)
+		}
+	}
+	mr.restored = nil
+}
+
+// このサーバが発行した退室が届いたときに、参加の記録から外す。
+// 記録から外すのが届く前だと、その間に接続し直したプレイヤーの記録を消してしまう。
+func (mr *MatchingRoom) unrecord(msg *common.MatchingMessage) {
+	conn, ok := mr.leaving[msg.Source.ID]
+	if !ok || msg.Player == nil || conn != msg.Player.Conn {
+		return
+	}
+	delete(mr.leaving, msg.Source.ID)
+	if mr.members == nil || mr.superseded(msg) {
+		return
+	}
+	if err := mr.members.RemoveID(mr.Name, msg.Source.ID); err != nil {
+		log.Printf("Error in RemoveID(): %v\n", err)
+	}
+}
+
+// クライアントにはサーバ間でのみ利用する接続の識別子を送信しない。
+func clientPlayer(player *common.MatchingPlayer) *common.MatchingPlayer {
+	if player == nil {
+		return nil
+	}
+	p := *player
+	p.Conn = ""
+	return &p
+}
+
+// 他のサーバに接続しているプレイヤーはコネクションを持たないMatchingPlayerとして保持する。
+// このサーバで接続し直していてlocalがnilの場合も、その接続のRESUMEが届くまではコネクションを持たないMatchingPlayerとする。
+func (mr *MatchingRoom) enterRoom(msg *common.MatchingMessage, local *MatchingPlayer) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	profile := msg.Source
+	p := local
+	if p == nil {
+		p = NewMatchingPlayer(profile.ID, profile.Name, nil)
+		p.Profile.Rating = profile.Rating
+	}
+	p.Status = WAITING
+	p.Joined = mr.now()
+	if msg.Player != nil {
+		p.Joined = msg.Player.Joined
+		p.connID = msg.Player.Conn
+	}
+	mr.Players[profile.ID] = p
+}
+
+// 他のサーバが購読を開始したときに、このサーバに接続しているロビーのプレイヤーの状態をSNAPSHOTとして発行する。
+// 参加のイベントがまだ届いていないプレイヤーは、後から届くJOINで他のサーバに加わるため含めない。
+// 他のサーバで接続し直したことをまだ知らない場合は他のサーバと重複するが、受け取ったサーバは把握済みのプレイヤーを無視する。
+func (mr *MatchingRoom) answerSync() {
+	var lobby []*common.MatchingPlayer
+	mr.mu.RLock()
+	ids := make([]string, 0, len(mr.locals)+len(mr.dropped))
+	for id := range mr.locals {
+		ids = append(ids, id)
+	}
+	for id := range mr.dropped {
+		ids = append(ids, id)
+	}
+	for _, id := range ids {
+		if p, ok := mr.Players[id]; ok {
+			summary := p.Summary()
+			summary.Conn = p.connID
+			lobby = append(lobby, summary)
+		}
+	}
+	mr.mu.RUnlock()
+	if len(lobby) == 0 {
+		return
+	}
+	mr.publish(&common.MatchingMessage{Data: common.SNAPSHOT, Lobby: lobby})
+}
+
+// 他のサーバがSYNCに応えて発行したSNAPSHOTから、まだ把握していないプレイヤーをロビーに加え、このサーバのプレイヤーに参加として通知する。
+// 既に把握しているプレイヤーは、それまでに受け取ったイベントで状態を更新しているため変更しない。
+func (mr *MatchingRoom) seed(msg *common.MatchingMessage) {
+	for _, player := range msg.Lobby {
+		if player == nil || player.Profile == nil {
+			continue
+		}
+		mr.mu.Lock()
+		if _, ok := mr.Players[player.Profile.ID]; ok {
+			mr.mu.Unlock()
+			continue
+		}
+		p := NewMatchingPlayer(player.Profile.ID, player.Profile.Name, nil)
+		p.Profile.Rating = player.Profile.Rating
+		p.Status = matchingStatus(player.Status)
+		p.Joined = player.Joined
+		p.connID = player.Conn
+		mr.Players[p.GetID()] = p
+		mr.mu.Unlock()
+
+		log.Printf("[+] %s was found in the room %s.\n", p.GetName(), mr.Name)
+		mr.seq++
+		mr.broadcast(&common.MatchingMessage{Source: p.GetProfile(), Data: common.JOIN, Player: clientPlayer(player), Seq: mr.seq})
+	}
+}
+
+// ルームにいるプレイヤーの現在の状態
+func (mr *MatchingRoom) statuses() map[string]MatchingStatus {
+	mr.mu.RLock()
+	defer mr.mu.RUnlock()
+	statuses := make(map[string]MatchingStatus, len(mr.Players))
+	for id, p := range mr.Players {
+		statuses[id] = p.GetStatus()
+	}
+	return statuses
+}
 
-	mr.changeToWaiting(msg.Source, msg.Dest)
+// ロビーの全員の状態と、それまでに処理したロビーの変化の通し番号
+// クライアントは以降のJOIN, LEAVE, STATUSの通し番号が連続していることを確認し、抜けていればSNAPSHOTを要求する。
+func (mr *MatchingRoom) snapshot() *common.MatchingMessage {
+	return &common.MatchingMessage{Data: common.SNAPSHOT, Seq: mr.seq, Lobby: mr.GetLobby()}
 }
 
-// 申請者(src)と承諾者(dst)のステータスが共にWAITINGであること確認し、両者のステータスをNEGOTIATINGにする。
-func (mr *MatchingRoom) changeToNegotiating(src, dst *common.Profile) error {
-	if mr.Players[src.ID] == nil {
-		return fmt.Errorf("source player is not in the room.")
-	} else if mr.Players[dst.ID] == nil {
-		return fmt.Errorf("destination player is not in the room.")
+// beforeから状態が変化したプレイヤーをSTATUSで全員に通知する。参加や退室はJOIN, LEAVEで通知するため含めない。
+// 通し番号が抜けないように、状態が変化したプレイヤー自身にも送信する。
+func (mr *MatchingRoom) notifyStatus(before map[string]MatchingStatus) {
+	var changed []*common.MatchingPlayer
+	mr.mu.RLock()
+	for id, p := range mr.Players {
+		if s, ok := before[id]; ok && s != p.GetStatus() {
+			changed = append(changed, p.Summary())
+		}
+	}
+	mr.mu.RUnlock()
+	for _, player := range changed {
+		mr.seq++
+		msg := &common.MatchingMessage{Source: player.Profile, Data: common.STATUS, Player: player, Seq: mr.seq}
+		for id := range mr.locals {
+			mr.send(id, msg)
+		}
 	}
+}
+
+func (mr *MatchingRoom) exitRoom(profile *common.Profile) {
+	log.Printf("[+] %s exited the room %s.\n", profile.Name, mr.Name)
+	mr.abandonNegotiation(profile)
+	mr.leaveParty(profile)
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	mr.dequeue(profile.ID)
+	delete(mr.Players, profile.ID)
+}
 
-	mu.Lock()
-	defer mu.Unlock()
+// 対戦申請処理
+// 申請者(Source)と受信者(Dest)が共にWAITINGである場合、申請者をNEGOTIATINGにして受信者に申請を届ける。
+// 受信者はWAITINGのまま、複数のプレイヤーからの申請を受け取ることができる。
+// 申請者にも回答期限を知らせるために同じメッセージを送り返す。申請者が受け取っていた申請は全て断る。
+func (mr *MatchingRoom) HandleOffer(msg *common.MatchingMessage) error {
+	if msg.Source.ID == msg.Dest.ID {
+		return newMatchingError(common.ERR_INVALID_MESSAGE, "自分に対戦を申請することはできません。")
+	} else if msg.Deadline == nil {
+		return newMatchingError(common.ERR_INVALID_MESSAGE, "対戦申請に回答期限がありません。")
+	}
+	shared := msg.Battle != nil && msg.Battle.SharedShell
+	if err := mr.startNegotiation(msg.Source.ID, msg.Dest.ID, *msg.Deadline, shared); err != nil {
+		return err
+	}
+	log.Printf("[+] OFFER: %s to %s\n", msg.Source.Name, msg.Dest.Name)
+	mr.send(msg.Dest.ID, msg)
+	mr.send(msg.Source.ID, msg)
+	mr.declineInbox(msg.Source)
+	return nil
+}
 
-	if mr.Players[src.ID].GetStatus() == NEGOTIATING {
-		return fmt.Errorf("souce player has already been NEGOTIATING")
-	} else if mr.Players[dst.ID].GetStatus() == NEGOTIATING {
-		return fmt.Errorf("destination player has alredy been NEGOTIATING")
+// 対戦申請キャンセル処理
+// 申請者(Source)が受信者(Dest)に送った申請のみ取り消すことができる。
+func (mr *MatchingRoom) HandleCancelOffer(msg *common.MatchingMessage) error {
+	if err := mr.endNegotiation(msg.Source.ID, msg.Dest.ID); err != nil {
+		return err
+	}
+	log.Printf("[+] CANCEL OFFER: %s to %s\n", msg.Source.Name, msg.Dest.Name)
+	mr.send(msg.Dest.ID, msg)
+	return nil
+}
+
+// 対戦申請に対する承諾処理
+// 受信者(Source)が申請者(Dest)からの申請を承諾した場合、両者(パーティのリーダーであればパーティの全員)をIN_BATTLEにし、
+// 受信者が受け取っていた他の申請は全て断る。どちらかがパーティのリーダーであればチーム戦とする。
+func (mr *MatchingRoom) HandleAccept(msg *common.MatchingMessage) error {
+	if msg.Battle == nil {
+		return newMatchingError(common.ERR_INVALID_MESSAGE, "承諾した対戦がありません。")
+	}
+	mr.mu.Lock()
+	o, ok := mr.offers[msg.Dest.ID]
+	if !ok || o.to != msg.Source.ID {
+		mr.mu.Unlock()
+		return newMatchingError(common.ERR_OFFER_NOT_FOUND, "対戦申請が見つかりません。既に取り消されたか、回答されています。")
+	}
+	// 申請後にメンバーが離脱した場合は人数が揃わないため承諾できない。
+	teams := [][]*common.Profile{mr.teamOf(o.from), mr.teamOf(o.to)}
+	if len(teams[0]) != len(teams[1]) {
+		mr.mu.Unlock()
+		return newMatchingError(common.ERR_INVALID_BATTLE, "パーティの人数が相手と異なるため対戦できません。")
+	}
+	mode := common.DUEL
+	if len(teams[0]) > 1 {
+		mode = common.TEAM
+	}
+	mr.removeOffer(o)
+	battle := mr.startBattle(msg.Battle.ID, mode, teams, o.shared)
+	mr.mu.Unlock()
+
+	log.Printf("[+] ACCEPT OFFER: %s to %s\n", msg.Source.Name, msg.Dest.Name)
+	reply := *msg
+	reply.Battle = battle.Summary()
+	mr.sendBattle(battle, &reply)
+	mr.declineInbox(msg.Source)
+	return nil
+}
+
+// 対戦申請に対する不承諾処理
+// 受信者(Source)が申請者(Dest)からの申請を断った場合、申請者をWAITINGに戻す。
+func (mr *MatchingRoom) HandleDeny(msg *common.MatchingMessage) error {
+	if err := mr.endNegotiation(msg.Dest.ID, msg.Source.ID); err != nil {
+		return err
 	}
+	log.Printf("[+] DENY OFFER: %s to %s\n", msg.Source.Name, msg.Dest.Name)
+	mr.send(msg.Dest.ID, msg)
+	return nil
+}
 
-	mr.Players[src.ID].SetStatus(NEGOTIATING)
-	mr.Players[dst.ID].SetStatus(NEGOTIATING)
+// 対戦申請の期限切れ処理
+// 申請者(Source)から受信者(Dest)への申請が回答されないまま期限を過ぎた場合、申請者をWAITINGに戻して両者に期限切れを通知する。
+func (mr *MatchingRoom) HandleOfferExpired(msg *common.MatchingMessage) error {
+	if msg.Deadline == nil {
+		return newMatchingError(common.ERR_INVALID_MESSAGE, "期限切れの対戦申請に回答期限がありません。")
+	}
+	mr.mu.RLock()
+	o, ok := mr.offers[msg.Source.ID]
+	mr.mu.RUnlock()
+	// 期限切れのイベントが届くまでに回答された申請や、新たに送信された申請は期限切れにしない。
+	if !ok || !o.deadline.Equal(*msg.Deadline) {
+		return nil
+	}
+	if err := mr.endNegotiation(msg.Source.ID, msg.Dest.ID); err != nil {
+		return err
+	}
+	log.Printf("[+] OFFER EXPIRED: %s to %s\n", msg.Source.Name, msg.Dest.Name)
+	mr.send(msg.Source.ID, msg)
+	mr.send(msg.Dest.ID, msg)
 	return nil
 }
 
-// 申請者(src)と承諾者(dst)のステータスが共にNEGITIATINGであること確認し、両者のステータスをWAITINGにする。
-func (mr *MatchingRoom) changeToWaiting(src, dst *common.Profile) error {
-	if mr.Players[src.ID] == nil {
-		return fmt.Errorf("source player is not in the room.")
-	} else if mr.Players[dst.ID] == nil {
-		return fmt.Errorf("destination player is not in the room.")
+// このサーバに接続しているプレイヤーが送信した申請のうち、回答期限を過ぎたものの期限切れを発行する。
+// 期限切れの処理は他のイベントと同様に、MatchingEventBusから受け取った後に行う。
+func (mr *MatchingRoom) expireOffers(now time.Time) {
+	var expired []*common.MatchingMessage
+	mr.mu.Lock()
+	for _, o := range mr.offers {
+		if o.expiring || now.Before(o.deadline) {
+			continue
+		}
+		if _, ok := mr.locals[o.from]; !ok {
+			continue
+		}
+		o.expiring = true
+		deadline := o.deadline
+		expired = append(expired, &common.MatchingMessage{
+			Source:   mr.Players[o.from].GetProfile(),
+			Dest:     mr.Players[o.to].GetProfile(),
+			Data:     common.OFFER_EXPIRED,
+			Deadline: &deadline,
+		})
 	}
+	mr.mu.Unlock()
+	for _, msg := range expired {
+		mr.publish(msg)
+	}
+}
 
-	mu.Lock()
-	defer mu.Unlock()
+// 申請者(from)と受信者(to)のステータスが共にWAITINGであること確認し、申請者のステータスをNEGOTIATINGにする。
+func (mr *MatchingRoom) startNegotiation(from, to string, deadline time.Time, shared bool) error {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
 
-	// FIXME: 交渉中でないPlayerを宛先にしてMessageを送信することで交渉解除することが可能。
-	if mr.Players[src.ID].GetStatus() == WAITING {
-		return fmt.Errorf("souce player has already been WAITING")
-	} else if mr.Players[dst.ID].GetStatus() == WAITING {
-		return fmt.Errorf("destination player has already been WAITING")
+	if s := mr.Players[from].GetStatus(); s != WAITING {
+		return busyError("あなた", s)
+	} else if s := mr.Players[to].GetStatus(); s != WAITING {
+		return busyError("相手", s)
+	}
+	if len(mr.teamOf(from)) != len(mr.teamOf(to)) {
+		return newMatchingError(common.ERR_INVALID_BATTLE, "パーティの人数が相手と異なるため対戦できません。")
 	}
 
-	mr.Players[src.ID].SetStatus(WAITING)
-	mr.Players[dst.ID].SetStatus(WAITING)
+	o := &offer{from: from, to: to, deadline: deadline, shared: shared}
+	mr.offers[from] = o
+	if _, ok := mr.inbox[to]; !ok {
+		mr.inbox[to] = make(map[string]*offer)
+	}
+	mr.inbox[to][from] = o
+	mr.Players[from].SetStatus(NEGOTIATING)
 	return nil
-}
\ No newline at end of file
+}
+
+// 申請者(from)から受信者(to)への申請が存在することを確認し、申請者をWAITINGに戻す。
+func (mr *MatchingRoom) endNegotiation(from, to string) error {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	o, ok := mr.offers[from]
+	if !ok || o.to != to {
+		return newMatchingError(common.ERR_OFFER_NOT_FOUND, "対戦申請が見つかりません。既に取り消されたか、回答されています。")
+	}
+
+	mr.removeOffer(o)
+	mr.Players[from].SetStatus(WAITING)
+	return nil
+}
+
+// mr.muをロックして呼び出す。
+func (mr *MatchingRoom) removeOffer(o *offer) {
+	delete(mr.offers, o.from)
+	delete(mr.inbox[o.to], o.from)
+	if len(mr.inbox[o.to]) == 0 {
+		delete(mr.inbox, o.to)
+	}
+}
+
+// profileのプレイヤーが受け取っている申請を全て断り、申請者をWAITINGに戻して不承諾を通知する。
+func (mr *MatchingRoom) declineInbox(profile *common.Profile) {
+	mr.mu.Lock()
+	var offerers []*common.Profile
+	for _, o := range mr.inbox[profile.ID] {
+		mr.removeOffer(o)
+		p := mr.Players[o.from]
+		p.SetStatus(WAITING)
+		offerers = append(offerers, p.GetProfile())
+	}
+	mr.mu.Unlock()
+
+	for _, offerer := range offerers {
+		log.Printf("[+] DENY OFFER: %s to %s\n", profile.Name, offerer.Name)
+		mr.send(offerer.ID, &common.MatchingMessage{Source: profile, Dest: offerer, Data: common.DENY})
+	}
+}
+
+// 交渉中のプレイヤーが退室した場合、相手に申請が取り消されたこと、または断られたことを通知する。
+func (mr *MatchingRoom) abandonNegotiation(profile *common.Profile) {
+	// 申請者が退室した場合は申請の取り消しとして扱う。
+	mr.mu.Lock()
+	o, ok := mr.offers[profile.ID]
+	if ok {
+		mr.removeOffer(o)
+	}
+	mr.mu.Unlock()
+	if ok {
+		mr.mu.RLock()
+		dest := mr.Players[o.to].GetProfile()
+		mr.mu.RUnlock()
+		mr.send(o.to, &common.MatchingMessage{Source: profile, Dest: dest, Data: common.CANCEL_OFFER})
+	}
+	// 受信者が退室した場合は申請の不承諾として扱う。
+	mr.declineInbox(profile)
+}
@@ -0,0 +1,916 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"github.com/taise-hub/shellgame-cli/common"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeConnはテスト用のConnの実装
+// Writeされたメッセージをwrittenに流し、incomingに流されたメッセージをReadで返す。
+// staleを閉じると応答が途絶えたものとしてReadがErrHeartbeatTimeoutを返す。
+type fakeConn struct {
+	written  chan *common.MatchingMessage
+	incoming chan *common.MatchingMessage
+	closed   chan struct{}
+	stale    chan struct{}
+	once     sync.Once
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{
+		written:  make(chan *common.MatchingMessage, 64),
+		incoming: make(chan *common.MatchingMessage),
+		closed:   make(chan struct{}),
+		stale:    make(chan struct{}),
+	}
+}
+
+func (c *fakeConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+func (c *fakeConn) Write(msg common.Message) error {
+	c.written <- msg.(*common.MatchingMessage)
+	return nil
+}
+
+func (c *fakeConn) Read(msg common.Message) error {
+	select {
+	case m := <-c.incoming:
+		*msg.(*common.MatchingMessage) = *m
+		return nil
+	case <-c.closed:
+		return context.Canceled
+	case <-c.stale:
+		return ErrHeartbeatTimeout
+	}
+}
+
+// 指定されたDataのメッセージが届くまで待つ。
+func (c *fakeConn) expect(t *testing.T, data common.MatchingMessageData) *common.MatchingMessage {
+	t.Helper()
+	for {
+		select {
+		case msg := <-c.written:
+			if msg.Data == data {
+				return msg
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for message %d", data)
+			return nil
+		}
+	}
+}
+
+// fakeBusはテスト用のMatchingEventBusの実装
+// 複数のMatchingRoomで共有することで、複数のサーバを想定したテストを行う。
+// MatchingRoom.Run()は自身が発行したイベントを購読するため、発行時に購読者を待たないようにイベントを溜めておく。
+type fakeBus struct {
+	mu          sync.Mutex
+	subscribers map[string][]*fakeSubscriber
+}
+
+type fakeSubscriber struct {
+	mu     sync.Mutex
+	queue  []*common.MatchingMessage
+	notify chan struct{}
+}
+
+func newFakeBus() *fakeBus {
+	return &fakeBus{subscribers: make(map[string][]*fakeSubscriber)}
+}
+
+func (b *fakeBus) Publish(room string, msg *common.MatchingMessage) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, s := range b.subscribers[room] {
+		s.mu.Lock()
+		s.queue = append(s.queue, msg)
+		s.mu.Unlock()
+		select {
+		case s.notify <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (b *fakeBus) Subscribe(ctx context.Context, room string) (<-chan *common.MatchingMessage, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := &fakeSubscriber{notify: make(chan struct{}, 1)}
+	b.subscribers[room] = append(b.subscribers[room], s)
+	events := make(chan *common.MatchingMessage)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.notify:
+			}
+			s.mu.Lock()
+			queue := s.queue
+			s.queue = nil
+			s.mu.Unlock()
+			for _, msg := range queue {
+				select {
+				case events <- msg:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return events, nil
+}
+
+func (b *fakeBus) count(room string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subscribers[room])
+}
+
+func startTestRoom(t *testing.T, name string, bus *fakeBus) *MatchingRoom {
+	t.Helper()
+	mr := NewMatchingRoom(name, bus)
+	mr.grace = 0 // 切断されたプレイヤーはすぐに退室させる。接続し直すのを待つ場合はgraceを設定したルームを使う。
+	runTestRoom(t, mr, bus)
+	return mr
+}
+
+// MatchingRoomが購読を開始するまで待つ。
+func runTestRoom(t *testing.T, mr *MatchingRoom, bus *fakeBus) {
+	t.Helper()
+	n := bus.count(mr.Name)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go mr.Run(ctx)
+	for bus.count(mr.Name) == n {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func joinTestRoom(mr *MatchingRoom, id string, name string) (*MatchingPlayer, *fakeConn) {
+	conn := newFakeConn()
+	p := NewMatchingPlayer(id, name, conn)
+	mr.register <- p
+	go p.WritePump(context.Background())
+	return p, conn
+}
+
+func TestMatchingRoomAcrossServers(t *testing.T) {
+	bus := newFakeBus()
+	roomA := startTestRoom(t, "beginner", bus)
+	roomB := startTestRoom(t, "beginner", bus)
+
+	bob, bobConn := joinTestRoom(roomA, "1", "bob")
+	alice, aliceConn := joinTestRoom(roomB, "2", "alice")
+
+	t.Run("他のサーバに接続しているプレイヤーの参加を受信できる。", func(t *testing.T) {
+		msg := bobConn.expect(t, common.JOIN)
+		if msg.Source.ID != alice.GetID() {
+			t.Errorf("Expected: %s\n\t\t Actual: %s \n", alice.GetID(), msg.Source.ID)
+		}
+	})
+	t.Run("他のサーバに接続しているプレイヤーに対戦申請を送信できる。", func(t *testing.T) {
+		roomA.message <- &playerMessage{bob, &common.MatchingMessage{Dest: alice.GetProfile(), Data: common.OFFER}}
+		msg := aliceConn.expect(t, common.OFFER)
+		if msg.Source.ID != bob.GetID() {
+			t.Errorf("Expected: %s\n\t\t Actual: %s \n", bob.GetID(), msg.Source.ID)
+		}
+	})
+	t.Run("他のサーバに接続しているプレイヤーの退室を受信できる。", func(t *testing.T) {
+		roomB.unregister <- alice
+		msg := bobConn.expect(t, common.LEAVE)
+		if msg.Source.ID != alice.GetID() {
+			t.Errorf("Expected: %s\n\t\t Actual: %s \n", alice.GetID(), msg.Source.ID)
+		}
+	})
+}
+
+func TestMatchingRoomSyncsLateServer(t *testing.T) {
+	bus := newFakeBus()
+	roomA := startTestRoom(t, "beginner", bus)
+	bob, _ := joinTestRoom(roomA, "1", "bob")
+	carol, carolConn := joinTestRoom(roomA, "3", "carol")
+	roomA.message <- &playerMessage{bob, &common.MatchingMessage{Dest: carol.GetProfile(), Data: common.OFFER}}
+	carolConn.expect(t, common.OFFER)
+
+	roomB := startTestRoom(t, "beginner", bus)
+	alice, _ := joinTestRoom(roomB, "2", "alice")
+
+	t.Run("後から購読を開始したサーバも、既に参加していたプレイヤーとその状態を把握する。", func(t *testing.T) {
+		deadline := time.Now().Add(time.Second)
+		for !hasPlayer(roomB, bob.GetID()) || !hasPlayer(roomB, carol.GetID()) {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected: bob and carol\n\t\t Actual: %v \n", roomB.GetLobby())
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if s := statusOf(roomB, bob.GetID()); s != NEGOTIATING {
+			t.Errorf("Expected: %s\n\t\t Actual: %s \n", NEGOTIATING, s)
+		}
+	})
+	t.Run("後から購読を開始したサーバのプレイヤーも、既に参加していたプレイヤーに対戦を申請できる。", func(t *testing.T) {
+		roomB.message <- &playerMessage{alice, &common.MatchingMessage{Dest: carol.GetProfile(), Data: common.OFFER}}
+		if msg := carolConn.expect(t, common.OFFER); msg.Source.ID != alice.GetID() {
+			t.Errorf("Expected: %s\n\t\t Actual: %s \n", alice.GetID(), msg.Source.ID)
+		}
+	})
+}
+
+func hasPlayer(mr *MatchingRoom, id string) bool {
+	mr.mu.RLock()
+	defer mr.mu.RUnlock()
+	return mr.Players[id] != nil
+}
+
+func TestMatchingRoomEvictsStalePlayer(t *testing.T) {
+	bus := newFakeBus()
+	roomA := startTestRoom(t, "beginner", bus)
+	roomB := startTestRoom(t, "beginner", bus)
+	_, bobConn := joinTestRoom(roomA, "1", "bob")
+	alice, aliceConn := joinTestRoom(roomB, "2", "alice")
+	bobConn.expect(t, common.JOIN)
+	go alice.ReadPump(roomB)
+
+	close(aliceConn.stale)
+	t.Run("応答が途絶えたプレイヤーは退室させられ、他のサーバのプレイヤーにも退室が届く。", func(t *testing.T) {
+		msg := bobConn.expect(t, common.LEAVE)
+		if msg.Source.ID != alice.GetID() {
+			t.Errorf("Expected: %s\n\t\t Actual: %s \n", alice.GetID(), msg.Source.ID)
+		}
+	})
+	t.Run("応答が途絶えたプレイヤーの接続は閉じられる。", func(t *testing.T) {
+		select {
+		case <-aliceConn.closed:
+		case <-time.After(time.Second):
+			t.Errorf("Expected: closed\n\t\t Actual: open \n")
+		}
+	})
+	t.Run("退室させられたプレイヤーはロビーから取り除かれる。", func(t *testing.T) {
+		for _, mr := range []*MatchingRoom{roomA, roomB} {
+			deadline := time.Now().Add(time.Second)
+			for hasPlayer(mr, alice.GetID()) {
+				if time.Now().After(deadline) {
+					t.Fatalf("Expected: no %s\n\t\t Actual: %s \n", alice.GetID(), mr.Name)
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}
+	})
+}
+
+func TestMatchingRoomResumesSession(t *testing.T) {
+	bus := newFakeBus()
+	roomA := startTestRoom(t, "beginner", bus)
+	roomB := startTestRoom(t, "beginner", bus)
+	bob, bobConn := joinTestRoom(roomA, "1", "bob")
+	alice, aliceConn := joinTestRoom(roomB, "2", "alice")
+	bobConn.expect(t, common.JOIN)
+	roomA.message <- &playerMessage{bob, &common.MatchingMessage{Dest: alice.GetProfile(), Data: common.OFFER}}
+	aliceConn.expect(t, common.OFFER)
+	bobConn.expect(t, common.OFFER)
+
+	_, bob2Conn := joinTestRoom(roomA, bob.GetID(), bob.GetName())
+	t.Run("接続し直したプレイヤーにはロビーの全員の状態が届く。", func(t *testing.T) {
+		msg := bob2Conn.expect(t, common.SNAPSHOT)
+		for _, p := range msg.Lobby {
+			if p.Profile.ID == bob.GetID() && p.Status != common.NEGOTIATING {
+				t.Errorf("Expected: %s\n\t\t Actual: %s \n", common.NEGOTIATING, p.Status)
+			}
+		}
+	})
+	t.Run("古い接続は閉じられる。", func(t *testing.T) {
+		select {
+		case <-bobConn.closed:
+		case <-time.After(time.Second):
+			t.Errorf("Expected: closed\n\t\t Actual: open \n")
+		}
+	})
+	t.Run("接続し直す前の対戦申請を承諾でき、他のプレイヤーには退室が届かない。", func(t *testing.T) {
+		roomB.message <- &playerMessage{alice, &common.MatchingMessage{Dest: bob.GetProfile(), Data: common.ACCEPT}}
+		bob2Conn.expect(t, common.ACCEPT)
+		aliceConn.expectWithout(t, common.ACCEPT, common.LEAVE)
+	})
+}
+
+func TestMatchingRoomResumesAfterDisconnect(t *testing.T) {
+	bus := newFakeBus()
+	clock := &fakeClock{now: time.Now()}
+	roomA := NewMatchingRoom("beginner", bus)
+	roomA.now = clock.Now
+	roomA.sweep = 10 * time.Millisecond
+	runTestRoom(t, roomA, bus)
+	roomB := startTestRoom(t, "beginner", bus)
+	bob, bobConn := joinTestRoom(roomA, "1", "bob")
+	alice, aliceConn := joinTestRoom(roomB, "2", "alice")
+	bobConn.expect(t, common.JOIN)
+	roomA.message <- &playerMessage{bob, &common.MatchingMessage{Dest: alice.GetProfile(), Data: common.OFFER}}
+	aliceConn.expect(t, common.OFFER)
+
+	// 切断に気付いてから、クライアントが接続し直す。
+	roomA.unregister <- bob
+	_, bob2Conn := joinTestRoom(roomA, bob.GetID(), bob.GetName())
+	t.Run("切断された後に接続し直すと、切断前の状態を引き継ぐ。", func(t *testing.T) {
+		msg := bob2Conn.expect(t, common.SNAPSHOT)
+		for _, p := range msg.Lobby {
+			if p.Profile.ID == bob.GetID() && p.Status != common.NEGOTIATING {
+				t.Errorf("Expected: %s\n\t\t Actual: %s \n", common.NEGOTIATING, p.Status)
+			}
+		}
+	})
+	t.Run("切断される前の対戦申請を承諾でき、他のプレイヤーには退室が届かない。", func(t *testing.T) {
+		roomB.message <- &playerMessage{alice, &common.MatchingMessage{Dest: bob.GetProfile(), Data: common.ACCEPT}}
+		bob2Conn.expect(t, common.ACCEPT)
+		aliceConn.expectWithout(t, common.ACCEPT, common.LEAVE)
+	})
+	t.Run("期限までに接続し直さなかったプレイヤーは退室させる。", func(t *testing.T) {
+		carol, _ := joinTestRoom(roomA, "3", "carol")
+		aliceConn.expect(t, common.JOIN)
+		roomA.unregister <- carol
+		// 切断を処理してから期限を過ぎるように、他のプレイヤーの参加を待つ。
+		joinTestRoom(roomA, "4", "dave")
+		aliceConn.expect(t, common.JOIN)
+		clock.Advance(RESUME_GRACE_PERIOD)
+		msg := aliceConn.expect(t, common.LEAVE)
+		if msg.Source.ID != carol.GetID() {
+			t.Errorf("Expected: %s\n\t\t Actual: %s \n", carol.GetID(), msg.Source.ID)
+		}
+	})
+}
+
+func TestMatchingRoomResumesSessionAcrossServers(t *testing.T) {
+	bus := newFakeBus()
+	roomA := startTestRoom(t, "beginner", bus)
+	roomB := startTestRoom(t, "beginner", bus)
+	bob, bobConn := joinTestRoom(roomA, "1", "bob")
+	alice, aliceConn := joinTestRoom(roomB, "2", "alice")
+	bobConn.expect(t, common.JOIN)
+	aliceConn.expect(t, common.SNAPSHOT)
+
+	_, bob2Conn := joinTestRoom(roomB, bob.GetID(), bob.GetName())
+	bob2Conn.expect(t, common.SNAPSHOT)
+	t.Run("他のサーバにある古い接続は閉じられる。", func(t *testing.T) {
+		select {
+		case <-bobConn.closed:
+		case <-time.After(time.Second):
+			t.Errorf("Expected: closed\n\t\t Actual: open \n")
+		}
+	})
+	roomA.unregister <- bob
+	t.Run("古い接続が切断されても、退室させない。", func(t *testing.T) {
+		roomB.message <- &playerMessage{alice, &common.MatchingMessage{Dest: bob.GetProfile(), Data: common.OFFER}}
+		bob2Conn.expect(t, common.OFFER)
+		aliceConn.expectWithout(t, common.OFFER, common.LEAVE)
+		if !hasPlayer(roomA, bob.GetID()) {
+			t.Errorf("Expected: %v\n\t\t Actual: %v \n", true, false)
+		}
+	})
+}
+
+// fakeMembersはテスト用のMatchingRoomRepositoryの実装
+type fakeMembers struct {
+	mu  sync.Mutex
+	ids map[string]map[string]bool
+}
+
+func newFakeMembers() *fakeMembers {
+	return &fakeMembers{ids: make(map[string]map[string]bool)}
+}
+
+func (m *fakeMembers) GetAll(room string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ids := []string{}
+	for id := range m.ids[room] {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (m *fakeMembers) SetID(room string, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.ids[room] == nil {
+		m.ids[room] = make(map[string]bool)
+	}
+	m.ids[room][id] = true
+	return nil
+}
+
+func (m *fakeMembers) RemoveID(room string, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.ids[room], id)
+	return nil
+}
+
+func (m *fakeMembers) has(room string, id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.ids[room][id]
+}
+
+func TestMatchingRoomRecordsMembers(t *testing.T) {
+	bus := newFakeBus()
+	members := newFakeMembers()
+	mr := NewMatchingRoom("beginner", bus)
+	mr.SetMembers(members)
+	mr.grace = 0
+	runTestRoom(t, mr, bus)
+
+	bob, bobConn := joinTestRoom(mr, "1", "bob")
+	bobConn.expect(t, common.SNAPSHOT)
+	t.Run("参加したプレイヤーを記録する。", func(t *testing.T) {
+		if !members.has(mr.Name, bob.GetID()) {
+			t.Errorf("Expected: %v\n\t\t Actual: %v \n", true, false)
+		}
+	})
+	bob2, bob2Conn := joinTestRoom(mr, bob.GetID(), bob.GetName())
+	bob2Conn.expect(t, common.SNAPSHOT)
+	mr.unregister <- bob
+	_, carolConn := joinTestRoom(mr, "3", "carol")
+	bob2Conn.expect(t, common.JOIN)
+	t.Run("接続し直す前の古い接続が切断されても、記録から外さない。", func(t *testing.T) {
+		if !members.has(mr.Name, bob.GetID()) {
+			t.Errorf("Expected: %v\n\t\t Actual: %v \n", true, false)
+		}
+	})
+	mr.unregister <- bob2
+	carolConn.expect(t, common.LEAVE)
+	t.Run("退室したプレイヤーは記録から外す。", func(t *testing.T) {
+		if members.has(mr.Name, bob.GetID()) {
+			t.Errorf("Expected: %v\n\t\t Actual: %v \n", false, true)
+		}
+	})
+}
+
+func TestMatchingRoomRestoresMembers(t *testing.T) {
+	bus := newFakeBus()
+	roomA := startTestRoom(t, "beginner", bus)
+	_, carolConn := joinTestRoom(roomA, "3", "carol")
+	carolConn.expect(t, common.SNAPSHOT)
+	members := newFakeMembers()
+	for _, id := range []string{"1", "2", "3"} {
+		members.SetID("beginner", id)
+	}
+
+	clock := &fakeClock{now: time.Now()}
+	roomB := NewMatchingRoom("beginner", bus)
+	roomB.SetMembers(members)
+	roomB.now = clock.Now
+	roomB.sweep = 10 * time.Millisecond
+	roomB.capacity = 2
+	runTestRoom(t, roomB, bus)
+	for !hasPlayer(roomB, "3") {
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Run("起動前に参加していたプレイヤーが戻るまで、新しいプレイヤーには席を空けておく。", func(t *testing.T) {
+		_, daveConn := joinTestRoom(roomB, "4", "dave")
+		if msg := daveConn.expect(t, common.ERROR); msg.Code != common.ERR_ROOM_FULL {
+			t.Errorf("Expected: %s\n\t\t Actual: %s \n", common.ERR_ROOM_FULL, msg.Code)
+		}
+	})
+	t.Run("起動前に参加していたプレイヤーは、定員に空きがあれば接続し直せる。", func(t *testing.T) {
+		_, bobConn := joinTestRoom(roomB, "2", "bob")
+		bobConn.expectWithout(t, common.SNAPSHOT, common.ERROR)
+	})
+	t.Run("起動前に参加していたプレイヤーも、定員に達していれば接続し直せない。", func(t *testing.T) {
+		_, aliceConn := joinTestRoom(roomB, "1", "alice")
+		if msg := aliceConn.expect(t, common.ERROR); msg.Code != common.ERR_ROOM_FULL {
+			t.Errorf("Expected: %s\n\t\t Actual: %s \n", common.ERR_ROOM_FULL, msg.Code)
+		}
+	})
+	t.Run("接続し直すのを待つ期限を過ぎると、戻らなかったプレイヤーを記録から外す。", func(t *testing.T) {
+		clock.Advance(RESTORE_GRACE_PERIOD)
+		timeout := time.After(time.Second)
+		for members.has("beginner", "1") {
+			select {
+			case <-timeout:
+				t.Fatalf("Expected: %v\n\t\t Actual: %v \n", false, true)
+			case <-time.After(time.Millisecond):
+			}
+		}
+		for _, id := range []string{"2", "3"} {
+			if !members.has("beginner", id) {
+				t.Errorf("Expected: %s is recorded\n\t\t Actual: %s is not recorded \n", id, id)
+			}
+		}
+	})
+}
+
+func TestMatchingRoomsAreIsolated(t *testing.T) {
+	bus := newFakeBus()
+	beginner := startTestRoom(t, "beginner", bus)
+	advanced := startTestRoom(t, "advanced", bus)
+
+	_, bobConn := joinTestRoom(beginner, "1", "bob")
+	joinTestRoom(advanced, "2", "alice")
+	joinTestRoom(beginner, "3", "carol")
+
+	t.Run("別のマッチングルームのプレイヤーの参加は受信しない。", func(t *testing.T) {
+		msg := bobConn.expect(t, common.JOIN)
+		if msg.Source.ID != "3" {
+			t.Errorf("Expected: %s\n\t\t Actual: %s \n", "3", msg.Source.ID)
+		}
+	})
+}
+
+// stuckConnは書き込みが完了しないConnの実装
+// 応答しなくなったクライアントを想定する。
+type stuckConn struct {
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newStuckConn() *stuckConn {
+	return &stuckConn{closed: make(chan struct{})}
+}
+
+func (c *stuckConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+func (c *stuckConn) Write(common.Message) error {
+	<-c.closed
+	return context.Canceled
+}
+
+func (c *stuckConn) Read(common.Message) error {
+	<-c.closed
+	return context.Canceled
+}
+
+func TestMatchingRoomEvictsSlowConsumer(t *testing.T) {
+	bus := newFakeBus()
+	mr := startTestRoom(t, "beginner", bus)
+
+	stuck := newStuckConn()
+	slow := NewMatchingPlayer("0", "slow", stuck)
+	mr.register <- slow
+	go slow.WritePump(context.Background())
+	bob, bobConn := joinTestRoom(mr, "1", "bob")
+
+	t.Run("応答しないプレイヤーがいても他のプレイヤーはメッセージを受信でき、応答しないプレイヤーの退出が通知される。", func(t *testing.T) {
+		for i := 0; i < OUTBOX_SIZE*2; i++ {
+			guest := NewMatchingPlayer(fmt.Sprintf("guest-%d", i), "guest", countConn{new(int64)})
+			mr.register <- guest
+			go guest.WritePump(context.Background())
+		}
+		joins, evicted := 0, false
+		for joins < OUTBOX_SIZE*2 || !evicted {
+			select {
+			case msg := <-bobConn.written:
+				switch msg.Data {
+				case common.JOIN:
+					joins++
+				case common.LEAVE:
+					evicted = evicted || msg.Source.ID == slow.GetID()
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("timed out: joins=%d evicted=%t", joins, evicted)
+			}
+		}
+		select {
+		case <-stuck.closed:
+		default:
+			t.Errorf("slow player was not disconnected")
+		}
+	})
+	t.Run("退出済みのプレイヤーの切断を処理してもpanicしない。", func(t *testing.T) {
+		mr.unregister <- slow
+		mr.message <- &playerMessage{bob, &common.MatchingMessage{Dest: slow.GetProfile(), Data: common.OFFER}}
+		bobConn.expect(t, common.ERROR)
+	})
+}
+
+// countConnは書き込まれたメッセージの数を数えるConnの実装
+type countConn struct {
+	count *int64
+}
+
+func (c countConn) Close() error { return nil }
+
+func (c countConn) Write(common.Message) error {
+	atomic.AddInt64(c.count, 1)
+	return nil
+}
+
+func (c countConn) Read(common.Message) error {
+	select {}
+}
+
+// 参加と退出を繰り返し、ロビー全体にメッセージを配信する速さを計測する。
+func BenchmarkMatchingRoomBroadcast(b *testing.B) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	for _, n := range []int{100, 500} {
+		for _, withStuck := range []bool{false, true} {
+			name := fmt.Sprintf("players=%d/stuck=%t", n, withStuck)
+			b.Run(name, func(b *testing.B) {
+				bus := newFakeBus()
+				mr := NewMatchingRoom("beginner", bus)
+				mr.capacity = n + 2 // 詰まったプレイヤーと計測中に参加するプレイヤーの分
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				go mr.Run(ctx)
+
+				var count int64
+				for i := 0; i < n; i++ {
+					p := NewMatchingPlayer(fmt.Sprintf("%d", i), "player", countConn{&count})
+					mr.register <- p
+					go p.WritePump(ctx)
+				}
+				if withStuck {
+					p := NewMatchingPlayer("stuck", "stuck", newStuckConn())
+					mr.register <- p
+					go p.WritePump(ctx)
+				}
+				// 全員の参加が配信されるまで待つ。
+				for atomic.LoadInt64(&count) < int64(n*(n-1)/2) {
+					time.Sleep(time.Millisecond)
+				}
+
+				atomic.StoreInt64(&count, 0)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					p := NewMatchingPlayer("guest", "guest", countConn{new(int64)})
+					mr.register <- p
+					mr.unregister <- p
+				}
+				for atomic.LoadInt64(&count) < int64(2*n*b.N) {
+					time.Sleep(time.Microsecond)
+				}
+			})
+		}
+	}
+}
+
+func statusOf(mr *MatchingRoom, id string) MatchingStatus {
+	mr.mu.RLock()
+	defer mr.mu.RUnlock()
+	return mr.Players[id].GetStatus()
+}
+
+func TestMatchingRoomNegotiation(t *testing.T) {
+	bus := newFakeBus()
+	mr := startTestRoom(t, "beginner", bus)
+	bob, bobConn := joinTestRoom(mr, "1", "bob")
+	alice, aliceConn := joinTestRoom(mr, "2", "alice")
+	carol, carolConn := joinTestRoom(mr, "3", "carol")
+	bobConn.expect(t, common.JOIN)
+	bobConn.expect(t, common.JOIN)
+	aliceConn.expect(t, common.JOIN)
+
+	send := func(src, dst *MatchingPlayer, data common.MatchingMessageData) {
+		mr.message <- &playerMessage{src, &common.MatchingMessage{Dest: dst.GetProfile(), Data: data}}
+	}
+	expectStatus := func(t *testing.T, p *MatchingPlayer, expected MatchingStatus) {
+		t.Helper()
+		if actual := statusOf(mr, p.GetID()); actual != expected {
+			t.Errorf("%s Expected: %s\n\t\t Actual: %s \n", p.GetName(), expected, actual)
+		}
+	}
+
+	t.Run("対戦申請を送信すると申請者がNEGOTIATINGになり、受信者はWAITINGのまま申請を受け付ける。", func(t *testing.T) {
+		send(bob, alice, common.OFFER)
+		aliceConn.expect(t, common.OFFER)
+		expectStatus(t, bob, NEGOTIATING)
+		expectStatus(t, alice, WAITING)
+	})
+	t.Run("申請中のプレイヤーへの対戦申請は拒否される。", func(t *testing.T) {
+		send(carol, bob, common.OFFER)
+		if msg := carolConn.expect(t, common.ERROR); msg.Reason == "" {
+			t.Errorf("ERROR has no reason")
+		}
+		expectStatus(t, carol, WAITING)
+	})
+	t.Run("交渉に関係のないプレイヤーは申請を取り消すことができない。", func(t *testing.T) {
+		send(carol, alice, common.CANCEL_OFFER)
+		carolConn.expect(t, common.ERROR)
+		expectStatus(t, bob, NEGOTIATING)
+	})
+	t.Run("申請者は自分の申請を承諾することができない。", func(t *testing.T) {
+		send(bob, alice, common.ACCEPT)
+		bobConn.expect(t, common.ERROR)
+		expectStatus(t, bob, NEGOTIATING)
+	})
+	t.Run("申請を取り消すと両者がWAITINGに戻る。", func(t *testing.T) {
+		send(bob, alice, common.CANCEL_OFFER)
+		aliceConn.expect(t, common.CANCEL_OFFER)
+		expectStatus(t, bob, WAITING)
+		expectStatus(t, alice, WAITING)
+	})
+	t.Run("申請を断ると両者がWAITINGに戻る。", func(t *testing.T) {
+		send(bob, alice, common.OFFER)
+		aliceConn.expect(t, common.OFFER)
+		send(alice, bob, common.DENY)
+		bobConn.expect(t, common.DENY)
+		expectStatus(t, bob, WAITING)
+		expectStatus(t, alice, WAITING)
+	})
+	t.Run("申請者が退室すると受信者に取り消しが通知される。", func(t *testing.T) {
+		send(carol, alice, common.OFFER)
+		aliceConn.expect(t, common.OFFER)
+		mr.unregister <- carol
+		msg := aliceConn.expect(t, common.CANCEL_OFFER)
+		if msg.Source.ID != carol.GetID() {
+			t.Errorf("Expected: %s\n\t\t Actual: %s \n", carol.GetID(), msg.Source.ID)
+		}
+		expectStatus(t, alice, WAITING)
+	})
+	t.Run("申請を承諾すると両者がIN_BATTLEになり、対戦申請を受け付けなくなる。", func(t *testing.T) {
+		send(bob, alice, common.OFFER)
+		aliceConn.expect(t, common.OFFER)
+		send(alice, bob, common.ACCEPT)
+		bobConn.expect(t, common.ACCEPT)
+		aliceConn.expect(t, common.ACCEPT)
+		expectStatus(t, bob, IN_BATTLE)
+		expectStatus(t, alice, IN_BATTLE)
+		send(alice, bob, common.OFFER)
+		aliceConn.expect(t, common.ERROR)
+	})
+}
+
+func TestMatchingRoomOfferExpiry(t *testing.T) {
+	bus := newFakeBus()
+	mr := NewMatchingRoom("beginner", bus)
+	mr.timeout = 100 * time.Millisecond
+	mr.sweep = 10 * time.Millisecond
+	runTestRoom(t, mr, bus)
+	bob, bobConn := joinTestRoom(mr, "1", "bob")
+	alice, aliceConn := joinTestRoom(mr, "2", "alice")
+	bobConn.expect(t, common.JOIN)
+
+	t.Run("申請者と受信者の両方に回答期限が通知される。", func(t *testing.T) {
+		mr.message <- &playerMessage{bob, &common.MatchingMessage{Dest: alice.GetProfile(), Data: common.OFFER}}
+		received := aliceConn.expect(t, common.OFFER)
+		echoed := bobConn.expect(t, common.OFFER)
+		if received.Deadline == nil || echoed.Deadline == nil || !received.Deadline.Equal(*echoed.Deadline) {
+			t.Errorf("Expected same deadline\n\t\t Actual: %v, %v \n", received.Deadline, echoed.Deadline)
+		}
+	})
+	t.Run("回答期限を過ぎると両者に期限切れが通知され、WAITINGに戻る。", func(t *testing.T) {
+		bobConn.expect(t, common.OFFER_EXPIRED)
+		aliceConn.expect(t, common.OFFER_EXPIRED)
+		if s := statusOf(mr, bob.GetID()); s != WAITING {
+			t.Errorf("Expected: %s\n\t\t Actual: %s \n", WAITING, s)
+		}
+		if s := statusOf(mr, alice.GetID()); s != WAITING {
+			t.Errorf("Expected: %s\n\t\t Actual: %s \n", WAITING, s)
+		}
+	})
+	t.Run("回答期限までに承諾された申請は期限切れにならない。", func(t *testing.T) {
+		mr.message <- &playerMessage{bob, &common.MatchingMessage{Dest: alice.GetProfile(), Data: common.OFFER}}
+		aliceConn.expect(t, common.OFFER)
+		mr.message <- &playerMessage{alice, &common.MatchingMessage{Dest: bob.GetProfile(), Data: common.ACCEPT}}
+		bobConn.expect(t, common.ACCEPT)
+		time.Sleep(2 * mr.timeout)
+		// 対戦を開始したaliceの状態の通知以外は届かない。
+		for len(bobConn.written) > 0 {
+			if msg := <-bobConn.written; msg.Data != common.STATUS {
+				t.Errorf("unexpected message: %#v", msg)
+			}
+		}
+		if s := statusOf(mr, bob.GetID()); s != IN_BATTLE {
+			t.Errorf("Expected: %s\n\t\t Actual: %s \n", IN_BATTLE, s)
+		}
+	})
+}
+
+func TestMatchingRoomRejectsSpoofedSource(t *testing.T) {
+	bus := newFakeBus()
+	mr := startTestRoom(t, "beginner", bus)
+	bob, bobConn := joinTestRoom(mr, "1", "bob")
+	alice, aliceConn := joinTestRoom(mr, "2", "alice")
+	carol, carolConn := joinTestRoom(mr, "3", "carol")
+	go bob.ReadPump(mr)
+	go carol.ReadPump(mr)
+	bobConn.expect(t, common.JOIN)
+	bobConn.expect(t, common.JOIN)
+
+	tests := map[string]struct {
+		msg *common.MatchingMessage
+	}{
+		"他のプレイヤーになりすました対戦申請は拒否される。": {
+			msg: &common.MatchingMessage{Source: alice.GetProfile(), Dest: carol.GetProfile(), Data: common.OFFER},
+		},
+		"他のプレイヤーになりすました承諾は拒否される。": {
+			msg: &common.MatchingMessage{Source: alice.GetProfile(), Dest: carol.GetProfile(), Data: common.ACCEPT},
+		},
+		"他のプレイヤーの退室を送信しても拒否される。": {
+			msg: &common.MatchingMessage{Source: alice.GetProfile(), Data: common.LEAVE},
+		},
+		"自身の参加を送信しても拒否される。": {
+			msg: &common.MatchingMessage{Source: bob.GetProfile(), Data: common.JOIN},
+		},
+	}
+	for tName, tt := range tests {
+		t.Run(tName, func(t *testing.T) {
+			bobConn.incoming <- tt.msg
+			if msg := bobConn.expect(t, common.ERROR); msg.Reason == "" {
+				t.Errorf("ERROR has no reason")
+			}
+			if s := statusOf(mr, alice.GetID()); s != WAITING {
+				t.Errorf("Expected: %s\n\t\t Actual: %s \n", WAITING, s)
+			}
+		})
+	}
+
+	t.Run("送信者を省略したメッセージはセッションのプレイヤーから送信されたものとして扱う。", func(t *testing.T) {
+		bobConn.incoming <- &common.MatchingMessage{Dest: carol.GetProfile(), Data: common.OFFER}
+		msg := carolConn.expect(t, common.OFFER)
+		if msg.Source.ID != bob.GetID() {
+			t.Errorf("Expected: %s\n\t\t Actual: %s \n", bob.GetID(), msg.Source.ID)
+		}
+	})
+	t.Run("受信者は申請者になりすまして申請を取り消すことができない。", func(t *testing.T) {
+		carolConn.incoming <- &common.MatchingMessage{Source: bob.GetProfile(), Dest: carol.GetProfile(), Data: common.CANCEL_OFFER}
+		carolConn.expect(t, common.ERROR)
+		if s := statusOf(mr, bob.GetID()); s != NEGOTIATING {
+			t.Errorf("Expected: %s\n\t\t Actual: %s \n", NEGOTIATING, s)
+		}
+	})
+	// なりすまされたプレイヤーにはロビーの変化の通知以外のメッセージが届かない。
+	for len(aliceConn.written) > 0 {
+		if msg := <-aliceConn.written; msg.Data != common.JOIN && msg.Data != common.STATUS && msg.Data != common.SNAPSHOT {
+			t.Errorf("alice received unexpected message: %#v", msg)
+		}
+	}
+}
+
+func TestMatchingRoomOfferInbox(t *testing.T) {
+	bus := newFakeBus()
+	mr := startTestRoom(t, "beginner", bus)
+	bob, bobConn := joinTestRoom(mr, "1", "bob")
+	alice, aliceConn := joinTestRoom(mr, "2", "alice")
+	carol, carolConn := joinTestRoom(mr, "3", "carol")
+	dave, daveConn := joinTestRoom(mr, "4", "dave")
+	erin, _ := joinTestRoom(mr, "5", "erin")
+	for i := 0; i < 4; i++ {
+		bobConn.expect(t, common.JOIN)
+	}
+
+	send := func(src, dst *MatchingPlayer, data common.MatchingMessageData) {
+		mr.message <- &playerMessage{src, &common.MatchingMessage{Dest: dst.GetProfile(), Data: data}}
+	}
+	expectStatus := func(t *testing.T, p *MatchingPlayer, expected MatchingStatus) {
+		t.Helper()
+		if actual := statusOf(mr, p.GetID()); actual != expected {
+			t.Errorf("%s Expected: %s\n\t\t Actual: %s \n", p.GetName(), expected, actual)
+		}
+	}
+
+	t.Run("申請を送信すると、受け取っていた申請は断られる。", func(t *testing.T) {
+		send(bob, alice, common.OFFER)
+		aliceConn.expect(t, common.OFFER)
+		send(alice, carol, common.OFFER)
+		carolConn.expect(t, common.OFFER)
+		aliceConn.expect(t, common.OFFER) // 申請者自身にも申請が届く
+		msg := bobConn.expect(t, common.DENY)
+		if msg.Source.ID != alice.GetID() {
+			t.Errorf("Expected: %s\n\t\t Actual: %s \n", alice.GetID(), msg.Source.ID)
+		}
+		expectStatus(t, bob, WAITING)
+		expectStatus(t, alice, NEGOTIATING)
+		send(alice, carol, common.CANCEL_OFFER)
+		carolConn.expect(t, common.CANCEL_OFFER)
+	})
+	t.Run("複数の申請を受け取り、一つを承諾すると他の申請は断られる。", func(t *testing.T) {
+		send(bob, alice, common.OFFER)
+		aliceConn.expect(t, common.OFFER)
+		send(carol, alice, common.OFFER)
+		aliceConn.expect(t, common.OFFER)
+		expectStatus(t, bob, NEGOTIATING)
+		expectStatus(t, carol, NEGOTIATING)
+		send(alice, carol, common.ACCEPT)
+		carolConn.expect(t, common.ACCEPT)
+		bobConn.expect(t, common.DENY)
+		expectStatus(t, alice, IN_BATTLE)
+		expectStatus(t, carol, IN_BATTLE)
+		expectStatus(t, bob, WAITING)
+	})
+	t.Run("受信者が退室すると、全ての申請者に不承諾が通知される。", func(t *testing.T) {
+		send(bob, erin, common.OFFER)
+		send(dave, erin, common.OFFER)
+		bobConn.expect(t, common.OFFER)
+		daveConn.expect(t, common.OFFER)
+		mr.unregister <- erin
+		bobConn.expect(t, common.DENY)
+		daveConn.expect(t, common.DENY)
+		expectStatus(t, bob, WAITING)
+		expectStatus(t, dave, WAITING)
+	})
+}
@@ -0,0 +1,262 @@
+package model
+
+import (
+	"github.com/taise-hub/shellgame-cli/common"
+	"log"
+	"math"
+	"time"
+)
+
+// 組み合わせを提案してから、成立の知らせ(MATCHED)が届かない場合に提案し直すまでの時間
+// MatchingEventBusでイベントが失われても、待ち行列のプレイヤーが組み合わされないまま残らないようにする。
+const MATCH_PROPOSAL_TIMEOUT = 10 * time.Second
+
+// ランダム対戦の待ち行列に並んでいるプレイヤー
+type queueEntry struct {
+	id         string
+	rating     int
+	since      time.Time // 待ち行列に並んだ時刻。サーバごとに自身の時計で記録する。
+	proposed   bool      // このサーバから組み合わせを提案済みかどうか
+	proposedAt time.Time // 組み合わせを提案した時刻
+}
+
+func (mr *MatchingRoom) queueing(msg *common.MatchingMessage) error {
+	if mr.Players[msg.Source.ID] == nil {
+		return newMatchingError(common.ERR_PLAYER_NOT_FOUND, "ルームに参加していません。")
+	}
+	switch msg.Data {
+	case common.QUEUE:
+		if err := mr.HandleQueue(msg); err != nil {
+			return err
+		}
+		mr.proposeMatches()
+		return nil
+	case common.LEAVE_QUEUE:
+		return mr.HandleLeaveQueue(msg)
+	}
+	return nil
+}
+
+// 待ち行列への参加処理
+// WAITINGのプレイヤー(Source)をQUEUEDにして待ち行列の末尾に並べる。参加できたことはnotifyQueue()で通知する。
+// QUEUEDのプレイヤーは対戦申請を送受信できないため、受け取っていた申請は全て断る。
+func (mr *MatchingRoom) HandleQueue(msg *common.MatchingMessage) error {
+	mr.mu.Lock()
+	p := mr.Players[msg.Source.ID]
+	if s := p.GetStatus(); s != WAITING {
+		mr.mu.Unlock()
+		return busyError("あなた", s)
+	}
+	if _, ok := mr.parties[msg.Source.ID]; ok {
+		mr.mu.Unlock()
+		return newMatchingError(common.ERR_NOT_PERMITTED, "パーティではランダム対戦に参加できません。")
+	}
+	p.SetStatus(QUEUED)
+	mr.queue = append(mr.queue, &queueEntry{id: msg.Source.ID, rating: p.GetProfile().Rating, since: mr.now()})
+	mr.mu.Unlock()
+
+	log.Printf("[+] QUEUE: %s\n", msg.Source.Name)
+	mr.declineInbox(msg.Source)
+	return nil
+}
+
+// 待ち行列からの離脱処理
+// 待ち行列に並んでいるプレイヤー(Source)をWAITINGに戻し、離脱できたことを送り返す。
+func (mr *MatchingRoom) HandleLeaveQueue(msg *common.MatchingMessage) error {
+	mr.mu.Lock()
+	if !mr.dequeue(msg.Source.ID) {
+		mr.mu.Unlock()
+		return newMatchingError(common.ERR_INVALID_MESSAGE, "ランダム対戦の待ち行列に並んでいません。")
+	}
+	mr.Players[msg.Source.ID].SetStatus(WAITING)
+	mr.mu.Unlock()
+
+	log.Printf("[+] LEAVE QUEUE: %s\n", msg.Source.Name)
+	mr.send(msg.Source.ID, msg)
+	return nil
+}
+
+// 組み合わせの成立処理
+// 提案された二人(Source, Dest)が共に待ち行列に並んでいる場合、承諾された対戦申請と同様に両者をIN_BATTLEにして1対1の対戦を開始する。
+// 既にどちらかが待ち行列にいない場合は提案を破棄し、改めて組み合わせを探せるようにする。
+func (mr *MatchingRoom) HandleMatched(msg *common.MatchingMessage) error {
+	if msg.Dest == nil {
+		return newMatchingError(common.ERR_INVALID_MESSAGE, "組み合わせの相手がいません。")
+	} else if msg.Battle == nil {
+		return newMatchingError(common.ERR_INVALID_MESSAGE, "組み合わせの対戦がありません。")
+	}
+	mr.mu.Lock()
+	if mr.indexInQueue(msg.Source.ID) < 0 || mr.indexInQueue(msg.Dest.ID) < 0 {
+		mr.resetProposals(msg.Source.ID, msg.Dest.ID)
+		mr.mu.Unlock()
+		mr.proposeMatches()
+		return newMatchingError(common.ERR_PLAYER_NOT_FOUND, "%sと%sは既に待ち行列にいません。", msg.Source.Name, msg.Dest.Name)
+	}
+	mr.dequeue(msg.Source.ID)
+	mr.dequeue(msg.Dest.ID)
+	teams := [][]*common.Profile{{mr.Players[msg.Source.ID].GetProfile()}, {mr.Players[msg.Dest.ID].GetProfile()}}
+	battle := mr.startBattle(msg.Battle.ID, common.DUEL, teams, false)
+	mr.mu.Unlock()
+
+	log.Printf("[+] MATCHED: %s and %s\n", msg.Source.Name, msg.Dest.Name)
+	reply := *msg
+	reply.Battle = battle.Summary()
+	mr.sendBattle(battle, &reply)
+	return nil
+}
+
+// このサーバに接続しているプレイヤーについて、先に並んだプレイヤーから順に組み合わせを提案する。
+// 相手にはレーティング差が許容幅に収まるプレイヤーのうち、最もレーティングが近いプレイヤーを選ぶ。(同じ差であれば先に並んだプレイヤー)
+// 出題する問題の組はルームで決まるため、希望する問題の組による絞り込みは行わない。
+// 組み合わせは他のイベントと同様に、MatchingEventBusから受け取った後に成立させる。
+// 発行に失敗した提案や、MATCH_PROPOSAL_TIMEOUTを過ぎても成立しない提案は取り消し、改めて組み合わせを探す。
+func (mr *MatchingRoom) proposeMatches() {
+	var proposals []*common.MatchingMessage
+	now := mr.now()
+	mr.mu.Lock()
+	for _, e := range mr.queue {
+		if e.proposed && now.Sub(e.proposedAt) >= MATCH_PROPOSAL_TIMEOUT {
+			e.proposed = false
+		}
+	}
+	for _, e := range mr.queue {
+		if e.proposed {
+			continue
+		}
+		if _, ok := mr.locals[e.id]; !ok {
+			continue
+		}
+		var best *queueEntry
+		for _, other := range mr.queue {
+			if other.id == e.id || other.proposed || !mr.acceptable(e, other, now) {
+				continue
+			}
+			if best == nil || ratingDiff(e, other) < ratingDiff(e, best) {
+				best = other
+			}
+		}
+		if best == nil {
+			continue
+		}
+		e.proposed, best.proposed = true, true
+		e.proposedAt, best.proposedAt = now, now
+		proposals = append(proposals, &common.MatchingMessage{
+			Source: mr.Players[e.id].GetProfile(),
+			Dest:   mr.Players[best.id].GetProfile(),
+			Data:   common.MATCHED,
+			Battle: &common.Battle{ID: newBattleID()},
+		})
+	}
+	mr.mu.Unlock()
+	for _, msg := range proposals {
+		if err := mr.publish(msg); err != nil {
+			mr.mu.Lock()
+			mr.resetProposals(msg.Source.ID, msg.Dest.ID)
+			mr.mu.Unlock()
+		}
+	}
+}
+
+// 二人のレーティング差が、どちらかの待ち時間に応じた許容幅に収まっているか。
+// 長く待っているプレイヤーの許容幅を優先し、後から並んだプレイヤーが待ち続けているプレイヤーの組み合わせを妨げないようにする。
+func (mr *MatchingRoom) acceptable(a, b *queueEntry, now time.Time) bool {
+	width := mr.window.Width(now.Sub(a.since))
+	if w := mr.window.Width(now.Sub(b.since)); w > width {
+		width = w
+	}
+	return ratingDiff(a, b) <= width
+}
+
+func ratingDiff(a, b *queueEntry) int {
+	d := a.rating - b.rating
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// 組み合わせが成立するまでの目安(秒)
+// いずれかの相手との許容幅がレーティング差に達するまでの最短の待ち時間とする。見積もれない場合は-1を返す。
+// mr.muをロックして呼び出す。
+func (mr *MatchingRoom) estimate(e *queueEntry, now time.Time) int {
+	best := time.Duration(-1)
+	for _, other := range mr.queue {
+		if other.id == e.id {
+			continue
+		}
+		wait, ok := mr.window.WaitFor(ratingDiff(e, other))
+		if !ok {
+			continue
+		}
+		// どちらかの許容幅が先に達した時点で組み合わせが成立する。
+		remain := wait - now.Sub(e.since)
+		if r := wait - now.Sub(other.since); r < remain {
+			remain = r
+		}
+		if remain < 0 {
+			remain = 0
+		}
+		if best < 0 || remain < best {
+			best = remain
+		}
+	}
+	if best < 0 {
+		return -1
+	}
+	return int(math.Ceil(best.Seconds()))
+}
+
+// このサーバに接続している待ち行列のプレイヤーに、順番と組み合わせが成立するまでの目安を通知する。
+func (mr *MatchingRoom) notifyQueue() {
+	now := mr.now()
+	var msgs []*common.MatchingMessage
+	mr.mu.RLock()
+	for i, e := range mr.queue {
+		if _, ok := mr.locals[e.id]; !ok {
+			continue
+		}
+		msgs = append(msgs, &common.MatchingMessage{
+			Source: mr.Players[e.id].GetProfile(),
+			Data:   common.QUEUE,
+			Queue: &common.QueueStatus{
+				Position: i + 1,
+				Waiting:  len(mr.queue),
+				Estimate: mr.estimate(e, now),
+			},
+		})
+	}
+	mr.mu.RUnlock()
+	for _, msg := range msgs {
+		mr.send(msg.Source.ID, msg)
+	}
+}
+
+// mr.muをロックして呼び出す。
+func (mr *MatchingRoom) resetProposals(ids ...string) {
+	for _, id := range ids {
+		if i := mr.indexInQueue(id); i >= 0 {
+			mr.queue[i].proposed = false
+		}
+	}
+}
+
+// mr.muをロックして呼び出す。
+func (mr *MatchingRoom) indexInQueue(id string) int {
+	for i, e := range mr.queue {
+		if e.id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// 待ち行列からidのプレイヤーを取り除く。並んでいなかった場合はfalseを返す。
+// mr.muをロックして呼び出す。
+func (mr *MatchingRoom) dequeue(id string) bool {
+	i := mr.indexInQueue(id)
+	if i < 0 {
+		return false
+	}
+	mr.queue = append(mr.queue[:i], mr.queue[i+1:]...)
+	return true
+}
@@ -0,0 +1,388 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"github.com/taise-hub/shellgame-cli/common"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMatchingRoomQuickMatch(t *testing.T) {
+	bus := newFakeBus()
+	mr := startTestRoom(t, "beginner", bus)
+	bob, bobConn := joinTestRoom(mr, "1", "bob")
+	alice, aliceConn := joinTestRoom(mr, "2", "alice")
+	carol, carolConn := joinTestRoom(mr, "3", "carol")
+	bobConn.expect(t, common.JOIN)
+	bobConn.expect(t, common.JOIN)
+	aliceConn.expect(t, common.JOIN)
+
+	send := func(src, dst *MatchingPlayer, data common.MatchingMessageData) {
+		msg := &common.MatchingMessage{Data: data}
+		if dst != nil {
+			msg.Dest = dst.GetProfile()
+		}
+		mr.message <- &playerMessage{src, msg}
+	}
+	expectStatus := func(t *testing.T, p *MatchingPlayer, expected MatchingStatus) {
+		t.Helper()
+		if actual := statusOf(mr, p.GetID()); actual != expected {
+			t.Errorf("%s Expected: %s\n\t\t Actual: %s \n", p.GetName(), expected, actual)
+		}
+	}
+
+	t.Run("待ち行列に参加するとQUEUEDになる。", func(t *testing.T) {
+		send(bob, nil, common.QUEUE)
+		bobConn.expect(t, common.QUEUE)
+		expectStatus(t, bob, QUEUED)
+	})
+	t.Run("待ち行列に並んでいるプレイヤーへの対戦申請は拒否される。", func(t *testing.T) {
+		send(carol, bob, common.OFFER)
+		carolConn.expect(t, common.ERROR)
+		expectStatus(t, carol, WAITING)
+	})
+	t.Run("待ち行列から離脱するとWAITINGに戻る。", func(t *testing.T) {
+		send(bob, nil, common.LEAVE_QUEUE)
+		bobConn.expect(t, common.LEAVE_QUEUE)
+		expectStatus(t, bob, WAITING)
+	})
+	t.Run("並んでいないプレイヤーは待ち行列から離脱できない。", func(t *testing.T) {
+		send(bob, nil, common.LEAVE_QUEUE)
+		bobConn.expect(t, common.ERROR)
+	})
+	t.Run("二人が待ち行列に並ぶと組み合わせが成立し、両者がIN_BATTLEになる。", func(t *testing.T) {
+		send(bob, nil, common.QUEUE)
+		send(alice, nil, common.QUEUE)
+		bobMsg := bobConn.expect(t, common.MATCHED)
+		aliceConn.expect(t, common.MATCHED)
+		if ids := []string{bobMsg.Source.ID, bobMsg.Dest.ID}; !(ids[0] == bob.GetID() && ids[1] == alice.GetID() || ids[0] == alice.GetID() && ids[1] == bob.GetID()) {
+			t.Errorf("Expected: bob and alice\n\t\t Actual: %v \n", ids)
+		}
+		expectStatus(t, bob, IN_BATTLE)
+		expectStatus(t, alice, IN_BATTLE)
+	})
+	t.Run("待ち行列に並んだまま退室したプレイヤーとは組み合わせが成立しない。", func(t *testing.T) {
+		send(carol, nil, common.QUEUE)
+		carolConn.expect(t, common.QUEUE)
+		mr.unregister <- carol
+		dave, daveConn := joinTestRoom(mr, "4", "dave")
+		send(dave, nil, common.QUEUE)
+		daveConn.expect(t, common.QUEUE)
+		select {
+		case msg := <-daveConn.written:
+			if msg.Data == common.MATCHED {
+				t.Errorf("unexpected message: %#v", msg)
+			}
+		case <-time.After(100 * time.Millisecond):
+		}
+		expectStatus(t, dave, QUEUED)
+	})
+}
+
+func TestMatchingRoomQuickMatchAcrossServers(t *testing.T) {
+	bus := newFakeBus()
+	roomA := startTestRoom(t, "beginner", bus)
+	roomB := startTestRoom(t, "beginner", bus)
+	bob, bobConn := joinTestRoom(roomA, "1", "bob")
+	alice, aliceConn := joinTestRoom(roomB, "2", "alice")
+	carol, carolConn := joinTestRoom(roomA, "3", "carol")
+	bobConn.expect(t, common.JOIN)
+	bobConn.expect(t, common.JOIN)
+	aliceConn.expect(t, common.JOIN)
+
+	t.Run("複数のサーバから同時に提案されても、一人のプレイヤーが二重に組み合わされない。", func(t *testing.T) {
+		roomA.message <- &playerMessage{bob, &common.MatchingMessage{Data: common.QUEUE}}
+		roomB.message <- &playerMessage{alice, &common.MatchingMessage{Data: common.QUEUE}}
+		roomA.message <- &playerMessage{carol, &common.MatchingMessage{Data: common.QUEUE}}
+
+		matched := map[string]int{}
+		deadline := time.After(300 * time.Millisecond)
+		conns := map[string]*fakeConn{bob.GetID(): bobConn, alice.GetID(): aliceConn, carol.GetID(): carolConn}
+	loop:
+		for {
+			for id, conn := range conns {
+				select {
+				case msg := <-conn.written:
+					if msg.Data == common.MATCHED {
+						matched[id]++
+					}
+				default:
+				}
+			}
+			select {
+			case <-deadline:
+				break loop
+			case <-time.After(time.Millisecond):
+			}
+		}
+		if len(matched) != 2 {
+			t.Fatalf("Expected: 2 players matched\n\t\t Actual: %v \n", matched)
+		}
+		for id, n := range matched {
+			if n != 1 {
+				t.Errorf("%s Expected: 1\n\t\t Actual: %d \n", id, n)
+			}
+		}
+		// 組み合わされなかったプレイヤーは両方のサーバでQUEUEDのまま残る。
+		for id := range conns {
+			if matched[id] > 0 {
+				continue
+			}
+			if s := statusOf(roomA, id); s != QUEUED {
+				t.Errorf("roomA %s Expected: %s\n\t\t Actual: %s \n", id, QUEUED, s)
+			}
+			if s := statusOf(roomB, id); s != QUEUED {
+				t.Errorf("roomB %s Expected: %s\n\t\t Actual: %s \n", id, QUEUED, s)
+			}
+		}
+	})
+}
+
+// fakeClockはテスト用の時計
+// ランダム対戦の待ち時間を実際に待たずに進める。
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func joinRatedTestRoom(mr *MatchingRoom, id string, name string, rating int) (*MatchingPlayer, *fakeConn) {
+	conn := newFakeConn()
+	p := NewMatchingPlayer(id, name, conn)
+	p.Profile.Rating = rating
+	mr.register <- p
+	go p.WritePump(context.Background())
+	return p, conn
+}
+
+// 組み合わせが成立しないことを確認するために、sweepが何度か実行されるまで待つ。
+func expectNoMatch(t *testing.T, conn *fakeConn) {
+	t.Helper()
+	timeout := time.After(100 * time.Millisecond)
+	for {
+		select {
+		case msg := <-conn.written:
+			if msg.Data == common.MATCHED {
+				t.Fatalf("unexpected match: %s and %s", msg.Source.Name, msg.Dest.Name)
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
+
+func TestMatchingRoomRatingWindow(t *testing.T) {
+	bus := newFakeBus()
+	clock := &fakeClock{now: time.Now()}
+	mr := NewMatchingRoom("beginner", bus)
+	mr.SetRatingWindow(LinearWindow{Initial: 50, PerSecond: 10, Max: 1000})
+	mr.now = clock.Now
+	mr.sweep = 10 * time.Millisecond
+	runTestRoom(t, mr, bus)
+
+	queue := func(p *MatchingPlayer) {
+		mr.message <- &playerMessage{p, &common.MatchingMessage{Data: common.QUEUE}}
+	}
+
+	bob, bobConn := joinRatedTestRoom(mr, "1", "bob", 1500)
+	alice, aliceConn := joinRatedTestRoom(mr, "2", "alice", 1800)
+	carol, carolConn := joinRatedTestRoom(mr, "3", "carol", 1500)
+	dave, daveConn := joinRatedTestRoom(mr, "4", "dave", 1580)
+	erin, _ := joinRatedTestRoom(mr, "5", "erin", 1510)
+	frank, frankConn := joinRatedTestRoom(mr, "6", "frank", 1680)
+
+	t.Run("レーティング差が許容幅を超える場合は組み合わせが成立せず、順番と目安が通知される。", func(t *testing.T) {
+		queue(bob)
+		bobConn.expect(t, common.QUEUE)
+		queue(alice)
+		msg := aliceConn.expect(t, common.QUEUE)
+		expected := common.QueueStatus{Position: 2, Waiting: 2, Estimate: 25}
+		if msg.Queue == nil || *msg.Queue != expected {
+			t.Errorf("Expected: %+v\n\t\t Actual: %+v \n", expected, msg.Queue)
+		}
+		expectNoMatch(t, bobConn)
+	})
+	t.Run("待ち時間に応じて許容幅が広がると組み合わせが成立する。", func(t *testing.T) {
+		clock.Advance(24 * time.Second)
+		expectNoMatch(t, bobConn)
+		clock.Advance(time.Second)
+		bobConn.expect(t, common.MATCHED)
+		aliceConn.expect(t, common.MATCHED)
+	})
+	t.Run("許容幅に収まる相手のうち、最もレーティングが近い相手と組み合わされる。", func(t *testing.T) {
+		queue(dave)
+		daveConn.expect(t, common.QUEUE)
+		queue(erin)
+		queue(carol)
+		msg := carolConn.expect(t, common.MATCHED)
+		if ids := map[string]bool{msg.Source.ID: true, msg.Dest.ID: true}; !ids[carol.GetID()] || !ids[erin.GetID()] {
+			t.Errorf("Expected: carol and erin\n\t\t Actual: %s and %s \n", msg.Source.Name, msg.Dest.Name)
+		}
+		if s := statusOf(mr, dave.GetID()); s != QUEUED {
+			t.Errorf("Expected: %s\n\t\t Actual: %s \n", QUEUED, s)
+		}
+	})
+	t.Run("長く待っているプレイヤーの許容幅で、後から並んだプレイヤーと組み合わされる。", func(t *testing.T) {
+		clock.Advance(10 * time.Second)
+		queue(frank)
+		msg := frankConn.expect(t, common.MATCHED)
+		if ids := map[string]bool{msg.Source.ID: true, msg.Dest.ID: true}; !ids[dave.GetID()] || !ids[frank.GetID()] {
+			t.Errorf("Expected: dave and frank\n\t\t Actual: %s and %s \n", msg.Source.Name, msg.Dest.Name)
+		}
+		daveConn.expect(t, common.MATCHED)
+	})
+}
+
+// lossyBusはテスト用のMatchingEventBusの実装
+// 組み合わせの提案(MATCHED)の発行に失敗したり、発行した提案を失ったりする。
+type lossyBus struct {
+	*fakeBus
+	mu   sync.Mutex
+	fail bool // 発行に失敗する。
+	drop bool // 発行に成功したことにして提案を捨てる。
+}
+
+func (b *lossyBus) Publish(room string, msg *common.MatchingMessage) error {
+	b.mu.Lock()
+	fail, drop := b.fail, b.drop
+	b.mu.Unlock()
+	if msg.Data == common.MATCHED && fail {
+		return errors.New("publish failed")
+	} else if msg.Data == common.MATCHED && drop {
+		return nil
+	}
+	return b.fakeBus.Publish(room, msg)
+}
+
+func (b *lossyBus) set(fail, drop bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fail, b.drop = fail, drop
+}
+
+func TestMatchingRoomLostProposal(t *testing.T) {
+	bus := &lossyBus{fakeBus: newFakeBus()}
+	clock := &fakeClock{now: time.Now()}
+	mr := NewMatchingRoom("beginner", bus)
+	mr.now = clock.Now
+	mr.sweep = 10 * time.Millisecond
+	runTestRoom(t, mr, bus.fakeBus)
+
+	queue := func(p *MatchingPlayer, conn *fakeConn) {
+		mr.message <- &playerMessage{p, &common.MatchingMessage{Data: common.QUEUE}}
+		conn.expect(t, common.QUEUE)
+	}
+
+	t.Run("提案の発行に失敗した場合は提案し直す。", func(t *testing.T) {
+		bob, bobConn := joinRatedTestRoom(mr, "1", "bob", 1500)
+		alice, aliceConn := joinRatedTestRoom(mr, "2", "alice", 1500)
+		bus.set(true, false)
+		queue(bob, bobConn)
+		queue(alice, aliceConn)
+		expectNoMatch(t, bobConn)
+		bus.set(false, false)
+		bobConn.expect(t, common.MATCHED)
+		aliceConn.expect(t, common.MATCHED)
+	})
+	t.Run("提案が成立しないまま時間が経った場合は提案し直す。", func(t *testing.T) {
+		carol, carolConn := joinRatedTestRoom(mr, "3", "carol", 1500)
+		dave, daveConn := joinRatedTestRoom(mr, "4", "dave", 1500)
+		bus.set(false, true)
+		queue(carol, carolConn)
+		queue(dave, daveConn)
+		expectNoMatch(t, carolConn)
+		bus.set(false, false)
+		expectNoMatch(t, carolConn)
+		clock.Advance(MATCH_PROPOSAL_TIMEOUT)
+		carolConn.expect(t, common.MATCHED)
+		daveConn.expect(t, common.MATCHED)
+	})
+}
+
+func TestRatingWindow(t *testing.T) {
+	tests := map[string]struct {
+		window       RatingWindow
+		waited       time.Duration
+		width        int
+		expectedWait time.Duration
+		expectedOK   bool
+	}{
+		"直線: 待ち始めは初期幅": {
+			window: LinearWindow{Initial: 100, PerSecond: 10, Max: 500}, waited: 0, width: 100,
+			expectedWait: 0, expectedOK: true,
+		},
+		"直線: 1秒ごとに広がる": {
+			window: LinearWindow{Initial: 100, PerSecond: 10, Max: 500}, waited: 15 * time.Second, width: 250,
+			expectedWait: 15 * time.Second, expectedOK: true,
+		},
+		"直線: 最大幅を超えて広がらない": {
+			window: LinearWindow{Initial: 100, PerSecond: 10, Max: 500}, waited: time.Hour, width: 500,
+			expectedWait: 40 * time.Second, expectedOK: true,
+		},
+		"段階: 間隔ごとに広がる": {
+			window: StepWindow{Initial: 100, Step: 50, Interval: 10 * time.Second, Max: 500}, waited: 25 * time.Second, width: 200,
+			expectedWait: 20 * time.Second, expectedOK: true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if actual := tt.window.Width(tt.waited); actual != tt.width {
+				t.Errorf("Expected: %d\n\t\t Actual: %d \n", tt.width, actual)
+			}
+			wait, ok := tt.window.WaitFor(tt.width)
+			if wait != tt.expectedWait || ok != tt.expectedOK {
+				t.Errorf("Expected: %v, %t\n\t\t Actual: %v, %t \n", tt.expectedWait, tt.expectedOK, wait, ok)
+			}
+		})
+	}
+	t.Run("最大幅を超える差には達しない。", func(t *testing.T) {
+		if _, ok := (LinearWindow{Initial: 100, PerSecond: 10, Max: 500}).WaitFor(501); ok {
+			t.Errorf("Expected: false\n\t\t Actual: true \n")
+		}
+	})
+}
+
+func TestParseRatingWindow(t *testing.T) {
+	tests := map[string]struct {
+		spec     string
+		expected RatingWindow
+	}{
+		"直線の曲線を指定できる。": {
+			spec:     "linear:100:10:1000",
+			expected: LinearWindow{Initial: 100, PerSecond: 10, Max: 1000},
+		},
+		"段階的な曲線を指定できる。": {
+			spec:     "step:100:50:10s:1000",
+			expected: StepWindow{Initial: 100, Step: 50, Interval: 10 * time.Second, Max: 1000},
+		},
+		"不明な曲線は指定できない。": {
+			spec:     "exp:100:2:1000",
+			expected: nil,
+		},
+		"負の値は指定できない。": {
+			spec:     "linear:-100:10:1000",
+			expected: nil,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			actual, err := ParseRatingWindow(tt.spec)
+			if actual != tt.expected || (err == nil) != (tt.expected != nil) {
+				t.Errorf("Expected: %v\n\t\t Actual: %v, %v \n", tt.expected, actual, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,330 @@
+package model
+
+import (
+	"github.com/taise-hub/shellgame-cli/common"
+	"log"
+	"time"
+)
+
+const (
+	MAX_PARTY_SIZE        = 4 // リーダーを含めたパーティの最大人数
+	MIN_FREE_FOR_ALL_SIZE = 2 // 個人戦を開始できる最少人数
+)
+
+// リーダーが招待したプレイヤーの集まり
+// リーダーはWAITINGのまま対戦申請を送受信でき、リーダー以外のメンバーはIN_PARTYになる。
+type party struct {
+	leader  string
+	members []string        // 先頭はリーダー
+	invited map[string]bool // 招待済みでまだ参加していないプレイヤー
+}
+
+func (mr *MatchingRoom) organizeParty(msg *common.MatchingMessage) error {
+	if mr.Players[msg.Source.ID] == nil {
+		return newMatchingError(common.ERR_PLAYER_NOT_FOUND, "ルームに参加していません。")
+	}
+	switch msg.Data {
+	case common.PARTY_INVITE:
+		return mr.HandlePartyInvite(msg)
+	case common.PARTY_JOIN:
+		return mr.HandlePartyJoin(msg)
+	case common.PARTY_LEAVE:
+		if !mr.leaveParty(msg.Source) {
+			return newMatchingError(common.ERR_INVALID_MESSAGE, "パーティに参加していません。")
+		}
+		log.Printf("[+] PARTY LEAVE: %s\n", msg.Source.Name)
+		return nil
+	case common.START:
+		if msg.Battle != nil && len(msg.Battle.Teams) > 0 {
+			return mr.HandleHostStart(msg)
+		}
+		return mr.HandleStart(msg)
+	}
+	return nil
+}
+
+// パーティへの招待処理
+// WAITINGのプレイヤー(Source)が、パーティに入っていないWAITINGのプレイヤー(Dest)を招待する。
+// 招待したプレイヤーがパーティに入っていない場合は、招待したプレイヤーをリーダーとするパーティを作る。
+func (mr *MatchingRoom) HandlePartyInvite(msg *common.MatchingMessage) error {
+	if msg.Dest == nil || mr.Players[msg.Dest.ID] == nil {
+		return newMatchingError(common.ERR_PLAYER_NOT_FOUND, "相手のプレイヤーが見つかりません。ルームから退出した可能性があります。")
+	} else if msg.Source.ID == msg.Dest.ID {
+		return newMatchingError(common.ERR_INVALID_MESSAGE, "自分を招待することはできません。")
+	}
+	mr.mu.Lock()
+	pt, err := mr.invite(msg.Source.ID, msg.Dest.ID)
+	mr.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	log.Printf("[+] PARTY INVITE: %s to %s\n", msg.Source.Name, msg.Dest.Name)
+	// イベントは他のサーバと共有している場合があるため、書き換えずに複製して送信する。
+	invitation := *msg
+	invitation.Party = mr.partySummary(pt)
+	mr.send(msg.Dest.ID, &invitation)
+	mr.notifyParty(pt)
+	return nil
+}
+
+// mr.muをロックして呼び出す。
+func (mr *MatchingRoom) invite(from, to string) (*party, error) {
+	if s := mr.Players[from].GetStatus(); s != WAITING {
+		return nil, busyError("あなた", s)
+	} else if s := mr.Players[to].GetStatus(); s != WAITING {
+		return nil, busyError("相手", s)
+	}
+	if _, ok := mr.parties[to]; ok {
+		return nil, newMatchingError(common.ERR_PLAYER_BUSY, "相手は既にパーティに参加しています。")
+	}
+	pt, ok := mr.parties[from]
+	if !ok {
+		pt = &party{leader: from, members: []string{from}, invited: make(map[string]bool)}
+		mr.parties[from] = pt
+	}
+	if len(pt.members) >= MAX_PARTY_SIZE {
+		return nil, newMatchingError(common.ERR_PARTY_FULL, "パーティは%d人までです。", MAX_PARTY_SIZE)
+	}
+	pt.invited[to] = true
+	return pt, nil
+}
+
+// パーティへの参加処理
+// 招待されたWAITINGのプレイヤー(Source)を、リーダー(Dest)のパーティに加えてIN_PARTYにする。
+// IN_PARTYのプレイヤーは対戦申請を送受信できないため、受け取っていた申請は全て断る。
+func (mr *MatchingRoom) HandlePartyJoin(msg *common.MatchingMessage) error {
+	if msg.Dest == nil {
+		return newMatchingError(common.ERR_INVALID_MESSAGE, "パーティのリーダーを指定してください。")
+	}
+	mr.mu.Lock()
+	pt, ok := mr.parties[msg.Dest.ID]
+	if !ok || pt.leader != msg.Dest.ID || !pt.invited[msg.Source.ID] {
+		mr.mu.Unlock()
+		return newMatchingError(common.ERR_NOT_PERMITTED, "このパーティには招待されていません。")
+	}
+	p := mr.Players[msg.Source.ID]
+	if s := p.GetStatus(); s != WAITING {
+		mr.mu.Unlock()
+		return busyError("あなた", s)
+	}
+	if _, ok := mr.parties[msg.Source.ID]; ok {
+		mr.mu.Unlock()
+		return newMatchingError(common.ERR_PLAYER_BUSY, "既にパーティに参加しています。")
+	}
+	if len(pt.members) >= MAX_PARTY_SIZE {
+		mr.mu.Unlock()
+		return newMatchingError(common.ERR_PARTY_FULL, "パーティは%d人までです。", MAX_PARTY_SIZE)
+	}
+	delete(pt.invited, msg.Source.ID)
+	pt.members = append(pt.members, msg.Source.ID)
+	mr.parties[msg.Source.ID] = pt
+	p.SetStatus(IN_PARTY)
+	mr.mu.Unlock()
+
+	log.Printf("[+] PARTY JOIN: %s to %s\n", msg.Source.Name, msg.Dest.Name)
+	mr.declineInbox(msg.Source)
+	mr.notifyParty(pt)
+	return nil
+}
+
+// profileのプレイヤーをパーティから外し、外れたことを本人に、残ったメンバーにはパーティの状態を通知する。
+// リーダーが外れた場合はパーティを解散する。パーティに入っていなかった場合はfalseを返す。
+func (mr *MatchingRoom) leaveParty(profile *common.Profile) bool {
+	mr.mu.Lock()
+	pt, ok := mr.parties[profile.ID]
+	if !ok {
+		mr.mu.Unlock()
+		return false
+	}
+	var left []string
+	if pt.leader == profile.ID {
+		left = pt.members
+		pt.members = nil
+	} else {
+		left = []string{profile.ID}
+		for i, id := range pt.members {
+			if id == profile.ID {
+				pt.members = append(pt.members[:i:i], pt.members[i+1:]...)
+				break
+			}
+		}
+	}
+	for _, id := range left {
+		delete(mr.parties, id)
+		if id != pt.leader {
+			mr.Players[id].SetStatus(WAITING)
+		}
+	}
+	remains := len(pt.members) > 0
+	mr.mu.Unlock()
+
+	for _, id := range left {
+		mr.send(id, &common.MatchingMessage{Source: profile, Data: common.PARTY})
+	}
+	if remains {
+		mr.notifyParty(pt)
+	}
+	return true
+}
+
+// 個人戦の開始処理
+// パーティのリーダー(Source)がWAITINGの場合、パーティの全員をIN_BATTLEにし、一人ずつのチームとして対戦を開始する。
+// チーム戦はリーダー同士の対戦申請で開始する。
+func (mr *MatchingRoom) HandleStart(msg *common.MatchingMessage) error {
+	if msg.Battle == nil || msg.Battle.Mode != common.FREE_FOR_ALL {
+		return newMatchingError(common.ERR_INVALID_BATTLE, "開始できるのは個人戦のみです。")
+	}
+	mr.mu.Lock()
+	pt, ok := mr.parties[msg.Source.ID]
+	if !ok || pt.leader != msg.Source.ID {
+		mr.mu.Unlock()
+		return newMatchingError(common.ERR_NOT_PERMITTED, "対戦を開始できるのはパーティのリーダーのみです。")
+	}
+	if s := mr.Players[msg.Source.ID].GetStatus(); s != WAITING {
+		mr.mu.Unlock()
+		return busyError("あなた", s)
+	}
+	if len(pt.members) < MIN_FREE_FOR_ALL_SIZE {
+		mr.mu.Unlock()
+		return newMatchingError(common.ERR_INVALID_BATTLE, "個人戦には%d人以上必要です。", MIN_FREE_FOR_ALL_SIZE)
+	}
+	var teams [][]*common.Profile
+	for _, id := range pt.members {
+		teams = append(teams, []*common.Profile{mr.Players[id].GetProfile()})
+	}
+	battle := mr.startBattle(msg.Battle.ID, common.FREE_FOR_ALL, teams, false)
+	mr.mu.Unlock()
+
+	log.Printf("[+] START: %s with %d players\n", msg.Source.Name, len(teams))
+	reply := *msg
+	reply.Battle = battle.Summary()
+	mr.sendBattle(battle, &reply)
+	mr.declineInbox(msg.Source)
+	return nil
+}
+
+// idのプレイヤーのチームとなるメンバーを返す。パーティに入っていない場合は本人だけのチームとする。
+// mr.muをロックして呼び出す。
+func (mr *MatchingRoom) teamOf(id string) []*common.Profile {
+	pt, ok := mr.parties[id]
+	if !ok {
+		return []*common.Profile{mr.Players[id].GetProfile()}
+	}
+	var team []*common.Profile
+	for _, member := range pt.members {
+		team = append(team, mr.Players[member].GetProfile())
+	}
+	return team
+}
+
+// teamsの全員をIN_BATTLEにして対戦を登録する。パーティは対戦の開始と共に解散する。
+// mr.muをロックして呼び出す。
+func (mr *MatchingRoom) startBattle(id string, mode common.BattleMode, teams [][]*common.Profile, shared bool) *Battle {
+	for _, team := range teams {
+		for _, p := range team {
+			mr.Players[p.ID].SetStatus(IN_BATTLE)
+			delete(mr.parties, p.ID)
+		}
+	}
+	battle := newBattle(id, mode, teams, shared, mr.now())
+	battle.QuestionSet = mr.questions
+	mr.battles[id] = battle
+	return battle
+}
+
+// 対戦の参加者全員に送信する。
+func (mr *MatchingRoom) sendBattle(battle *Battle, msg *common.MatchingMessage) {
+	for _, team := range battle.Teams {
+		for _, p := range team {
+			mr.send(p.ID, msg)
+		}
+	}
+}
+
+func (mr *MatchingRoom) GetBattle(id string) (*Battle, bool) {
+	mr.mu.RLock()
+	defer mr.mu.RUnlock()
+	battle, ok := mr.battles[id]
+	return battle, ok
+}
+
+// 開始からBATTLE_LIFETIMEを過ぎた対戦を破棄し、その対戦チャンネルに接続しているプレイヤーを切断する。
+// ロビーに残っている対戦の参加者は、全てのサーバでWAITINGに戻るようにSTATUSを発行する。
+// 対戦の参加者が利用したコンソールは、このサーバで利用したものを削除する。
+func (mr *MatchingRoom) sweepBattles(now time.Time) {
+	var returning []*MatchingPlayer
+	var consoles []string
+	mr.mu.Lock()
+	for id, battle := range mr.battles {
+		if now.Sub(battle.started) > BATTLE_LIFETIME {
+			delete(mr.battles, id)
+			returning = append(returning, mr.returningPlayers(battle)...)
+			consoles = append(consoles, battle.ConsoleNames()...)
+		}
+	}
+	for _, p := range mr.fighters {
+		if _, ok := mr.battles[p.GetBattleID()]; !ok {
+			p.conn.Close()
+		}
+	}
+	mr.mu.Unlock()
+	for _, p := range returning {
+		mr.publishStatus(p, common.WAITING)
+	}
+	if mr.consoles != nil && len(consoles) > 0 {
+		go mr.removeConsoles(consoles)
+	}
+}
+
+// コンテナの停止を待つ間もロビーの処理を続けられるように、Run()とは別のゴルーチンで呼び出す。
+func (mr *MatchingRoom) removeConsoles(names []string) {
+	for _, name := range names {
+		if err := mr.consoles.RemoveShell(name); err != nil {
+			log.Printf("Error in RemoveShell(): %s: %v\n", name, err)
+		}
+	}
+}
+
+// 破棄したbattleの参加者のうち、このサーバのロビーに接続していて他の対戦に参加していないIN_BATTLEのプレイヤー
+// mr.muをロックして呼び出す。
+func (mr *MatchingRoom) returningPlayers(battle *Battle) []*MatchingPlayer {
+	var players []*MatchingPlayer
+	for _, team := range battle.Teams {
+		for _, profile := range team {
+			p, ok := mr.locals[profile.ID]
+			if !ok || p.GetStatus() != IN_BATTLE || mr.inBattle(profile.ID) {
+				continue
+			}
+			players = append(players, p)
+		}
+	}
+	return players
+}
+
+// mr.muをロックして呼び出す。
+func (mr *MatchingRoom) inBattle(id string) bool {
+	for _, battle := range mr.battles {
+		if battle.TeamOf(id) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (mr *MatchingRoom) partySummary(pt *party) *common.Party {
+	mr.mu.RLock()
+	defer mr.mu.RUnlock()
+	summary := &common.Party{Leader: mr.Players[pt.leader].GetProfile()}
+	for _, id := range pt.members {
+		summary.Members = append(summary.Members, mr.Players[id].GetProfile())
+	}
+	return summary
+}
+
+// このサーバに接続しているパーティのメンバーに、パーティの状態を通知する。
+func (mr *MatchingRoom) notifyParty(pt *party) {
+	summary := mr.partySummary(pt)
+	for _, p := range summary.Members {
+		mr.send(p.ID, &common.MatchingMessage{Source: summary.Leader, Data: common.PARTY, Party: summary})
+	}
+}
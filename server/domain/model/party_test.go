@@ -0,0 +1,195 @@
+package model
+
+import (
+	"github.com/taise-hub/shellgame-cli/common"
+	"testing"
+)
+
+// パーティのメンバーがn人であること(パーティから外れた場合は0人)を通知するメッセージが届くまで待つ。
+func (c *fakeConn) expectParty(t *testing.T, n int) *common.MatchingMessage {
+	t.Helper()
+	for {
+		msg := c.expect(t, common.PARTY)
+		if msg.Party == nil && n == 0 || msg.Party != nil && len(msg.Party.Members) == n {
+			return msg
+		}
+	}
+}
+
+func TestMatchingRoomParty(t *testing.T) {
+	bus := newFakeBus()
+	mr := startTestRoom(t, "beginner", bus)
+	bob, bobConn := joinTestRoom(mr, "1", "bob")
+	alice, aliceConn := joinTestRoom(mr, "2", "alice")
+	carol, carolConn := joinTestRoom(mr, "3", "carol")
+	dave, daveConn := joinTestRoom(mr, "4", "dave")
+	for i := 0; i < 3; i++ {
+		bobConn.expect(t, common.JOIN)
+	}
+
+	send := func(src, dst *MatchingPlayer, data common.MatchingMessageData, battle *common.Battle) {
+		msg := &common.MatchingMessage{Data: data, Battle: battle}
+		if dst != nil {
+			msg.Dest = dst.GetProfile()
+		}
+		mr.message <- &playerMessage{src, msg}
+	}
+	expectStatus := func(t *testing.T, p *MatchingPlayer, expected MatchingStatus) {
+		t.Helper()
+		if actual := statusOf(mr, p.GetID()); actual != expected {
+			t.Errorf("%s Expected: %s\n\t\t Actual: %s \n", p.GetName(), expected, actual)
+		}
+	}
+
+	t.Run("招待されたプレイヤーはパーティに参加でき、IN_PARTYになる。", func(t *testing.T) {
+		send(bob, alice, common.PARTY_INVITE, nil)
+		msg := aliceConn.expect(t, common.PARTY_INVITE)
+		if msg.Party == nil || msg.Party.Leader.ID != bob.GetID() {
+			t.Fatalf("Expected: %s\n\t\t Actual: %v \n", bob.GetID(), msg.Party)
+		}
+		send(alice, bob, common.PARTY_JOIN, nil)
+		bobConn.expectParty(t, 2)
+		aliceConn.expectParty(t, 2)
+		expectStatus(t, bob, WAITING)
+		expectStatus(t, alice, IN_PARTY)
+	})
+	t.Run("招待されていないパーティには参加できない。", func(t *testing.T) {
+		send(dave, bob, common.PARTY_JOIN, nil)
+		daveConn.expect(t, common.ERROR)
+		expectStatus(t, dave, WAITING)
+	})
+	t.Run("パーティのメンバーは対戦申請を送信できない。", func(t *testing.T) {
+		send(alice, carol, common.OFFER, nil)
+		aliceConn.expect(t, common.ERROR)
+	})
+	t.Run("パーティのリーダーは待ち行列に参加できない。", func(t *testing.T) {
+		send(bob, nil, common.QUEUE, nil)
+		bobConn.expect(t, common.ERROR)
+		expectStatus(t, bob, WAITING)
+	})
+	t.Run("人数の異なるパーティへの対戦申請は拒否される。", func(t *testing.T) {
+		send(carol, bob, common.OFFER, nil)
+		carolConn.expect(t, common.ERROR)
+		expectStatus(t, carol, WAITING)
+	})
+	t.Run("リーダー同士の対戦申請を承諾すると、両方のパーティの全員でチーム戦が開始される。", func(t *testing.T) {
+		send(carol, dave, common.PARTY_INVITE, nil)
+		daveConn.expect(t, common.PARTY_INVITE)
+		send(dave, carol, common.PARTY_JOIN, nil)
+		carolConn.expectParty(t, 2)
+		send(bob, carol, common.OFFER, &common.Battle{SharedShell: true})
+		carolConn.expect(t, common.OFFER)
+		send(carol, bob, common.ACCEPT, nil)
+
+		var id string
+		for _, conn := range []*fakeConn{bobConn, aliceConn, carolConn, daveConn} {
+			msg := conn.expect(t, common.ACCEPT)
+			b := msg.Battle
+			if b == nil || b.ID == "" || b.Mode != common.TEAM || !b.SharedShell || len(b.Teams) != 2 || len(b.Teams[0].Members) != 2 || len(b.Teams[1].Members) != 2 {
+				t.Fatalf("Expected: 2v2 team battle\n\t\t Actual: %+v \n", b)
+			}
+			id = b.ID
+		}
+		for _, p := range []*MatchingPlayer{bob, alice, carol, dave} {
+			expectStatus(t, p, IN_BATTLE)
+		}
+		battle, ok := mr.GetBattle(id)
+		if !ok {
+			t.Fatalf("battle %s is not registered", id)
+		}
+		if actual := battle.TeamOf(alice.GetID()); actual != battle.TeamOf(bob.GetID()) {
+			t.Errorf("Expected: %d\n\t\t Actual: %d \n", battle.TeamOf(bob.GetID()), actual)
+		}
+	})
+}
+
+func TestMatchingRoomFreeForAll(t *testing.T) {
+	bus := newFakeBus()
+	mr := startTestRoom(t, "beginner", bus)
+	bob, bobConn := joinTestRoom(mr, "1", "bob")
+	alice, aliceConn := joinTestRoom(mr, "2", "alice")
+	carol, carolConn := joinTestRoom(mr, "3", "carol")
+	for i := 0; i < 2; i++ {
+		bobConn.expect(t, common.JOIN)
+	}
+
+	send := func(src, dst *MatchingPlayer, data common.MatchingMessageData, battle *common.Battle) {
+		msg := &common.MatchingMessage{Data: data, Battle: battle}
+		if dst != nil {
+			msg.Dest = dst.GetProfile()
+		}
+		mr.message <- &playerMessage{src, msg}
+	}
+	ffa := &common.Battle{Mode: common.FREE_FOR_ALL}
+
+	t.Run("一人のパーティでは個人戦を開始できない。", func(t *testing.T) {
+		send(bob, alice, common.PARTY_INVITE, nil)
+		aliceConn.expect(t, common.PARTY_INVITE)
+		send(bob, nil, common.START, ffa)
+		bobConn.expect(t, common.ERROR)
+	})
+	t.Run("リーダー以外は個人戦を開始できない。", func(t *testing.T) {
+		send(alice, bob, common.PARTY_JOIN, nil)
+		aliceConn.expectParty(t, 2)
+		send(alice, nil, common.START, ffa)
+		aliceConn.expect(t, common.ERROR)
+	})
+	t.Run("リーダーが開始すると、パーティの全員が一人ずつのチームで対戦する。", func(t *testing.T) {
+		send(bob, carol, common.PARTY_INVITE, nil)
+		carolConn.expect(t, common.PARTY_INVITE)
+		send(carol, bob, common.PARTY_JOIN, nil)
+		bobConn.expectParty(t, 3)
+		send(bob, nil, common.START, ffa)
+		for _, conn := range []*fakeConn{bobConn, aliceConn, carolConn} {
+			msg := conn.expect(t, common.START)
+			if b := msg.Battle; b == nil || b.Mode != common.FREE_FOR_ALL || len(b.Teams) != 3 {
+				t.Fatalf("Expected: free-for-all with 3 players\n\t\t Actual: %+v \n", b)
+			}
+		}
+		for _, p := range []*MatchingPlayer{bob, alice, carol} {
+			if actual := statusOf(mr, p.GetID()); actual != IN_BATTLE {
+				t.Errorf("%s Expected: %s\n\t\t Actual: %s \n", p.GetName(), IN_BATTLE, actual)
+			}
+		}
+	})
+}
+
+func TestMatchingRoomPartyLeave(t *testing.T) {
+	bus := newFakeBus()
+	mr := startTestRoom(t, "beginner", bus)
+	bob, bobConn := joinTestRoom(mr, "1", "bob")
+	alice, aliceConn := joinTestRoom(mr, "2", "alice")
+	carol, carolConn := joinTestRoom(mr, "3", "carol")
+	for i := 0; i < 2; i++ {
+		bobConn.expect(t, common.JOIN)
+	}
+
+	send := func(src, dst *MatchingPlayer, data common.MatchingMessageData) {
+		msg := &common.MatchingMessage{Data: data}
+		if dst != nil {
+			msg.Dest = dst.GetProfile()
+		}
+		mr.message <- &playerMessage{src, msg}
+	}
+	for _, p := range []*MatchingPlayer{alice, carol} {
+		send(bob, p, common.PARTY_INVITE)
+		send(p, bob, common.PARTY_JOIN)
+	}
+	bobConn.expectParty(t, 3)
+
+	t.Run("メンバーが離脱するとWAITINGに戻り、残ったメンバーに通知される。", func(t *testing.T) {
+		send(alice, nil, common.PARTY_LEAVE)
+		aliceConn.expectParty(t, 0)
+		bobConn.expectParty(t, 2)
+		if actual := statusOf(mr, alice.GetID()); actual != WAITING {
+			t.Errorf("Expected: %s\n\t\t Actual: %s \n", WAITING, actual)
+		}
+	})
+	t.Run("リーダーが退室するとパーティは解散する。", func(t *testing.T) {
+		mr.unregister <- bob
+		carolConn.expectParty(t, 0)
+		if actual := statusOf(mr, carol.GetID()); actual != WAITING {
+			t.Errorf("Expected: %s\n\t\t Actual: %s \n", WAITING, actual)
+		}
+	})
+}
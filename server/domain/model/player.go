@@ -2,7 +2,16 @@ package model
 
 import (
 	"context"
+	"errors"
+	"github.com/google/uuid"
 	"github.com/taise-hub/shellgame-cli/common"
+	"log"
+	"time"
+)
+
+const (
+	OUTBOX_SIZE           = 64                     // プレイヤーごとに溜めておける送信待ちのメッセージ数
+	SLOW_CONSUMER_TIMEOUT = 100 * time.Millisecond // outboxが一杯のプレイヤーに空きができるまで待つ時間。過ぎた場合はルームから退出させる。
 )
 
 type MatchingStatus uint8
@@ -10,22 +19,78 @@ type MatchingStatus uint8
 const (
 	WAITING     MatchingStatus = iota // マッチング待ち状態
 	NEGOTIATING                       // マッチング状態(対戦申請を受信または送信中)
+	IN_BATTLE                         // 対戦中
+	QUEUED                            // ランダム対戦の相手を待っている状態
+	IN_PARTY                          // パーティのメンバーとしてリーダーの対戦開始を待っている状態
+	AWAY                              // しばらく操作していない状態
 )
 
+// クライアントに通知する状態
+var commonStatus = map[MatchingStatus]common.MatchingStatus{
+	WAITING:     common.WAITING,
+	NEGOTIATING: common.NEGOTIATING,
+	IN_BATTLE:   common.IN_BATTLE,
+	QUEUED:      common.QUEUED,
+	IN_PARTY:    common.IN_PARTY,
+	AWAY:        common.AWAY,
+}
+
+// 接続し直したプレイヤーの古い接続と新しい接続を区別するために、ロビーへの接続ごとに発行する。
+func newConnID() string {
+	return uuid.NewString()
+}
+
+// 他のサーバから通知された状態。知らない状態はWAITINGとする。
+func matchingStatus(s common.MatchingStatus) MatchingStatus {
+	for status, c := range commonStatus {
+		if c == s {
+			return status
+		}
+	}
+	return WAITING
+}
+
+func (s MatchingStatus) String() string {
+	switch s {
+	case WAITING:
+		return "WAITING"
+	case NEGOTIATING:
+		return "NEGOTIATING"
+	case IN_BATTLE:
+		return "IN_BATTLE"
+	case QUEUED:
+		return "QUEUED"
+	case IN_PARTY:
+		return "IN_PARTY"
+	case AWAY:
+		return "AWAY"
+	default:
+		return "UNKNOWN"
+	}
+}
+
 type MatchingPlayer struct {
-	Profile      *common.Profile `json:"profile"`
-	Status       MatchingStatus  `json:"status"`
-	conn         Conn
-	matchingChan chan *common.MatchingMessage
+	Profile *common.Profile `json:"profile"`
+	Status  MatchingStatus  `json:"status"`
+	Joined  time.Time       `json:"joined"` // ロビーに参加した時刻。全てのサーバで同じ値になるように、JOINの発行時に決める。
+	conn    Conn
+	outbox  chan *common.MatchingMessage // MatchingRoomから送信されたメッセージをWritePumpに渡す。
+	chats   []time.Time                  // 直近に送信したチャットの時刻。MatchingRoom.Run()でのみ扱う。
+	battle  string                       // 対戦チャンネルとして接続している場合の対戦のID
+	active  time.Time                    // ロビーで最後に操作した時刻。MatchingRoom.Run()でのみ扱う。
+	warned  bool                         // 切断を予告済みかどうか。MatchingRoom.Run()でのみ扱う。
+	away    bool                         // AWAYへの変化を発行済みかどうか。MatchingRoom.Run()でのみ扱う。
+	connID  string                       // ロビーへの接続の識別子。全てのサーバで同じ値になる。MatchingRoom.Run()でのみ扱う。
+	pending bool                         // このサーバが発行したJOIN, RESUMEがまだ届いていないかどうか。MatchingRoom.Run()でのみ扱う。
 }
 
 func NewMatchingPlayer(id string, name string, conn Conn) *MatchingPlayer {
 	profile := &common.Profile{ID: id, Name: name}
 	return &MatchingPlayer{
-		Profile:      profile,
-		Status:       WAITING,
-		conn:         conn,
-		matchingChan: make(chan *common.MatchingMessage),
+		Profile: profile,
+		Status:  WAITING,
+		conn:    conn,
+		outbox:  make(chan *common.MatchingMessage, OUTBOX_SIZE),
 	}
 }
 
@@ -41,6 +106,20 @@ func (p *MatchingPlayer) SetStatus(s MatchingStatus) {
 	p.Status = s
 }
 
+// ルームに登録する前に設定する。設定したプレイヤーはロビーではなく対戦チャンネルに接続する。
+func (p *MatchingPlayer) SetBattleID(id string) {
+	p.battle = id
+}
+
+func (p *MatchingPlayer) GetBattleID() string {
+	return p.battle
+}
+
+// ロビーの一覧や状態の通知に含める情報。MatchingRoomのプレイヤーであればmr.muをロックして呼び出す。
+func (p *MatchingPlayer) Summary() *common.MatchingPlayer {
+	return &common.MatchingPlayer{Profile: p.GetProfile(), Status: commonStatus[p.Status], Joined: p.Joined}
+}
+
 func (p *MatchingPlayer) GetID() string {
 	return p.GetProfile().ID
 }
@@ -49,20 +128,28 @@ func (p *MatchingPlayer) GetName() string {
 	return p.GetProfile().Name
 }
 
-func (p *MatchingPlayer) ReadPump() {
+// 受信したメッセージを送信者であるプレイヤーと共にMatchingRoomに渡す。
+func (p *MatchingPlayer) ReadPump(mr *MatchingRoom) {
 	defer func() {
 		p.conn.Close()
-		GetMatchingRoom().unregister <- p
+		select {
+		case mr.unregister <- p:
+		case <-mr.done:
+		}
 	}()
-	msg := &common.MatchingMessage{}
 	for {
+		msg := &common.MatchingMessage{}
 		if err := p.conn.Read(msg); err != nil {
+			if errors.Is(err, ErrHeartbeatTimeout) {
+				log.Printf("[+] %s stopped responding and was evicted.\n", p.GetName())
+			}
 			return
 		}
-		if msg.Dest == nil || msg.Source == nil {
+		select {
+		case mr.message <- &playerMessage{player: p, msg: msg}:
+		case <-mr.done:
 			return
 		}
-		GetMatchingRoom().message <- msg
 	}
 }
 
@@ -72,7 +159,7 @@ func (p *MatchingPlayer) WritePump(ctx context.Context) {
 		select {
 		case <-ctx.Done():
 			return
-		case msg, ok := <-p.matchingChan:
+		case msg, ok := <-p.outbox:
 			if !ok {
 				return
 			}
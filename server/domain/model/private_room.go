@@ -0,0 +1,89 @@
+package model
+
+import (
+	"github.com/taise-hub/shellgame-cli/common"
+	"log"
+)
+
+// ホストによる対戦の開始処理
+// プライベートルームのホスト(Source)が指定した組み合わせで対戦を開始する。ホスト自身が参加する必要はない。
+// 指定されたプレイヤーは全員、パーティに入っていないWAITINGのプレイヤーでなければならない。
+func (mr *MatchingRoom) HandleHostStart(msg *common.MatchingMessage) error {
+	if !mr.IsPrivate() || msg.Source.ID != mr.host {
+		return newMatchingError(common.ERR_NOT_PERMITTED, "プレイヤーを割り当てられるのはプライベートルームのホストのみです。")
+	}
+	mr.mu.Lock()
+	teams, err := mr.assignedTeams(msg.Battle)
+	if err != nil {
+		mr.mu.Unlock()
+		return err
+	}
+	battle := mr.startBattle(msg.Battle.ID, msg.Battle.Mode, teams, msg.Battle.SharedShell)
+	mr.mu.Unlock()
+
+	log.Printf("[+] START: %s assigned %d teams\n", msg.Source.Name, len(teams))
+	reply := *msg
+	reply.Battle = battle.Summary()
+	mr.sendBattle(battle, &reply)
+	// 参加しないホストにも開始したことを知らせる。
+	if battle.TeamOf(msg.Source.ID) < 0 {
+		mr.send(msg.Source.ID, &reply)
+	}
+	for _, team := range teams {
+		for _, p := range team {
+			mr.declineInbox(p)
+		}
+	}
+	return nil
+}
+
+// ホストが指定した参加者をルームのプレイヤーに置き換え、対戦の形式に合っているか確認する。
+// mr.muをロックして呼び出す。
+func (mr *MatchingRoom) assignedTeams(b *common.Battle) ([][]*common.Profile, error) {
+	assigned := make(map[string]bool)
+	var teams [][]*common.Profile
+	for _, t := range b.Teams {
+		var team []*common.Profile
+		for _, member := range t.Members {
+			p, ok := mr.Players[member.ID]
+			if !ok {
+				return nil, newMatchingError(common.ERR_PLAYER_NOT_FOUND, "割り当てたプレイヤーが見つかりません。ルームから退出した可能性があります。")
+			} else if assigned[member.ID] {
+				return nil, newMatchingError(common.ERR_INVALID_BATTLE, "%sを二度割り当てることはできません。", p.GetName())
+			} else if s := p.GetStatus(); s != WAITING {
+				return nil, busyError(p.GetName(), s)
+			} else if _, ok := mr.parties[member.ID]; ok {
+				return nil, newMatchingError(common.ERR_PLAYER_BUSY, "%sはパーティに参加しているため割り当てられません。", p.GetName())
+			}
+			assigned[member.ID] = true
+			team = append(team, p.GetProfile())
+		}
+		teams = append(teams, team)
+	}
+	return teams, validateTeams(b.Mode, teams)
+}
+
+func validateTeams(mode common.BattleMode, teams [][]*common.Profile) error {
+	switch mode {
+	case common.DUEL:
+		if len(teams) != 2 || len(teams[0]) != 1 || len(teams[1]) != 1 {
+			return newMatchingError(common.ERR_INVALID_BATTLE, "1対1の対戦には二人を割り当ててください。")
+		}
+	case common.TEAM:
+		if len(teams) != 2 || len(teams[0]) == 0 || len(teams[0]) != len(teams[1]) {
+			return newMatchingError(common.ERR_INVALID_BATTLE, "チーム戦には同じ人数の二つのチームを割り当ててください。")
+		}
+	case common.FREE_FOR_ALL:
+		if len(teams) < MIN_FREE_FOR_ALL_SIZE {
+			return newMatchingError(common.ERR_INVALID_BATTLE, "個人戦には%d人以上必要です。", MIN_FREE_FOR_ALL_SIZE)
+		}
+		for _, team := range teams {
+			if len(team) != 1 {
+				return newMatchingError(common.ERR_INVALID_BATTLE, "個人戦ではチームに一人ずつ割り当ててください。")
+			}
+		}
+	default:
+		return newMatchingError(common.ERR_INVALID_BATTLE, "対戦の形式が正しくありません。")
+	}
+	return nil
+}
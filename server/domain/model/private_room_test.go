@@ -0,0 +1,127 @@
+package model
+
+import (
+	"context"
+	"github.com/taise-hub/shellgame-cli/common"
+	"testing"
+	"time"
+)
+
+func TestMatchingRoomHostStart(t *testing.T) {
+	bus := newFakeBus()
+	mr := NewMatchingRoom("private:ABC234", bus)
+	mr.SetHost("0")
+	mr.SetQuestionSet("advanced")
+	runTestRoom(t, mr, bus)
+	host, hostConn := joinTestRoom(mr, "0", "host")
+	bob, bobConn := joinTestRoom(mr, "1", "bob")
+	alice, aliceConn := joinTestRoom(mr, "2", "alice")
+	carol, _ := joinTestRoom(mr, "3", "carol")
+	for i := 0; i < 3; i++ {
+		hostConn.expect(t, common.JOIN)
+	}
+
+	start := func(src *MatchingPlayer, mode common.BattleMode, teams ...[]*MatchingPlayer) {
+		battle := &common.Battle{Mode: mode}
+		for _, team := range teams {
+			t := &common.Team{}
+			for _, p := range team {
+				t.Members = append(t.Members, p.GetProfile())
+			}
+			battle.Teams = append(battle.Teams, t)
+		}
+		mr.message <- &playerMessage{src, &common.MatchingMessage{Data: common.START, Battle: battle}}
+	}
+
+	tests := []struct {
+		name  string
+		src   *MatchingPlayer
+		mode  common.BattleMode
+		teams [][]*MatchingPlayer
+	}{
+		{name: "ホスト以外は組み合わせを指定できない。", src: bob, mode: common.DUEL, teams: [][]*MatchingPlayer{{bob}, {alice}}},
+		{name: "形式に合わない組み合わせは拒否される。", src: host, mode: common.DUEL, teams: [][]*MatchingPlayer{{bob, carol}, {alice}}},
+		{name: "同じプレイヤーを二度指定できない。", src: host, mode: common.FREE_FOR_ALL, teams: [][]*MatchingPlayer{{bob}, {bob}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start(tt.src, tt.mode, tt.teams...)
+			msg := (map[*MatchingPlayer]*fakeConn{host: hostConn, bob: bobConn})[tt.src].expect(t, common.ERROR)
+			if msg.Reason == "" {
+				t.Errorf("Expected: reason\n\t\t Actual: %q \n", msg.Reason)
+			}
+		})
+	}
+	t.Run("ホストが指定した組み合わせで対戦が開始され、ホストにも通知される。", func(t *testing.T) {
+		start(host, common.DUEL, []*MatchingPlayer{bob}, []*MatchingPlayer{alice})
+		for _, conn := range []*fakeConn{hostConn, bobConn, aliceConn} {
+			msg := conn.expect(t, common.START)
+			if b := msg.Battle; b == nil || b.Mode != common.DUEL || b.QuestionSet != "advanced" || len(b.Teams) != 2 {
+				t.Fatalf("Expected: duel with advanced questions\n\t\t Actual: %+v \n", b)
+			}
+		}
+		for p, expected := range map[*MatchingPlayer]MatchingStatus{host: WAITING, bob: IN_BATTLE, alice: IN_BATTLE, carol: WAITING} {
+			if actual := statusOf(mr, p.GetID()); actual != expected {
+				t.Errorf("%s Expected: %s\n\t\t Actual: %s \n", p.GetName(), expected, actual)
+			}
+		}
+	})
+	t.Run("対戦中のプレイヤーは指定できない。", func(t *testing.T) {
+		start(host, common.DUEL, []*MatchingPlayer{bob}, []*MatchingPlayer{carol})
+		hostConn.expect(t, common.ERROR)
+		if actual := statusOf(mr, carol.GetID()); actual != WAITING {
+			t.Errorf("Expected: %s\n\t\t Actual: %s \n", WAITING, actual)
+		}
+	})
+}
+
+func TestMatchingRoomClosesWhenVacant(t *testing.T) {
+	bus := newFakeBus()
+	clock := &fakeClock{now: time.Now()}
+	mr := NewMatchingRoom("private:ABC234", bus)
+	mr.now = clock.Now
+	mr.sweep = 10 * time.Millisecond
+	mr.grace = 0
+	mr.SetVacancyTimeout(time.Minute)
+	n := bus.count(mr.Name)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	closed := make(chan error, 1)
+	go func() { closed <- mr.Run(ctx) }()
+	for bus.count(mr.Name) == n {
+		time.Sleep(time.Millisecond)
+	}
+	bob, bobConn := joinTestRoom(mr, "1", "bob")
+	bobConn.expect(t, common.SNAPSHOT)
+
+	t.Run("参加者がいる間は閉じない。", func(t *testing.T) {
+		clock.Advance(2 * time.Minute)
+		select {
+		case err := <-closed:
+			t.Fatalf("Expected: running\n\t\t Actual: closed (%v) \n", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+	mr.unregister <- bob
+	t.Run("誰もいない状態が続くと閉じる。", func(t *testing.T) {
+		timeout := time.After(time.Second)
+		for {
+			select {
+			case err := <-closed:
+				if err != nil {
+					t.Errorf("Expected: %v\n\t\t Actual: %v \n", nil, err)
+				}
+				return
+			case <-timeout:
+				t.Fatalf("Expected: closed\n\t\t Actual: running \n")
+			case <-time.After(10 * time.Millisecond):
+				clock.Advance(10 * time.Second)
+			}
+		}
+	})
+	t.Run("閉じたルームには登録できない。", func(t *testing.T) {
+		if err := mr.Register(NewMatchingPlayer("2", "alice", newFakeConn())); err != ErrRoomClosed {
+			t.Errorf("Expected: %v\n\t\t Actual: %v \n", ErrRoomClosed, err)
+		}
+	})
+}
@@ -0,0 +1,36 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	MAX_NAME_LENGTH = 20
+)
+
+var (
+	ErrEmptyName       = errors.New("プレイヤー名を入力してください。")
+	ErrNameTooLong     = fmt.Errorf("%d文字以内で入力してください。", MAX_NAME_LENGTH)
+	ErrInvalidNameChar = errors.New("プレイヤー名に使用できない文字が含まれています。")
+)
+
+// プレイヤー名として利用できるか確認する。
+// 文字、数字、'-'、'_'のみ利用できる。
+func ValidateName(name string) error {
+	if name == "" {
+		return ErrEmptyName
+	}
+	if utf8.RuneCountInString(name) > MAX_NAME_LENGTH {
+		return ErrNameTooLong
+	}
+	for _, r := range name {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' {
+			continue
+		}
+		return ErrInvalidNameChar
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package model
+
+import (
+	"testing"
+)
+
+func TestValidateName(t *testing.T) {
+	tests := map[string]struct {
+		name        string
+		expectedErr error
+	}{
+		"英数字のプレイヤー名は利用できる。": {
+			name:        "bob_01",
+			expectedErr: nil,
+		},
+		"日本語のプレイヤー名は利用できる。": {
+			name:        "しぇるげー太郎",
+			expectedErr: nil,
+		},
+		"空のプレイヤー名は利用できない。": {
+			name:        "",
+			expectedErr: ErrEmptyName,
+		},
+		"20文字を超えるプレイヤー名は利用できない。": {
+			name:        "012345678901234567890",
+			expectedErr: ErrNameTooLong,
+		},
+		"20文字の日本語のプレイヤー名は利用できる。": {
+			name:        "あいうえおかきくけこさしすせそたちつてと",
+			expectedErr: nil,
+		},
+		"空白を含むプレイヤー名は利用できない。": {
+			name:        "bob alice",
+			expectedErr: ErrInvalidNameChar,
+		},
+		"制御文字を含むプレイヤー名は利用できない。": {
+			name:        "bob\x1b[31m",
+			expectedErr: ErrInvalidNameChar,
+		},
+	}
+
+	for tName, tt := range tests {
+		t.Run(tName, func(t *testing.T) {
+			actual := ValidateName(tt.name)
+			if actual != tt.expectedErr {
+				t.Errorf("Expected: %v\n\t\t Actual: %v \n", tt.expectedErr, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,17 @@
+package model
+
+const (
+	DEFAULT_QUESTION_SET = "beginner"
+)
+
+// 対戦で出題する問題の組
+var QUESTION_SETS = []string{"beginner", "advanced"}
+
+func IsQuestionSet(name string) bool {
+	for _, v := range QUESTION_SETS {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
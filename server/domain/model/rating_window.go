@@ -0,0 +1,114 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_RATING = 1500 // 登録時のレーティング
+)
+
+// 待ち時間に応じてランダム対戦の組み合わせを許容するレーティング差を広げる曲線
+type RatingWindow interface {
+	Width(waited time.Duration) int
+	// 許容幅がwidthに達するまでの待ち時間。最大幅がwidthに満たない場合はfalseを返す。
+	WaitFor(width int) (time.Duration, bool)
+}
+
+// 最初はInitial、以降1秒ごとにPerSecondずつ広がり、Maxを超えて広がらない。
+type LinearWindow struct {
+	Initial   int
+	PerSecond int
+	Max       int
+}
+
+func (w LinearWindow) Width(waited time.Duration) int {
+	width := w.Initial + int(waited.Seconds()*float64(w.PerSecond))
+	if width > w.Max {
+		return w.Max
+	}
+	return width
+}
+
+func (w LinearWindow) WaitFor(width int) (time.Duration, bool) {
+	if width <= w.Initial {
+		return 0, true
+	}
+	if width > w.Max || w.PerSecond <= 0 {
+		return 0, false
+	}
+	secs := math.Ceil(float64(width-w.Initial) / float64(w.PerSecond))
+	return time.Duration(secs) * time.Second, true
+}
+
+// 最初はInitial、以降IntervalごとにStepずつ広がり、Maxを超えて広がらない。
+type StepWindow struct {
+	Initial  int
+	Step     int
+	Interval time.Duration
+	Max      int
+}
+
+func (w StepWindow) Width(waited time.Duration) int {
+	width := w.Initial
+	if w.Interval > 0 {
+		width += w.Step * int(waited/w.Interval)
+	}
+	if width > w.Max {
+		return w.Max
+	}
+	return width
+}
+
+func (w StepWindow) WaitFor(width int) (time.Duration, bool) {
+	if width <= w.Initial {
+		return 0, true
+	}
+	if width > w.Max || w.Step <= 0 || w.Interval <= 0 {
+		return 0, false
+	}
+	steps := (width - w.Initial + w.Step - 1) / w.Step
+	return time.Duration(steps) * w.Interval, true
+}
+
+var DEFAULT_RATING_WINDOW RatingWindow = LinearWindow{Initial: 100, PerSecond: 10, Max: 1000}
+
+// "linear:初期幅:毎秒の増分:最大幅"、または"step:初期幅:増分:間隔:最大幅"の形式で曲線を指定する。
+// 例: "linear:100:10:1000", "step:100:50:10s:1000"
+func ParseRatingWindow(spec string) (RatingWindow, error) {
+	fields := strings.Split(spec, ":")
+	ints := func(ss ...string) ([]int, error) {
+		var vs []int
+		for _, s := range ss {
+			v, err := strconv.Atoi(s)
+			if err != nil || v < 0 {
+				return nil, fmt.Errorf("invalid rating window %q", spec)
+			}
+			vs = append(vs, v)
+		}
+		return vs, nil
+	}
+	switch {
+	case fields[0] == "linear" && len(fields) == 4:
+		vs, err := ints(fields[1:]...)
+		if err != nil {
+			return nil, err
+		}
+		return LinearWindow{Initial: vs[0], PerSecond: vs[1], Max: vs[2]}, nil
+	case fields[0] == "step" && len(fields) == 5:
+		vs, err := ints(fields[1], fields[2], fields[4])
+		if err != nil {
+			return nil, err
+		}
+		interval, err := time.ParseDuration(fields[3])
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("invalid rating window %q", spec)
+		}
+		return StepWindow{Initial: vs[0], Step: vs[1], Interval: interval, Max: vs[2]}, nil
+	}
+	return nil, fmt.Errorf("invalid rating window %q", spec)
+}
@@ -0,0 +1,34 @@
+package repository
+
+import (
+	"errors"
+)
+
+var (
+	ErrAccountNotFound   = errors.New("アカウントが見つかりません。")
+	ErrChallengeNotFound = errors.New("チャレンジの有効期限が切れています。")
+)
+
+// Accountは登録済みプレイヤーの認証情報
+// IDはプロフィールのIDとしても利用し、ログインし直しても同じIDで対戦できるようにする。
+type Account struct {
+	ID           string   `json:"id"`
+	Name         string   `json:"name"`
+	PasswordHash []byte   `json:"password_hash,omitempty"`
+	PublicKeys   []string `json:"public_keys,omitempty"` // authorized_keys形式
+	Rating       int      `json:"rating"`
+}
+
+// 登録済みプレイヤーのアカウントに関する操作を行うRepository
+// アカウントはプレイヤー名で一意に識別する。
+type AccountRepository interface {
+	Create(*Account) error // プレイヤー名が使われている場合はErrNameTakenを返す。
+	FindByName(string) (*Account, error)
+}
+
+// SSH公開鍵認証のために発行したチャレンジを保持するRepository
+// チャレンジごとに発行先のプレイヤー名を保持し、同じプレイヤー名に発行した他のチャレンジを上書きしない。
+type ChallengeRepository interface {
+	Save(challenge string, name string) error
+	Take(challenge string) (string, error) // 発行先のプレイヤー名を返す。取り出したチャレンジは削除し、再利用できないようにする。
+}
@@ -6,4 +6,6 @@ import (
 
 type ConsoleRepository interface {
 	StartShell() (net.Conn, error)
+	JoinShell(string) (net.Conn, error) // 名前で指定したコンソールを共有する。初めて利用する場合は作成する。
+	RemoveShell(string) error           // 名前で指定したコンソールを削除する。
 }
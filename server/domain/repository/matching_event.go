@@ -0,0 +1,13 @@
+package repository
+
+import (
+	"context"
+	"github.com/taise-hub/shellgame-cli/common"
+)
+
+// マッチングルームで発生したイベント(JOIN/LEAVE/OFFER/ACCEPT...)を複数のサーバ間で共有するためのPub/Sub
+// 全てのサーバが同じ順序でイベントを受け取ることを前提としている。イベントはルーム名ごとに分けて扱う。
+type MatchingEventBus interface {
+	Publish(string, *common.MatchingMessage) error
+	Subscribe(context.Context, string) (<-chan *common.MatchingMessage, error)
+}
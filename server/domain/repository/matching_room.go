@@ -1,7 +1,9 @@
 package repository
 
 // 対戦待ちのプレイヤーに関する操作を行うRepository
+// プレイヤーはルーム名ごとに管理する。
 type MatchingRoomRepository interface {
-	GetAll() ([]string, error)
-	SetID(string) error
+	GetAll(string) ([]string, error)
+	SetID(string, string) error
+	RemoveID(string, string) error
 }
@@ -0,0 +1,24 @@
+package repository
+
+import (
+	"errors"
+)
+
+var (
+	ErrCodeTaken           = errors.New("この招待コードは既に使われています。")
+	ErrPrivateRoomNotFound = errors.New("招待コードに対応するルームが見つかりません。")
+)
+
+// 招待コードを知っているプレイヤーだけが参加できるマッチングルーム
+type PrivateRoom struct {
+	Code        string `json:"code"`
+	Host        string `json:"host"` // 作成したプレイヤーのID
+	QuestionSet string `json:"question_set"`
+}
+
+// プライベートルームに関する操作を行うRepository
+// プライベートルームは招待コードで一意に識別する。
+type PrivateRoomRepository interface {
+	Create(*PrivateRoom) error // 招待コードが使われている場合はErrCodeTakenを返す。
+	Find(string) (*PrivateRoom, error)
+}
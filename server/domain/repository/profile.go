@@ -0,0 +1,20 @@
+package repository
+
+import (
+	"errors"
+	"github.com/taise-hub/shellgame-cli/common"
+)
+
+var (
+	ErrNameTaken       = errors.New("このプレイヤー名は既に使われています。")
+	ErrProfileNotFound = errors.New("プレイヤーが見つかりません。")
+)
+
+// プレイヤーのプロフィールに関する操作を行うRepository
+// プレイヤー名は全てのプロフィールの中で一意でなければならない。
+type ProfileRepository interface {
+	Create(*common.Profile) error // プレイヤー名が使われている場合はErrNameTakenを返す。
+	Find(string) (*common.Profile, error)
+	Rename(string, string) (*common.Profile, error)
+	Delete(string) error
+}
@@ -0,0 +1,76 @@
+package infrastructure
+
+import (
+	"github.com/taise-hub/shellgame-cli/server/domain/repository"
+	"github.com/taise-hub/shellgame-cli/server/interfaces"
+	"testing"
+)
+
+func TestAccountRepository(t *testing.T) {
+	handlers := map[string]func(t *testing.T) interfaces.HashHandler{
+		"memory": func(t *testing.T) interfaces.HashHandler { return NewMemoryHandler() },
+		"redis":  func(t *testing.T) interfaces.HashHandler { return newTestRedisHandler(t) },
+	}
+	bob := &repository.Account{ID: "1", Name: "bob", PasswordHash: []byte("hash")}
+
+	for hName, newHandler := range handlers {
+		t.Run(hName+"/登録したアカウントをプレイヤー名で取得できる。", func(t *testing.T) {
+			repo := interfaces.NewAccountRepository(newHandler(t))
+			if err := repo.Create(bob); err != nil {
+				t.Fatalf("Create(): %v", err)
+			}
+			actual, err := repo.FindByName(bob.Name)
+			if err != nil {
+				t.Fatalf("FindByName(): %v", err)
+			}
+			if actual.ID != bob.ID || string(actual.PasswordHash) != string(bob.PasswordHash) {
+				t.Errorf("Expected: %v\n\t\t Actual: %v \n", bob, actual)
+			}
+		})
+		t.Run(hName+"/同じプレイヤー名では登録できない。", func(t *testing.T) {
+			repo := interfaces.NewAccountRepository(newHandler(t))
+			repo.Create(bob)
+			if err := repo.Create(&repository.Account{ID: "2", Name: "bob"}); err != repository.ErrNameTaken {
+				t.Errorf("Expected: %v\n\t\t Actual: %v \n", repository.ErrNameTaken, err)
+			}
+		})
+		t.Run(hName+"/登録されていないアカウントは取得できない。", func(t *testing.T) {
+			repo := interfaces.NewAccountRepository(newHandler(t))
+			if _, err := repo.FindByName("alice"); err != repository.ErrAccountNotFound {
+				t.Errorf("Expected: %v\n\t\t Actual: %v \n", repository.ErrAccountNotFound, err)
+			}
+		})
+	}
+}
+
+func TestChallengeRepository(t *testing.T) {
+	handlers := map[string]func(t *testing.T) interfaces.StringHandler{
+		"memory": func(t *testing.T) interfaces.StringHandler { return NewMemoryHandler() },
+		"redis":  func(t *testing.T) interfaces.StringHandler { return newTestRedisHandler(t) },
+	}
+	for hName, newHandler := range handlers {
+		t.Run(hName+"/チャレンジは一度しか取り出せない。", func(t *testing.T) {
+			repo := interfaces.NewChallengeRepository(newHandler(t))
+			if err := repo.Save("challenge", "bob"); err != nil {
+				t.Fatalf("Save(): %v", err)
+			}
+			actual, err := repo.Take("challenge")
+			if err != nil || actual != "bob" {
+				t.Errorf("Expected: %v\n\t\t Actual: %v, %v \n", "bob", actual, err)
+			}
+			if _, err := repo.Take("challenge"); err != repository.ErrChallengeNotFound {
+				t.Errorf("Expected: %v\n\t\t Actual: %v \n", repository.ErrChallengeNotFound, err)
+			}
+		})
+		t.Run(hName+"/同じプレイヤー名に発行しても以前のチャレンジを取り出せる。", func(t *testing.T) {
+			repo := interfaces.NewChallengeRepository(newHandler(t))
+			repo.Save("first", "bob")
+			repo.Save("second", "bob")
+			for _, challenge := range []string{"first", "second"} {
+				if actual, err := repo.Take(challenge); err != nil || actual != "bob" {
+					t.Errorf("Expected: %v\n\t\t Actual: %v, %v \n", "bob", actual, err)
+				}
+			}
+		})
+	}
+}
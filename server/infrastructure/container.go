@@ -49,6 +49,16 @@ func NewContainerHandler() (interfaces.ContainerHandler, error) {
 	return h, nil
 }
 
+func (h *containerHandler) Find(ctx context.Context, containerName string) (string, error) {
+	info, err := h.client.ContainerInspect(ctx, containerName)
+	if client.IsErrNotFound(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return info.ID, nil
+}
+
 func (h *containerHandler) Create(ctx context.Context, containerName string) (string, error) {
 	createdBody, err := h.client.ContainerCreate(ctx, conf, hconf, nil, nil, containerName)
 	if err != nil {
@@ -0,0 +1,57 @@
+package infrastructure
+
+import (
+	"context"
+	"github.com/taise-hub/shellgame-cli/common"
+	"github.com/taise-hub/shellgame-cli/server/interfaces"
+	"testing"
+	"time"
+)
+
+func TestMatchingEventBus(t *testing.T) {
+	handlers := map[string]func(t *testing.T) interfaces.PubSubHandler{
+		"memory": func(t *testing.T) interfaces.PubSubHandler { return NewMemoryHandler() },
+		"redis":  func(t *testing.T) interfaces.PubSubHandler { return newTestRedisHandler(t) },
+	}
+	sent := []*common.MatchingMessage{
+		{Source: &common.Profile{ID: "1", Name: "bob"}, Data: common.JOIN},
+		{Source: &common.Profile{ID: "2", Name: "alice"}, Data: common.JOIN},
+		{Source: &common.Profile{ID: "1", Name: "bob"}, Dest: &common.Profile{ID: "2", Name: "alice"}, Data: common.OFFER},
+		{Source: &common.Profile{ID: "2", Name: "alice"}, Dest: &common.Profile{ID: "1", Name: "bob"}, Data: common.ACCEPT},
+	}
+
+	for hName, newHandler := range handlers {
+		t.Run(hName+"/全ての購読者が発行された順序でイベントを受信できる。", func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			h := newHandler(t)
+			// 別々のサーバを想定して、購読者ごとにMatchingEventBusを生成する。
+			var subs []<-chan *common.MatchingMessage
+			for i := 0; i < 2; i++ {
+				events, err := interfaces.NewMatchingEventBus(h).Subscribe(ctx, "beginner")
+				if err != nil {
+					t.Fatalf("Subscribe(): %v", err)
+				}
+				subs = append(subs, events)
+			}
+			bus := interfaces.NewMatchingEventBus(h)
+			for _, msg := range sent {
+				if err := bus.Publish("beginner", msg); err != nil {
+					t.Fatalf("Publish(): %v", err)
+				}
+			}
+			for i, events := range subs {
+				for _, expected := range sent {
+					select {
+					case actual := <-events:
+						if actual.Data != expected.Data || actual.Source.ID != expected.Source.ID {
+							t.Errorf("subscriber %d Expected: %#v\n\t\t Actual: %#v \n", i, expected, actual)
+						}
+					case <-time.After(time.Second):
+						t.Fatalf("subscriber %d: timed out waiting for %#v", i, expected)
+					}
+				}
+			}
+		})
+	}
+}
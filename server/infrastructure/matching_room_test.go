@@ -0,0 +1,115 @@
+package infrastructure
+
+import (
+	"github.com/alicebob/miniredis/v2"
+	"github.com/taise-hub/shellgame-cli/server/interfaces"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// テスト用にプロセス内でRedis互換サーバを起動する。
+func newTestRedisHandler(t *testing.T) *RedisHandler {
+	t.Helper()
+	s := miniredis.RunT(t)
+	h, err := NewRedisHandler(s.Addr())
+	if err != nil {
+		t.Fatalf("NewRedisHandler(): %v", err)
+	}
+	t.Cleanup(func() { h.Close() })
+	return h
+}
+
+func TestMatchingRoomRepository(t *testing.T) {
+	handlers := map[string]func(t *testing.T) interfaces.SetHandler{
+		"memory": func(t *testing.T) interfaces.SetHandler { return NewMemoryHandler() },
+		"redis":  func(t *testing.T) interfaces.SetHandler { return newTestRedisHandler(t) },
+	}
+	tests := map[string]struct {
+		set      []string
+		other    []string
+		remove   []string
+		expected []string
+	}{
+		"登録したIDを全て取得できる。": {
+			set:      []string{"bob", "alice"},
+			expected: []string{"alice", "bob"},
+		},
+		"同じIDを複数回登録しても一つだけ取得できる。": {
+			set:      []string{"bob", "bob"},
+			expected: []string{"bob"},
+		},
+		"削除したIDは取得されない。": {
+			set:      []string{"bob", "alice"},
+			remove:   []string{"bob"},
+			expected: []string{"alice"},
+		},
+		"別のマッチングルームのIDは取得されない。": {
+			set:      []string{"bob"},
+			other:    []string{"alice"},
+			expected: []string{"bob"},
+		},
+		"誰もいない時は空のスライスを返す。": {
+			set:      []string{"bob"},
+			remove:   []string{"bob"},
+			expected: []string{},
+		},
+	}
+
+	for hName, newHandler := range handlers {
+		for tName, tt := range tests {
+			t.Run(hName+"/"+tName, func(t *testing.T) {
+				repo := interfaces.NewMatchingRoomRepository(newHandler(t))
+				for _, id := range tt.set {
+					if err := repo.SetID("beginner", id); err != nil {
+						t.Fatalf("SetID(%s): %v", id, err)
+					}
+				}
+				for _, id := range tt.other {
+					if err := repo.SetID("advanced", id); err != nil {
+						t.Fatalf("SetID(%s): %v", id, err)
+					}
+				}
+				for _, id := range tt.remove {
+					if err := repo.RemoveID("beginner", id); err != nil {
+						t.Fatalf("RemoveID(%s): %v", id, err)
+					}
+				}
+				actual, err := repo.GetAll("beginner")
+				if err != nil {
+					t.Fatalf("GetAll(): %v", err)
+				}
+				sort.Strings(actual)
+				if !reflect.DeepEqual(actual, tt.expected) {
+					t.Errorf("Expected: %v\n\t\t Actual: %v \n", tt.expected, actual)
+				}
+			})
+		}
+	}
+}
+
+func TestMatchingRoomRepositorySurvivesReconnect(t *testing.T) {
+	s := miniredis.RunT(t)
+	h, err := NewRedisHandler(s.Addr())
+	if err != nil {
+		t.Fatalf("NewRedisHandler(): %v", err)
+	}
+	if err := interfaces.NewMatchingRoomRepository(h).SetID("beginner", "bob"); err != nil {
+		t.Fatalf("SetID(): %v", err)
+	}
+	h.Close()
+
+	// サーバの再起動を想定して、新しいコネクションから取得する。
+	h, err = NewRedisHandler(s.Addr())
+	if err != nil {
+		t.Fatalf("NewRedisHandler(): %v", err)
+	}
+	defer h.Close()
+	actual, err := interfaces.NewMatchingRoomRepository(h).GetAll("beginner")
+	if err != nil {
+		t.Fatalf("GetAll(): %v", err)
+	}
+	if !reflect.DeepEqual(actual, []string{"bob"}) {
+		t.Errorf("Expected: %v\n\t\t Actual: %v \n", []string{"bob"}, actual)
+	}
+}
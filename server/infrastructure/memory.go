@@ -0,0 +1,204 @@
+package infrastructure
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryHandlerはinterfaces.SetHandler, interfaces.HashHandler, interfaces.StringHandler, interfaces.PubSubHandlerのインメモリ実装
+// Redisを用意しない場合に利用する。サーバを再起動すると状態は失われ、他のサーバとイベントを共有することはできない。
+type MemoryHandler struct {
+	mu          sync.RWMutex
+	sets        map[string]map[string]struct{}
+	hashes      map[string]map[string]string
+	strings     map[string]expiringString
+	subscribers map[string]map[*subscriber]struct{}
+}
+
+func NewMemoryHandler() *MemoryHandler {
+	return &MemoryHandler{
+		sets:        make(map[string]map[string]struct{}),
+		hashes:      make(map[string]map[string]string),
+		strings:     make(map[string]expiringString),
+		subscribers: make(map[string]map[*subscriber]struct{}),
+	}
+}
+
+func (h *MemoryHandler) SAdd(_ context.Context, key string, member string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.sets[key]; !ok {
+		h.sets[key] = make(map[string]struct{})
+	}
+	h.sets[key][member] = struct{}{}
+	return nil
+}
+
+func (h *MemoryHandler) SMembers(_ context.Context, key string) ([]string, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	members := []string{}
+	for m := range h.sets[key] {
+		members = append(members, m)
+	}
+	sort.Strings(members)
+	return members, nil
+}
+
+func (h *MemoryHandler) SRem(_ context.Context, key string, member string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.sets[key], member)
+	if len(h.sets[key]) == 0 {
+		delete(h.sets, key)
+	}
+	return nil
+}
+
+func (h *MemoryHandler) HSetNX(_ context.Context, key string, field string, value string) (bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.hashes[key][field]; ok {
+		return false, nil
+	}
+	h.hset(key, field, value)
+	return true, nil
+}
+
+func (h *MemoryHandler) HSet(_ context.Context, key string, field string, value string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.hset(key, field, value)
+	return nil
+}
+
+func (h *MemoryHandler) hset(key string, field string, value string) {
+	if _, ok := h.hashes[key]; !ok {
+		h.hashes[key] = make(map[string]string)
+	}
+	h.hashes[key][field] = value
+}
+
+// fieldが存在しない場合はfalseを返す。
+func (h *MemoryHandler) HGet(_ context.Context, key string, field string) (string, bool, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	v, ok := h.hashes[key][field]
+	return v, ok, nil
+}
+
+func (h *MemoryHandler) HDel(_ context.Context, key string, field string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.hashes[key], field)
+	if len(h.hashes[key]) == 0 {
+		delete(h.hashes, key)
+	}
+	return nil
+}
+
+type expiringString struct {
+	value    string
+	deadline time.Time
+}
+
+func (h *MemoryHandler) SetEX(_ context.Context, key string, value string, ttl time.Duration) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.strings[key] = expiringString{value: value, deadline: time.Now().Add(ttl)}
+	return nil
+}
+
+// keyが存在しない場合や有効期限が切れている場合はfalseを返す。
+func (h *MemoryHandler) Get(_ context.Context, key string) (string, bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.strings[key]
+	if !ok {
+		return "", false, nil
+	}
+	if !time.Now().Before(s.deadline) {
+		delete(h.strings, key)
+		return "", false, nil
+	}
+	return s.value, true, nil
+}
+
+func (h *MemoryHandler) Del(_ context.Context, key string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.strings, key)
+	return nil
+}
+
+// 購読者の受信を待たずに返る。購読者ごとに発行された順序は保たれる。
+func (h *MemoryHandler) Publish(_ context.Context, channel string, payload []byte) error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for s := range h.subscribers[channel] {
+		s.push(payload)
+	}
+	return nil
+}
+
+func (h *MemoryHandler) Subscribe(ctx context.Context, channel string) (<-chan []byte, error) {
+	s := &subscriber{notify: make(chan struct{}, 1)}
+	h.mu.Lock()
+	if _, ok := h.subscribers[channel]; !ok {
+		h.subscribers[channel] = make(map[*subscriber]struct{})
+	}
+	h.subscribers[channel][s] = struct{}{}
+	h.mu.Unlock()
+
+	payloads := make(chan []byte)
+	go func() {
+		defer close(payloads)
+		defer func() {
+			h.mu.Lock()
+			delete(h.subscribers[channel], s)
+			h.mu.Unlock()
+		}()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.notify:
+			}
+			for _, payload := range s.pop() {
+				select {
+				case payloads <- payload:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return payloads, nil
+}
+
+// 発行者が購読者の処理を待たないように、受信したメッセージを溜めておく。
+type subscriber struct {
+	mu     sync.Mutex
+	queue  [][]byte
+	notify chan struct{}
+}
+
+func (s *subscriber) push(payload []byte) {
+	s.mu.Lock()
+	s.queue = append(s.queue, payload)
+	s.mu.Unlock()
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *subscriber) pop() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	queue := s.queue
+	s.queue = nil
+	return queue
+}
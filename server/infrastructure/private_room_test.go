@@ -0,0 +1,44 @@
+package infrastructure
+
+import (
+	"github.com/taise-hub/shellgame-cli/server/domain/repository"
+	"github.com/taise-hub/shellgame-cli/server/interfaces"
+	"testing"
+)
+
+func TestPrivateRoomRepository(t *testing.T) {
+	handlers := map[string]func(t *testing.T) interfaces.HashHandler{
+		"memory": func(t *testing.T) interfaces.HashHandler { return NewMemoryHandler() },
+		"redis":  func(t *testing.T) interfaces.HashHandler { return newTestRedisHandler(t) },
+	}
+	room := &repository.PrivateRoom{Code: "ABC234", Host: "1", QuestionSet: "advanced"}
+
+	for hName, newHandler := range handlers {
+		t.Run(hName+"/作成したプライベートルームを招待コードで取得できる。", func(t *testing.T) {
+			repo := interfaces.NewPrivateRoomRepository(newHandler(t))
+			if err := repo.Create(room); err != nil {
+				t.Fatalf("Create(): %v", err)
+			}
+			actual, err := repo.Find(room.Code)
+			if err != nil {
+				t.Fatalf("Find(): %v", err)
+			}
+			if *actual != *room {
+				t.Errorf("Expected: %v\n\t\t Actual: %v \n", room, actual)
+			}
+		})
+		t.Run(hName+"/同じ招待コードでは作成できない。", func(t *testing.T) {
+			repo := interfaces.NewPrivateRoomRepository(newHandler(t))
+			repo.Create(room)
+			if err := repo.Create(&repository.PrivateRoom{Code: room.Code, Host: "2"}); err != repository.ErrCodeTaken {
+				t.Errorf("Expected: %v\n\t\t Actual: %v \n", repository.ErrCodeTaken, err)
+			}
+		})
+		t.Run(hName+"/存在しない招待コードのルームは取得できない。", func(t *testing.T) {
+			repo := interfaces.NewPrivateRoomRepository(newHandler(t))
+			if _, err := repo.Find("XYZ789"); err != repository.ErrPrivateRoomNotFound {
+				t.Errorf("Expected: %v\n\t\t Actual: %v \n", repository.ErrPrivateRoomNotFound, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package infrastructure
+
+import (
+	"errors"
+	"github.com/gorilla/securecookie"
+	"github.com/taise-hub/shellgame-cli/common"
+	"github.com/taise-hub/shellgame-cli/server/domain/repository"
+	"github.com/taise-hub/shellgame-cli/server/interfaces"
+	"github.com/taise-hub/shellgame-cli/server/usecase"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// cookieを付けてProfileControllerにリクエストを送り、レスポンスを返す。
+func requestProfile(con *interfaces.ProfileController, method string, cookie *http.Cookie) *http.Response {
+	req := httptest.NewRequest(method, "/profiles", nil)
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	con.Profile(rec, req)
+	return rec.Result()
+}
+
+func TestProfileControllerLogout(t *testing.T) {
+	h := NewMemoryHandler()
+	profileRepo := interfaces.NewProfileRepository(h)
+	accountRepo := interfaces.NewAccountRepository(h)
+	store := interfaces.NewKVSSessionStore(h, 60, securecookie.GenerateRandomKey(64))
+	con := interfaces.NewProfileController(usecase.NewProfileInteractor(profileRepo, accountRepo), store)
+	bob := &common.Profile{ID: "1", Name: "bob"}
+	alice := &common.Profile{ID: "2", Name: "alice"}
+	if err := accountRepo.Create(&repository.Account{ID: bob.ID, Name: bob.Name}); err != nil {
+		t.Fatalf("Create(): %v", err)
+	}
+	for _, p := range []*common.Profile{bob, alice} {
+		if err := profileRepo.Create(p); err != nil {
+			t.Fatalf("Create(): %v", err)
+		}
+	}
+
+	t.Run("アカウントのセッションからログアウトしても、同じアカウントの他のセッションは利用できる。", func(t *testing.T) {
+		first := saveTestSession(t, store, bob.ID)
+		second := saveTestSession(t, store, bob.ID)
+		if res := requestProfile(con, "DELETE", first); res.StatusCode != http.StatusNoContent {
+			t.Fatalf("Expected: %d\n\t\t Actual: %d \n", http.StatusNoContent, res.StatusCode)
+		}
+		if id := loadTestSession(store, first); id != nil {
+			t.Errorf("Expected: %v\n\t\t Actual: %v \n", nil, id)
+		}
+		if res := requestProfile(con, "GET", second); res.StatusCode != http.StatusOK {
+			t.Errorf("Expected: %d\n\t\t Actual: %d \n", http.StatusOK, res.StatusCode)
+		}
+		if _, err := profileRepo.Find(bob.ID); err != nil {
+			t.Errorf("Expected: %v\n\t\t Actual: %v \n", nil, err)
+		}
+	})
+	t.Run("ゲストのセッションからログアウトすると、プロフィールを削除する。", func(t *testing.T) {
+		cookie := saveTestSession(t, store, alice.ID)
+		if res := requestProfile(con, "DELETE", cookie); res.StatusCode != http.StatusNoContent {
+			t.Fatalf("Expected: %d\n\t\t Actual: %d \n", http.StatusNoContent, res.StatusCode)
+		}
+		if _, err := profileRepo.Find(alice.ID); !errors.Is(err, repository.ErrProfileNotFound) {
+			t.Errorf("Expected: %v\n\t\t Actual: %v \n", repository.ErrProfileNotFound, err)
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package infrastructure
+
+import (
+	"github.com/taise-hub/shellgame-cli/common"
+	"github.com/taise-hub/shellgame-cli/server/domain/repository"
+	"github.com/taise-hub/shellgame-cli/server/interfaces"
+	"testing"
+)
+
+func TestProfileRepository(t *testing.T) {
+	handlers := map[string]func(t *testing.T) interfaces.HashHandler{
+		"memory": func(t *testing.T) interfaces.HashHandler { return NewMemoryHandler() },
+		"redis":  func(t *testing.T) interfaces.HashHandler { return newTestRedisHandler(t) },
+	}
+	bob := &common.Profile{ID: "1", Name: "bob"}
+	alice := &common.Profile{ID: "2", Name: "alice"}
+
+	for hName, newHandler := range handlers {
+		t.Run(hName+"/登録したプロフィールをIDで取得できる。", func(t *testing.T) {
+			repo := interfaces.NewProfileRepository(newHandler(t))
+			if err := repo.Create(bob); err != nil {
+				t.Fatalf("Create(): %v", err)
+			}
+			actual, err := repo.Find(bob.ID)
+			if err != nil {
+				t.Fatalf("Find(): %v", err)
+			}
+			if *actual != *bob {
+				t.Errorf("Expected: %v\n\t\t Actual: %v \n", bob, actual)
+			}
+		})
+		t.Run(hName+"/同じプレイヤー名では登録できない。", func(t *testing.T) {
+			repo := interfaces.NewProfileRepository(newHandler(t))
+			repo.Create(bob)
+			if err := repo.Create(&common.Profile{ID: "3", Name: "bob"}); err != repository.ErrNameTaken {
+				t.Errorf("Expected: %v\n\t\t Actual: %v \n", repository.ErrNameTaken, err)
+			}
+		})
+		t.Run(hName+"/使われているプレイヤー名には変更できない。", func(t *testing.T) {
+			repo := interfaces.NewProfileRepository(newHandler(t))
+			repo.Create(bob)
+			repo.Create(alice)
+			if _, err := repo.Rename(alice.ID, "bob"); err != repository.ErrNameTaken {
+				t.Errorf("Expected: %v\n\t\t Actual: %v \n", repository.ErrNameTaken, err)
+			}
+		})
+		t.Run(hName+"/変更前のプレイヤー名は他のプレイヤーが利用できる。", func(t *testing.T) {
+			repo := interfaces.NewProfileRepository(newHandler(t))
+			repo.Create(&common.Profile{ID: bob.ID, Name: bob.Name})
+			renamed, err := repo.Rename(bob.ID, "bobby")
+			if err != nil {
+				t.Fatalf("Rename(): %v", err)
+			}
+			if renamed.Name != "bobby" {
+				t.Errorf("Expected: %v\n\t\t Actual: %v \n", "bobby", renamed.Name)
+			}
+			if err := repo.Create(&common.Profile{ID: "3", Name: "bob"}); err != nil {
+				t.Errorf("Expected: %v\n\t\t Actual: %v \n", nil, err)
+			}
+		})
+		t.Run(hName+"/削除したプロフィールは取得できず、プレイヤー名は他のプレイヤーが利用できる。", func(t *testing.T) {
+			repo := interfaces.NewProfileRepository(newHandler(t))
+			repo.Create(bob)
+			if err := repo.Delete(bob.ID); err != nil {
+				t.Fatalf("Delete(): %v", err)
+			}
+			if _, err := repo.Find(bob.ID); err != repository.ErrProfileNotFound {
+				t.Errorf("Expected: %v\n\t\t Actual: %v \n", repository.ErrProfileNotFound, err)
+			}
+			if err := repo.Create(&common.Profile{ID: "3", Name: "bob"}); err != nil {
+				t.Errorf("Expected: %v\n\t\t Actual: %v \n", nil, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package infrastructure
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"time"
+)
+
+// RedisHandlerはinterfaces.SetHandler, interfaces.HashHandler, interfaces.StringHandler, interfaces.PubSubHandlerのRedisによる実装
+// サーバを再起動してもマッチングルームの状態を保持することができ、複数のサーバ間でイベントを共有することができる。
+type RedisHandler struct {
+	client *redis.Client
+}
+
+func NewRedisHandler(addr string) (*RedisHandler, error) {
+	cli := redis.NewClient(&redis.Options{Addr: addr})
+	if err := cli.Ping(context.Background()).Err(); err != nil {
+		return nil, err
+	}
+	return &RedisHandler{client: cli}, nil
+}
+
+func (h *RedisHandler) SAdd(ctx context.Context, key string, member string) error {
+	return h.client.SAdd(ctx, key, member).Err()
+}
+
+func (h *RedisHandler) SMembers(ctx context.Context, key string) ([]string, error) {
+	return h.client.SMembers(ctx, key).Result()
+}
+
+func (h *RedisHandler) SRem(ctx context.Context, key string, member string) error {
+	return h.client.SRem(ctx, key, member).Err()
+}
+
+func (h *RedisHandler) HSetNX(ctx context.Context, key string, field string, value string) (bool, error) {
+	return h.client.HSetNX(ctx, key, field, value).Result()
+}
+
+func (h *RedisHandler) HSet(ctx context.Context, key string, field string, value string) error {
+	return h.client.HSet(ctx, key, field, value).Err()
+}
+
+// fieldが存在しない場合はfalseを返す。
+func (h *RedisHandler) HGet(ctx context.Context, key string, field string) (string, bool, error) {
+	v, err := h.client.HGet(ctx, key, field).Result()
+	if err == redis.Nil {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	return v, true, nil
+}
+
+func (h *RedisHandler) HDel(ctx context.Context, key string, field string) error {
+	return h.client.HDel(ctx, key, field).Err()
+}
+
+func (h *RedisHandler) SetEX(ctx context.Context, key string, value string, ttl time.Duration) error {
+	return h.client.Set(ctx, key, value, ttl).Err()
+}
+
+// keyが存在しない場合(有効期限切れを含む)はfalseを返す。
+func (h *RedisHandler) Get(ctx context.Context, key string) (string, bool, error) {
+	v, err := h.client.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	return v, true, nil
+}
+
+func (h *RedisHandler) Del(ctx context.Context, key string) error {
+	return h.client.Del(ctx, key).Err()
+}
+
+func (h *RedisHandler) Publish(ctx context.Context, channel string, payload []byte) error {
+	return h.client.Publish(ctx, channel, payload).Err()
+}
+
+// 購読が開始されたことを確認してからチャネルを返す。
+func (h *RedisHandler) Subscribe(ctx context.Context, channel string) (<-chan []byte, error) {
+	ps := h.client.Subscribe(ctx, channel)
+	if _, err := ps.Receive(ctx); err != nil {
+		ps.Close()
+		return nil, err
+	}
+	payloads := make(chan []byte)
+	go func() {
+		defer close(payloads)
+		defer ps.Close()
+		ch := ps.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				select {
+				case payloads <- []byte(msg.Payload):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return payloads, nil
+}
+
+func (h *RedisHandler) Close() error {
+	return h.client.Close()
+}
@@ -0,0 +1,96 @@
+package infrastructure
+
+import (
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gorilla/securecookie"
+	"github.com/taise-hub/shellgame-cli/server/interfaces"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// storeでidを保存したセッションのクッキーを返す。
+func saveTestSession(t *testing.T, store *interfaces.KVSSessionStore, id string) *http.Cookie {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/profiles", nil)
+	sess, _ := store.Get(req, interfaces.SESS_NAME)
+	sess.Values["id"] = id
+	rec := httptest.NewRecorder()
+	if err := store.Save(req, rec, sess); err != nil {
+		t.Fatalf("Save(): %v", err)
+	}
+	return rec.Result().Cookies()[0]
+}
+
+// cookieを付けたリクエストのセッションからidを取り出す。
+func loadTestSession(store *interfaces.KVSSessionStore, cookie *http.Cookie) any {
+	req := httptest.NewRequest("GET", "/profiles", nil)
+	req.AddCookie(cookie)
+	sess, _ := store.Get(req, interfaces.SESS_NAME)
+	return sess.Values["id"]
+}
+
+func TestKVSSessionStore(t *testing.T) {
+	handlers := map[string]func(t *testing.T) interfaces.StringHandler{
+		"memory": func(t *testing.T) interfaces.StringHandler { return NewMemoryHandler() },
+		"redis":  func(t *testing.T) interfaces.StringHandler { return newTestRedisHandler(t) },
+	}
+	oldKey, newKey := securecookie.GenerateRandomKey(64), securecookie.GenerateRandomKey(64)
+
+	for hName, newHandler := range handlers {
+		t.Run(hName+"/保存したセッションを別のサーバでも取得できる。", func(t *testing.T) {
+			h := newHandler(t)
+			cookie := saveTestSession(t, interfaces.NewKVSSessionStore(h, 60, newKey), "1")
+			actual := loadTestSession(interfaces.NewKVSSessionStore(h, 60, newKey), cookie)
+			if actual != "1" {
+				t.Errorf("Expected: %v\n\t\t Actual: %v \n", "1", actual)
+			}
+		})
+		t.Run(hName+"/ローテーション前の鍵で発行したクッキーも検証できる。", func(t *testing.T) {
+			h := newHandler(t)
+			cookie := saveTestSession(t, interfaces.NewKVSSessionStore(h, 60, oldKey), "1")
+			actual := loadTestSession(interfaces.NewKVSSessionStore(h, 60, newKey, nil, oldKey, nil), cookie)
+			if actual != "1" {
+				t.Errorf("Expected: %v\n\t\t Actual: %v \n", "1", actual)
+			}
+		})
+		t.Run(hName+"/破棄された鍵で発行したクッキーは無効になる。", func(t *testing.T) {
+			h := newHandler(t)
+			cookie := saveTestSession(t, interfaces.NewKVSSessionStore(h, 60, oldKey), "1")
+			actual := loadTestSession(interfaces.NewKVSSessionStore(h, 60, newKey), cookie)
+			if actual != nil {
+				t.Errorf("Expected: %v\n\t\t Actual: %v \n", nil, actual)
+			}
+		})
+		t.Run(hName+"/MaxAgeを負にして保存するとセッションは削除される。", func(t *testing.T) {
+			store := interfaces.NewKVSSessionStore(newHandler(t), 60, newKey)
+			cookie := saveTestSession(t, store, "1")
+			req := httptest.NewRequest("DELETE", "/profiles", nil)
+			req.AddCookie(cookie)
+			sess, _ := store.Get(req, interfaces.SESS_NAME)
+			sess.Options.MaxAge = -1
+			if err := store.Save(req, httptest.NewRecorder(), sess); err != nil {
+				t.Fatalf("Save(): %v", err)
+			}
+			if actual := loadTestSession(store, cookie); actual != nil {
+				t.Errorf("Expected: %v\n\t\t Actual: %v \n", nil, actual)
+			}
+		})
+	}
+
+	t.Run("redis/有効期限が切れたセッションは取得できない。", func(t *testing.T) {
+		s := miniredis.RunT(t)
+		h, err := NewRedisHandler(s.Addr())
+		if err != nil {
+			t.Fatalf("NewRedisHandler(): %v", err)
+		}
+		defer h.Close()
+		store := interfaces.NewKVSSessionStore(h, 60, newKey)
+		cookie := saveTestSession(t, store, "1")
+		s.FastForward(61 * time.Second)
+		if actual := loadTestSession(store, cookie); actual != nil {
+			t.Errorf("Expected: %v\n\t\t Actual: %v \n", nil, actual)
+		}
+	})
+}
@@ -0,0 +1,84 @@
+package interfaces
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/taise-hub/shellgame-cli/server/domain/repository"
+	"time"
+)
+
+const (
+	ACCOUNT_KEY         = "shellgame:account"         // プレイヤー名 → アカウント
+	LOGIN_CHALLENGE_KEY = "shellgame:login-challenge" // チャレンジ → プレイヤー名
+	CHALLENGE_TIMEOUT   = time.Minute
+)
+
+type AccountRepository struct {
+	HashHandler
+}
+
+func NewAccountRepository(hh HashHandler) repository.AccountRepository {
+	return &AccountRepository{hh}
+}
+
+func (rep *AccountRepository) Create(account *repository.Account) error {
+	b, err := json.Marshal(account)
+	if err != nil {
+		return err
+	}
+	ok, err := rep.HSetNX(context.Background(), ACCOUNT_KEY, account.Name, string(b))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return repository.ErrNameTaken
+	}
+	return nil
+}
+
+func (rep *AccountRepository) FindByName(name string) (*repository.Account, error) {
+	v, ok, err := rep.HGet(context.Background(), ACCOUNT_KEY, name)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, repository.ErrAccountNotFound
+	}
+	account := &repository.Account{}
+	if err := json.Unmarshal([]byte(v), account); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+type ChallengeRepository struct {
+	StringHandler
+}
+
+func NewChallengeRepository(sh StringHandler) repository.ChallengeRepository {
+	return &ChallengeRepository{sh}
+}
+
+// 発行から CHALLENGE_TIMEOUT 経過したチャレンジは利用できない。
+func (rep *ChallengeRepository) Save(challenge string, name string) error {
+	return rep.SetEX(context.Background(), challengeKey(challenge), name, CHALLENGE_TIMEOUT)
+}
+
+func (rep *ChallengeRepository) Take(challenge string) (string, error) {
+	ctx := context.Background()
+	v, ok, err := rep.Get(ctx, challengeKey(challenge))
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", repository.ErrChallengeNotFound
+	}
+	if err := rep.Del(ctx, challengeKey(challenge)); err != nil {
+		return "", err
+	}
+	return v, nil
+}
+
+func challengeKey(challenge string) string {
+	return LOGIN_CHALLENGE_KEY + ":" + challenge
+}
@@ -0,0 +1,128 @@
+package interfaces
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/gorilla/sessions"
+	"github.com/taise-hub/shellgame-cli/common"
+	"github.com/taise-hub/shellgame-cli/server/usecase"
+	"net/http"
+)
+
+type AccountController struct {
+	usecase        *usecase.AccountInteractor
+	profileUsecase *usecase.ProfileInteractor
+	store          sessions.Store
+}
+
+func NewAccountController(usecase *usecase.AccountInteractor, profileUsecase *usecase.ProfileInteractor, store sessions.Store) *AccountController {
+	return &AccountController{
+		usecase:        usecase,
+		profileUsecase: profileUsecase,
+		store:          store,
+	}
+}
+
+type accountRequest struct {
+	Name      string `json:"name"`
+	Password  string `json:"password,omitempty"`
+	PublicKey string `json:"public_key,omitempty"` // authorized_keys形式
+	Challenge string `json:"challenge,omitempty"`  // 署名したチャレンジ。Challenge()で発行されたものをそのまま送る。
+	Signature string `json:"signature,omitempty"`  // ssh.Marshalでエンコードした署名をbase64でエンコードしたもの
+}
+
+type challengeResponse struct {
+	Challenge string `json:"challenge"`
+}
+
+// POSTでアカウントを登録し、そのままログインする。
+func (con *AccountController) Accounts(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		http.NotFound(w, req)
+		return
+	}
+	body := &accountRequest{}
+	if err := decodeAccountRequest(req, body); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	profile, err := con.usecase.Register(body.Name, body.Password, body.PublicKey)
+	if err != nil {
+		http.Error(w, err.Error(), profileErrorStatus(err))
+		return
+	}
+	con.startSession(w, req, profile)
+}
+
+// POSTでパスワード、またはチャレンジに対する署名でログインする。
+func (con *AccountController) Login(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		http.NotFound(w, req)
+		return
+	}
+	body := &accountRequest{}
+	if err := decodeAccountRequest(req, body); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	var (
+		profile *common.Profile
+		err     error
+	)
+	if body.Signature != "" {
+		sig, decodeErr := base64.StdEncoding.DecodeString(body.Signature)
+		if decodeErr != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		profile, err = con.usecase.LoginWithSignature(body.Name, body.Challenge, sig)
+	} else {
+		profile, err = con.usecase.LoginWithPassword(body.Name, body.Password)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), profileErrorStatus(err))
+		return
+	}
+	con.startSession(w, req, profile)
+}
+
+// POSTでSSH公開鍵認証に利用するチャレンジを発行する。
+func (con *AccountController) Challenge(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		http.NotFound(w, req)
+		return
+	}
+	body := &accountRequest{}
+	if err := decodeAccountRequest(req, body); err != nil || body.Name == "" {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	challenge, err := con.usecase.IssueChallenge(body.Name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	RespondJSON(w, &challengeResponse{Challenge: challenge}, 200)
+}
+
+// ゲストとして登録していた場合は、そのプロフィールを削除してアカウントのプロフィールに切り替える。
+// 別のアカウントでログインしていた場合、そのアカウントのプロフィールは削除しない。
+func (con *AccountController) startSession(w http.ResponseWriter, req *http.Request, profile *common.Profile) {
+	sess, _ := con.store.Get(req, SESS_NAME)
+	if id, ok := sess.Values["id"].(string); ok && id != profile.ID {
+		con.profileUsecase.DeleteGuest(id)
+	}
+	sess.Values["id"] = profile.ID
+	sess.Values["name"] = profile.Name
+	sess.Values["rating"] = profile.Rating
+	if err := con.store.Save(req, w, sess); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	RespondJSON(w, profile, 200)
+}
+
+func decodeAccountRequest(req *http.Request, body *accountRequest) error {
+	defer req.Body.Close()
+	return json.NewDecoder(req.Body).Decode(body)
+}
@@ -5,9 +5,11 @@ import (
 	"github.com/google/uuid"
 	"github.com/taise-hub/shellgame-cli/server/domain/repository"
 	"net"
+	"sync"
 )
 
 type ContainerHandler interface {
+	Find(context.Context, string) (string, error) // 名前で指定したコンテナのIDを返す。存在しない場合は空文字列を返す。
 	Create(context.Context, string) (string, error)
 	Exec(context.Context, string, []string) (net.Conn, error)
 	Start(context.Context, string) error
@@ -17,10 +19,12 @@ type ContainerHandler interface {
 
 type ContainerRepository struct {
 	ContainerHandler
+	shared map[string]bool // 作成済みの共有コンテナ
+	mu     sync.Mutex
 }
 
 func NewContainerRepository(ch ContainerHandler) repository.ConsoleRepository {
-	return &ContainerRepository{ch}
+	return &ContainerRepository{ContainerHandler: ch, shared: make(map[string]bool)}
 }
 
 func (rep *ContainerRepository) StartShell() (net.Conn, error) {
@@ -39,7 +43,73 @@ func (rep *ContainerRepository) StartShell() (net.Conn, error) {
 	return rep.Exec(ctx, name.String(), []string{"/bin/sh"})
 }
 
-// 10分以上残ってるゲーム用コンテナをストップして、削除する。
+// チームメイトが同じコンテナで対戦できるように、nameという名前のコンテナを一度だけ作成し、以降は同じコンテナでシェルを起動する。
+// 他のサーバが作成したコンテナが残っている場合は、作成せずにそのコンテナを利用する。
+func (rep *ContainerRepository) JoinShell(name string) (net.Conn, error) {
+	ctx := context.Background()
+	rep.mu.Lock()
+	if !rep.shared[name] {
+		id, err := rep.findOrCreate(ctx, name)
+		if err != nil {
+			rep.mu.Unlock()
+			return nil, err
+		}
+		if err = rep.Start(ctx, id); err != nil {
+			rep.mu.Unlock()
+			return nil, err
+		}
+		rep.shared[name] = true
+	}
+	rep.mu.Unlock()
+	return rep.Exec(ctx, name, []string{"/bin/sh"})
+}
+
+// 他のサーバと同時に作成しようとして名前が衝突した場合も、先に作成されたコンテナを利用する。
+func (rep *ContainerRepository) findOrCreate(ctx context.Context, name string) (string, error) {
+	if id, err := rep.Find(ctx, name); err != nil || id != "" {
+		return id, err
+	}
+	id, err := rep.Create(ctx, name)
+	if err != nil {
+		if found, ferr := rep.Find(ctx, name); ferr == nil && found != "" {
+			return found, nil
+		}
+		return "", err
+	}
+	return id, nil
+}
+
+// このサーバで利用したnameという名前のコンテナを停止する。コンテナは停止すると削除される。
+// 他のサーバが既に削除していた場合は何もしない。
+func (rep *ContainerRepository) RemoveShell(name string) error {
+	rep.mu.Lock()
+	if !rep.shared[name] {
+		rep.mu.Unlock()
+		return nil
+	}
+	delete(rep.shared, name)
+	rep.mu.Unlock()
+	ctx := context.Background()
+	id, err := rep.Find(ctx, name)
+	if err != nil || id == "" {
+		return err
+	}
+	return rep.Stop(ctx, id)
+}
+
+// このサーバで利用した共有コンテナを全て停止して、削除する。
 func (rep *ContainerRepository) CleanUp() error {
-	panic("implement me")
+	rep.mu.Lock()
+	var names []string
+	for name := range rep.shared {
+		names = append(names, name)
+	}
+	rep.mu.Unlock()
+	var err error
+	for _, name := range names {
+		if rerr := rep.RemoveShell(name); rerr != nil {
+			err = rerr
+		}
+	}
+	return err
 }
@@ -0,0 +1,109 @@
+package interfaces
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+)
+
+// fakeContainerHandlerはテスト用のContainerHandlerの実装
+// 全てのサーバが同じDockerデーモンを利用している状態を想定し、複数のContainerRepositoryで共有する。
+type fakeContainerHandler struct {
+	mu         sync.Mutex
+	containers map[string]bool // 存在するコンテナの名前
+	created    int
+}
+
+func newFakeContainerHandler() *fakeContainerHandler {
+	return &fakeContainerHandler{containers: make(map[string]bool)}
+}
+
+func (h *fakeContainerHandler) Find(_ context.Context, name string) (string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.containers[name] {
+		return "", nil
+	}
+	return name, nil
+}
+
+func (h *fakeContainerHandler) Create(_ context.Context, name string) (string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.containers[name] {
+		return "", errors.New("conflict: the container name is already in use")
+	}
+	h.containers[name] = true
+	h.created++
+	return name, nil
+}
+
+func (h *fakeContainerHandler) Exec(context.Context, string, []string) (net.Conn, error) {
+	server, client := net.Pipe()
+	server.Close()
+	return client, nil
+}
+
+func (h *fakeContainerHandler) Start(context.Context, string) error {
+	return nil
+}
+
+// 停止したコンテナは自動で削除される。
+func (h *fakeContainerHandler) Stop(_ context.Context, id string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.containers[id] {
+		return errors.New("no such container")
+	}
+	delete(h.containers, id)
+	return nil
+}
+
+func (h *fakeContainerHandler) Remove(context.Context, string) error {
+	return nil
+}
+
+func (h *fakeContainerHandler) exists(name string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.containers[name]
+}
+
+func TestContainerRepositoryShell(t *testing.T) {
+	handler := newFakeContainerHandler()
+	repA := NewContainerRepository(handler)
+	repB := NewContainerRepository(handler)
+
+	t.Run("他のサーバが作成したコンテナがあれば、作成せずにそのコンテナを利用する。", func(t *testing.T) {
+		if _, err := repA.JoinShell("battle-player-1"); err != nil {
+			t.Fatalf("Expected: %v\n\t\t Actual: %v \n", nil, err)
+		}
+		if _, err := repB.JoinShell("battle-player-1"); err != nil {
+			t.Errorf("Expected: %v\n\t\t Actual: %v \n", nil, err)
+		}
+		if handler.created != 1 {
+			t.Errorf("Expected: %d\n\t\t Actual: %d \n", 1, handler.created)
+		}
+	})
+	t.Run("利用したコンテナを削除でき、他のサーバが削除済みでも失敗しない。", func(t *testing.T) {
+		if err := repA.RemoveShell("battle-player-1"); err != nil {
+			t.Errorf("Expected: %v\n\t\t Actual: %v \n", nil, err)
+		}
+		if err := repB.RemoveShell("battle-player-1"); err != nil {
+			t.Errorf("Expected: %v\n\t\t Actual: %v \n", nil, err)
+		}
+		if handler.exists("battle-player-1") {
+			t.Errorf("Expected: %v\n\t\t Actual: %v \n", false, true)
+		}
+	})
+	t.Run("削除した後に同じ名前で利用すると、改めて作成する。", func(t *testing.T) {
+		if _, err := repA.JoinShell("battle-player-1"); err != nil {
+			t.Errorf("Expected: %v\n\t\t Actual: %v \n", nil, err)
+		}
+		if handler.created != 2 {
+			t.Errorf("Expected: %d\n\t\t Actual: %d \n", 2, handler.created)
+		}
+	})
+}
@@ -2,13 +2,15 @@ package interfaces
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
 	"github.com/gorilla/websocket"
+	"github.com/taise-hub/shellgame-cli/common"
 	"github.com/taise-hub/shellgame-cli/server/domain/model"
 	"github.com/taise-hub/shellgame-cli/server/usecase"
 	"net/http"
+	"strings"
 )
 
 const (
@@ -20,99 +22,156 @@ var (
 		ReadBufferSize:  1024,
 		WriteBufferSize: 1024,
 	}
-	store = sessions.NewCookieStore(securecookie.GenerateRandomKey(32))
 )
 
 type GameController struct {
 	usecase *usecase.GameInteractor
+	store   sessions.Store
 }
 
-func NewGameController(usecase *usecase.GameInteractor) *GameController {
+func NewGameController(usecase *usecase.GameInteractor, store sessions.Store) *GameController {
 	return &GameController{
 		usecase: usecase,
+		store:   store,
 	}
 }
 
 // 対戦開始時にクライアントから呼び出される予定
 // websocketを用いてクライアントをシェルに接続する
+// ?battle=で対戦を指定した場合、チームメイトでコンテナを共有する対戦ではチームのコンテナに接続する。
 func (con *GameController) Start(w http.ResponseWriter, req *http.Request) {
-	conn, err := upgrader.Upgrade(w, req, nil)
-	defer conn.Close()
+	sess, _ := con.store.Get(req, SESS_NAME)
+	id, _ := sess.Values["id"].(string)
+	conn, err := upgrade(w, req)
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
-	if err = con.usecase.Start(conn.UnderlyingConn()); err != nil {
+	defer conn.Close()
+	if err = con.usecase.Start(conn.UnderlyingConn(), req.URL.Query().Get("battle"), id); err != nil {
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 }
 
-func (con *GameController) Profile(w http.ResponseWriter, req *http.Request) {
+func (con *GameController) Match(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
-	case "POST":
-		con.saveProfile(w, req)
+	case "GET":
+		con.getMatchingPlayers(w, req)
 	default:
 		http.NotFound(w, req)
 	}
 }
 
-func (con *GameController) saveProfile(w http.ResponseWriter, req *http.Request) {
-	sess, _ := store.Get(req, SESS_NAME)
-	b := make([]byte, req.ContentLength)
-	req.Body.Read(b)
-	defer req.Body.Close()
-	json, err := ParseJSON(b)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	id := json["id"].(string)
-	name := json["name"].(string)
-	if name == "" {
+// ロビーにいるプレイヤーのプロフィールと状態、参加した時刻を返す。
+func (con *GameController) getMatchingPlayers(w http.ResponseWriter, req *http.Request) {
+	sess, _ := con.store.Get(req, SESS_NAME)
+	if sess.Values["id"] == nil {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		w.Header().Set("X-Content-Type-Options", "nosniff")
 		w.WriteHeader(400)
 		fmt.Fprintln(w, "400 bad reuqest")
 		return
 	}
-	sess.Values["id"] = id
-	sess.Values["name"] = name
-	if err := store.Save(req, w, sess); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	players, err := con.usecase.ExtractMatchingPlayers(roomName(req), sess.Values["id"].(string))
+	if err != nil {
+		http.NotFound(w, req)
 		return
 	}
+	RespondJSON(w, players, 200)
 }
 
-func (con *GameController) Match(w http.ResponseWriter, req *http.Request) {
+// ?id=で指定された対戦の参加者とチームごとの得点を返す。対戦の参加者のみ取得できる。
+func (con *GameController) Battle(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		http.NotFound(w, req)
+		return
+	}
+	sess, _ := con.store.Get(req, SESS_NAME)
+	id, ok := sess.Values["id"].(string)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	battle, err := con.usecase.GetBattle(req.URL.Query().Get("id"))
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	} else if battle.TeamOf(id) < 0 {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	RespondJSON(w, battle.Summary(), 200)
+}
+
+// ?id=で指定された対戦の対戦チャンネルにwebsocketで接続する。対戦の参加者のみ接続できる。
+func (con *GameController) BattleChannel(w http.ResponseWriter, req *http.Request) {
+	sess, _ := con.store.Get(req, SESS_NAME)
+	id, ok := sess.Values["id"].(string)
+	name, _ := sess.Values["name"].(string)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	battleID := req.URL.Query().Get("id")
+	battle, err := con.usecase.GetBattle(battleID)
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	} else if battle.TeamOf(id) < 0 {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	conn, err := upgrade(w, req) //NOTE: WaitMatchと同様に、このコネクションはdomain層で閉じる。
+	if err != nil {
+		return
+	}
+	wc := NewWebsocketConn(conn)
+	if err := con.usecase.JoinBattle(battleID, model.NewMatchingPlayer(id, name, wc)); err != nil {
+		wc.Close()
+	}
+}
+
+func (con *GameController) Rooms(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case "GET":
-		con.getMatchingProfiles(w, req)
+		RespondJSON(w, con.usecase.GetRooms(), 200)
 	default:
 		http.NotFound(w, req)
 	}
 }
 
-// MatchingPlayerのProfileを返すようにしたい。
-func (con *GameController) getMatchingProfiles(w http.ResponseWriter, req *http.Request) {
-	sess, _ := store.Get(req, SESS_NAME)
-	if sess.Values["id"] == nil {
-		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-		w.Header().Set("X-Content-Type-Options", "nosniff")
-		w.WriteHeader(400)
-		fmt.Fprintln(w, "400 bad reuqest")
+// プライベートルームを作成し、招待コードを返す。作成したプレイヤーがホストとなる。
+func (con *GameController) PrivateRooms(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		http.NotFound(w, req)
 		return
 	}
-	players := con.usecase.ExtractMatchingProfiles(sess.Values["id"].(string))
-	RespondJSON(w, players, 200)
+	sess, _ := con.store.Get(req, SESS_NAME)
+	id, ok := sess.Values["id"].(string)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	defer req.Body.Close()
+	body := &common.PrivateRoom{}
+	if err := json.NewDecoder(req.Body).Decode(body); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	room, err := con.usecase.CreatePrivateRoom(id, body.QuestionSet)
+	if errors.Is(err, usecase.ErrUnknownQuestionSet) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	RespondJSON(w, room, 201)
 }
 
+// ?room=で指定されたマッチングルーム、または?code=で指定されたプライベートルームで対戦待ちを行う。
 func (con *GameController) WaitMatch(w http.ResponseWriter, req *http.Request) {
-	conn, err := upgrader.Upgrade(w, req, nil) //NOTE: このコネクションはdomain層で利用しているためはあえて閉じてない。(domain層で閉じてる)
-	if err != nil {
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		return
-	}
-	sess, _ := store.Get(req, SESS_NAME)
+	sess, _ := con.store.Get(req, SESS_NAME)
 	if sess.Values["id"] == nil || sess.Values["name"] == nil {
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		w.Header().Set("X-Content-Type-Options", "nosniff")
@@ -120,9 +179,56 @@ func (con *GameController) WaitMatch(w http.ResponseWriter, req *http.Request) {
 		fmt.Fprintln(w, "400 bad reuqest")
 		return
 	}
+	room := roomName(req)
+	if !con.usecase.HasRoom(room) {
+		http.NotFound(w, req)
+		return
+	}
+	conn, err := upgrade(w, req) //NOTE: このコネクションはdomain層で利用しているためはあえて閉じてない。(domain層で閉じてる)
+	if err != nil {
+		return
+	}
 	wc := NewWebsocketConn(conn)
 	player := model.NewMatchingPlayer(sess.Values["id"].(string), sess.Values["name"].(string), wc)
-	con.usecase.WaitMatch(player)
+	player.Profile.Rating = model.DEFAULT_RATING
+	if rating, ok := sess.Values["rating"].(int); ok {
+		player.Profile.Rating = rating
+	}
+	if err := con.usecase.WaitMatch(room, player); err != nil {
+		wc.Close()
+	}
+}
+
+// クライアントが提示したプロトコルのバージョンを確認してからwebsocketにアップグレードする。
+// 対応していないバージョンの場合は、理由を含むREJECTを本文として426を返す。エラーの応答は送信済みとなる。
+func upgrade(w http.ResponseWriter, req *http.Request) (*websocket.Conn, error) {
+	version, rejection := common.Negotiate(websocket.Subprotocols(req))
+	if rejection != nil {
+		env, err := common.Wrap(rejection)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return nil, err
+		}
+		RespondJSON(w, env, http.StatusUpgradeRequired)
+		return nil, rejection
+	}
+	return upgrader.Upgrade(w, req, http.Header{"Sec-Websocket-Protocol": {common.Subprotocol(version)}})
+}
+
+// 招待コードが指定された場合はプライベートルームを、指定がない場合はデフォルトのマッチングルームを利用する。
+// プライベートルームは招待コードでのみ指定でき、ルーム名では指定できない。
+func roomName(req *http.Request) string {
+	if code := req.URL.Query().Get("code"); code != "" {
+		return usecase.PrivateRoomName(code)
+	}
+	room := req.URL.Query().Get("room")
+	if strings.HasPrefix(room, usecase.PRIVATE_ROOM_PREFIX) {
+		return ""
+	}
+	if room == "" {
+		return usecase.DEFAULT_ROOM
+	}
+	return room
 }
 
 func ParseJSON(data []byte) (map[string]any, error) {
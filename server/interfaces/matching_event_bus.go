@@ -0,0 +1,64 @@
+package interfaces
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/taise-hub/shellgame-cli/common"
+	"github.com/taise-hub/shellgame-cli/server/domain/repository"
+	"log"
+)
+
+const (
+	MATCHING_EVENT_CHANNEL = "shellgame:matching-event"
+)
+
+// Pub/Subを提供するメッセージブローカ(Redis等)の操作
+type PubSubHandler interface {
+	Publish(context.Context, string, []byte) error
+	Subscribe(context.Context, string) (<-chan []byte, error)
+}
+
+type MatchingEventBus struct {
+	PubSubHandler
+}
+
+func NewMatchingEventBus(ph PubSubHandler) repository.MatchingEventBus {
+	return &MatchingEventBus{ph}
+}
+
+func (bus *MatchingEventBus) Publish(room string, msg *common.MatchingMessage) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return bus.PubSubHandler.Publish(context.Background(), matchingEventChannel(room), b)
+}
+
+// ctxが終了するまでroomのイベントを受信し続ける。
+func (bus *MatchingEventBus) Subscribe(ctx context.Context, room string) (<-chan *common.MatchingMessage, error) {
+	payloads, err := bus.PubSubHandler.Subscribe(ctx, matchingEventChannel(room))
+	if err != nil {
+		return nil, err
+	}
+	events := make(chan *common.MatchingMessage)
+	go func() {
+		defer close(events)
+		for b := range payloads {
+			msg := &common.MatchingMessage{}
+			if err := json.Unmarshal(b, msg); err != nil {
+				log.Printf("Error in MatchingEventBus.Subscribe(): %v\n", err)
+				continue
+			}
+			select {
+			case events <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+func matchingEventChannel(room string) string {
+	return MATCHING_EVENT_CHANNEL + ":" + room
+}
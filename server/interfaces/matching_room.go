@@ -0,0 +1,42 @@
+package interfaces
+
+import (
+	"context"
+	"github.com/taise-hub/shellgame-cli/server/domain/repository"
+)
+
+const (
+	MATCHING_ROOM_KEY = "shellgame:matching-room"
+)
+
+// 集合型の値を扱うKVS(Redis等)の操作
+type SetHandler interface {
+	SAdd(context.Context, string, string) error
+	SMembers(context.Context, string) ([]string, error)
+	SRem(context.Context, string, string) error
+}
+
+type MatchingRoomRepository struct {
+	SetHandler
+}
+
+func NewMatchingRoomRepository(sh SetHandler) repository.MatchingRoomRepository {
+	return &MatchingRoomRepository{sh}
+}
+
+// roomで対戦待ちのプレイヤーのIDを全て取得する。
+func (rep *MatchingRoomRepository) GetAll(room string) ([]string, error) {
+	return rep.SMembers(context.Background(), matchingRoomKey(room))
+}
+
+func (rep *MatchingRoomRepository) SetID(room string, id string) error {
+	return rep.SAdd(context.Background(), matchingRoomKey(room), id)
+}
+
+func (rep *MatchingRoomRepository) RemoveID(room string, id string) error {
+	return rep.SRem(context.Background(), matchingRoomKey(room), id)
+}
+
+func matchingRoomKey(room string) string {
+	return MATCHING_ROOM_KEY + ":" + room
+}
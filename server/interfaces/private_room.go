@@ -0,0 +1,49 @@
+package interfaces
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/taise-hub/shellgame-cli/server/domain/repository"
+)
+
+const (
+	PRIVATE_ROOM_KEY = "shellgame:private-room" // 招待コード → プライベートルーム
+)
+
+type PrivateRoomRepository struct {
+	HashHandler
+}
+
+func NewPrivateRoomRepository(hh HashHandler) repository.PrivateRoomRepository {
+	return &PrivateRoomRepository{hh}
+}
+
+func (rep *PrivateRoomRepository) Create(room *repository.PrivateRoom) error {
+	b, err := json.Marshal(room)
+	if err != nil {
+		return err
+	}
+	ok, err := rep.HSetNX(context.Background(), PRIVATE_ROOM_KEY, room.Code, string(b))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return repository.ErrCodeTaken
+	}
+	return nil
+}
+
+func (rep *PrivateRoomRepository) Find(code string) (*repository.PrivateRoom, error) {
+	v, ok, err := rep.HGet(context.Background(), PRIVATE_ROOM_KEY, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, repository.ErrPrivateRoomNotFound
+	}
+	room := &repository.PrivateRoom{}
+	if err := json.Unmarshal([]byte(v), room); err != nil {
+		return nil, err
+	}
+	return room, nil
+}
@@ -0,0 +1,109 @@
+package interfaces
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/taise-hub/shellgame-cli/common"
+	"github.com/taise-hub/shellgame-cli/server/domain/repository"
+)
+
+const (
+	PROFILE_KEY      = "shellgame:profile"      // ID → プロフィール
+	PROFILE_NAME_KEY = "shellgame:profile-name" // プレイヤー名 → ID
+)
+
+// ハッシュ型の値を扱うKVS(Redis等)の操作
+type HashHandler interface {
+	HSetNX(context.Context, string, string, string) (bool, error)
+	HSet(context.Context, string, string, string) error
+	HGet(context.Context, string, string) (string, bool, error)
+	HDel(context.Context, string, string) error
+}
+
+type ProfileRepository struct {
+	HashHandler
+}
+
+func NewProfileRepository(hh HashHandler) repository.ProfileRepository {
+	return &ProfileRepository{hh}
+}
+
+// プレイヤー名を先に確保することで、複数のサーバから同時に登録された場合も一意性を保つ。
+func (rep *ProfileRepository) Create(profile *common.Profile) error {
+	ctx := context.Background()
+	ok, err := rep.HSetNX(ctx, PROFILE_NAME_KEY, profile.Name, profile.ID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return repository.ErrNameTaken
+	}
+	if err := rep.save(ctx, profile); err != nil {
+		rep.HDel(ctx, PROFILE_NAME_KEY, profile.Name)
+		return err
+	}
+	return nil
+}
+
+func (rep *ProfileRepository) Find(id string) (*common.Profile, error) {
+	v, ok, err := rep.HGet(context.Background(), PROFILE_KEY, id)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, repository.ErrProfileNotFound
+	}
+	profile := &common.Profile{}
+	if err := json.Unmarshal([]byte(v), profile); err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+func (rep *ProfileRepository) Rename(id string, name string) (*common.Profile, error) {
+	ctx := context.Background()
+	profile, err := rep.Find(id)
+	if err != nil {
+		return nil, err
+	}
+	if profile.Name == name {
+		return profile, nil
+	}
+	ok, err := rep.HSetNX(ctx, PROFILE_NAME_KEY, name, id)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, repository.ErrNameTaken
+	}
+	old := profile.Name
+	profile.Name = name
+	if err := rep.save(ctx, profile); err != nil {
+		rep.HDel(ctx, PROFILE_NAME_KEY, name)
+		return nil, err
+	}
+	if err := rep.HDel(ctx, PROFILE_NAME_KEY, old); err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+func (rep *ProfileRepository) Delete(id string) error {
+	ctx := context.Background()
+	profile, err := rep.Find(id)
+	if err != nil {
+		return err
+	}
+	if err := rep.HDel(ctx, PROFILE_KEY, id); err != nil {
+		return err
+	}
+	return rep.HDel(ctx, PROFILE_NAME_KEY, profile.Name)
+}
+
+func (rep *ProfileRepository) save(ctx context.Context, profile *common.Profile) error {
+	b, err := json.Marshal(profile)
+	if err != nil {
+		return err
+	}
+	return rep.HSet(ctx, PROFILE_KEY, profile.ID, string(b))
+}
@@ -0,0 +1,149 @@
+package interfaces
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/sessions"
+	"github.com/taise-hub/shellgame-cli/server/domain/model"
+	"github.com/taise-hub/shellgame-cli/server/domain/repository"
+	"github.com/taise-hub/shellgame-cli/server/usecase"
+	"net/http"
+)
+
+type ProfileController struct {
+	usecase *usecase.ProfileInteractor
+	store   sessions.Store
+}
+
+func NewProfileController(usecase *usecase.ProfileInteractor, store sessions.Store) *ProfileController {
+	return &ProfileController{
+		usecase: usecase,
+		store:   store,
+	}
+}
+
+// POSTで登録、GETで取得、PUTでプレイヤー名の変更、DELETEでログアウトを行う。
+func (con *ProfileController) Profile(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case "GET":
+		con.getProfile(w, req)
+	case "POST":
+		con.createProfile(w, req)
+	case "PUT":
+		con.renameProfile(w, req)
+	case "DELETE":
+		con.deleteProfile(w, req)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+type profileRequest struct {
+	Name string `json:"name"`
+}
+
+func (con *ProfileController) createProfile(w http.ResponseWriter, req *http.Request) {
+	sess, _ := con.store.Get(req, SESS_NAME)
+	defer req.Body.Close()
+	body := &profileRequest{}
+	if err := json.NewDecoder(req.Body).Decode(body); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	profile, err := con.usecase.Create(body.Name)
+	if err != nil {
+		http.Error(w, err.Error(), profileErrorStatus(err))
+		return
+	}
+	// 登録し直した場合は以前のプロフィールを削除する。アカウントのプロフィールは削除しない。
+	if id, ok := sess.Values["id"].(string); ok {
+		con.usecase.DeleteGuest(id)
+	}
+	sess.Values["id"] = profile.ID
+	sess.Values["name"] = profile.Name
+	sess.Values["rating"] = profile.Rating
+	if err := con.store.Save(req, w, sess); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	RespondJSON(w, profile, 200)
+}
+
+func (con *ProfileController) getProfile(w http.ResponseWriter, req *http.Request) {
+	sess, _ := con.store.Get(req, SESS_NAME)
+	id, ok := sess.Values["id"].(string)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	profile, err := con.usecase.Find(id)
+	if err != nil {
+		http.Error(w, err.Error(), profileErrorStatus(err))
+		return
+	}
+	RespondJSON(w, profile, 200)
+}
+
+func (con *ProfileController) renameProfile(w http.ResponseWriter, req *http.Request) {
+	sess, _ := con.store.Get(req, SESS_NAME)
+	id, ok := sess.Values["id"].(string)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	defer req.Body.Close()
+	body := &profileRequest{}
+	if err := json.NewDecoder(req.Body).Decode(body); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	profile, err := con.usecase.Rename(id, body.Name)
+	if err != nil {
+		http.Error(w, err.Error(), profileErrorStatus(err))
+		return
+	}
+	sess.Values["name"] = profile.Name
+	if err := con.store.Save(req, w, sess); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	RespondJSON(w, profile, 200)
+}
+
+// ゲストのプロフィールは削除する。アカウントのプロフィールは同じアカウントでログインしている他のセッションでも利用しているため、
+// このセッションを破棄するのみとする。
+func (con *ProfileController) deleteProfile(w http.ResponseWriter, req *http.Request) {
+	sess, _ := con.store.Get(req, SESS_NAME)
+	id, ok := sess.Values["id"].(string)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if err := con.usecase.DeleteGuest(id); err != nil {
+		http.Error(w, err.Error(), profileErrorStatus(err))
+		return
+	}
+	sess.Options.MaxAge = -1
+	if err := con.store.Save(req, w, sess); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func profileErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, model.ErrEmptyName), errors.Is(err, model.ErrNameTooLong), errors.Is(err, model.ErrInvalidNameChar),
+		errors.Is(err, model.ErrPasswordTooShort), errors.Is(err, model.ErrPasswordTooLong),
+		errors.Is(err, model.ErrInvalidPublicKey), errors.Is(err, model.ErrNoCredential):
+		return http.StatusBadRequest
+	case errors.Is(err, model.ErrAuthenticationFailed):
+		return http.StatusUnauthorized
+	case errors.Is(err, repository.ErrNameTaken), errors.Is(err, usecase.ErrAccountRename):
+		return http.StatusConflict
+	case errors.Is(err, repository.ErrProfileNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,112 @@
+package interfaces
+
+import (
+	"context"
+	"encoding/base32"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	SESSION_KEY = "shellgame:session"
+)
+
+// 文字列型の値を有効期限付きで扱うKVS(Redis等)の操作
+type StringHandler interface {
+	SetEX(context.Context, string, string, time.Duration) error
+	Get(context.Context, string) (string, bool, error)
+	Del(context.Context, string) error
+}
+
+// KVSSessionStoreはセッションの値をKVSに保存するsessions.Storeの実装
+// クッキーには署名・暗号化したセッションIDのみを保持するため、同じKVSに接続している全てのサーバでセッションを共有できる。
+type KVSSessionStore struct {
+	StringHandler
+	Codecs  []securecookie.Codec
+	Options *sessions.Options
+}
+
+// keyPairsはsessions.NewCookieStoreと同じく、ハッシュキーとブロックキーを交互に指定する。
+// 先頭のペアで署名・暗号化を行い、残りのペアはローテーション前に発行されたクッキーの検証にのみ利用する。
+func NewKVSSessionStore(sh StringHandler, maxAge int, keyPairs ...[]byte) *KVSSessionStore {
+	s := &KVSSessionStore{
+		StringHandler: sh,
+		Codecs:        securecookie.CodecsFromPairs(keyPairs...),
+		Options: &sessions.Options{
+			Path:     "/",
+			MaxAge:   maxAge,
+			HttpOnly: true,
+		},
+	}
+	for _, c := range s.Codecs {
+		if codec, ok := c.(*securecookie.SecureCookie); ok {
+			codec.MaxAge(maxAge)
+		}
+	}
+	return s
+}
+
+func (s *KVSSessionStore) Get(req *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(req).Get(s, name)
+}
+
+// クッキーが無い場合や検証に失敗した場合、KVSに値が無い(期限切れ)場合は新しいセッションを返す。
+func (s *KVSSessionStore) New(req *http.Request, name string) (*sessions.Session, error) {
+	sess := sessions.NewSession(s, name)
+	opts := *s.Options
+	sess.Options = &opts
+	sess.IsNew = true
+	c, err := req.Cookie(name)
+	if err != nil {
+		return sess, nil
+	}
+	if err := securecookie.DecodeMulti(name, c.Value, &sess.ID, s.Codecs...); err != nil {
+		return sess, err
+	}
+	data, ok, err := s.StringHandler.Get(req.Context(), sessionKey(sess.ID))
+	if err != nil || !ok {
+		return sess, err
+	}
+	if err := securecookie.DecodeMulti(name, data, &sess.Values, s.Codecs...); err != nil {
+		return sess, err
+	}
+	sess.IsNew = false
+	return sess, nil
+}
+
+// MaxAgeが0以下の場合はKVSからセッションを削除し、クッキーも破棄する。
+func (s *KVSSessionStore) Save(req *http.Request, w http.ResponseWriter, sess *sessions.Session) error {
+	if sess.Options.MaxAge <= 0 {
+		if sess.ID != "" {
+			if err := s.Del(req.Context(), sessionKey(sess.ID)); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(sess.Name(), "", sess.Options))
+		return nil
+	}
+	if sess.ID == "" {
+		sess.ID = strings.TrimRight(base32.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32)), "=")
+	}
+	data, err := securecookie.EncodeMulti(sess.Name(), sess.Values, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	ttl := time.Duration(sess.Options.MaxAge) * time.Second
+	if err := s.SetEX(req.Context(), sessionKey(sess.ID), data, ttl); err != nil {
+		return err
+	}
+	encoded, err := securecookie.EncodeMulti(sess.Name(), sess.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(sess.Name(), encoded, sess.Options))
+	return nil
+}
+
+func sessionKey(id string) string {
+	return SESSION_KEY + ":" + id
+}
@@ -1,8 +1,12 @@
 package interfaces
 
 import (
+	"errors"
+	"fmt"
 	"github.com/gorilla/websocket"
 	"github.com/taise-hub/shellgame-cli/common"
+	"github.com/taise-hub/shellgame-cli/server/domain/model"
+	"net"
 	"sync"
 	"time"
 )
@@ -10,42 +14,107 @@ import (
 const (
 	writeWait      = 20 * time.Second
 	readWait       = 60 * time.Second
-	maxMessageSize = 512
+	pingPeriod     = readWait * 9 / 10 // readWaitが過ぎる前にPongが届くように、少し短い間隔でPingを送る。
+	maxMessageSize = 4096              // チャットの本文(最大200文字)を含むメッセージが収まる大きさ
 )
 
+// WebsocketConnはクライアントにPingを送り続け、PongかメッセージのいずれかがreadWaitの間届かなければ読み込みを打ち切る。
+// 読み込みを打ち切るとReadPumpが終了し、プレイヤーはマッチングルームから退室させられる。
 type WebsocketConn struct {
 	*websocket.Conn
-	muRead           sync.Mutex
-	muWrite          sync.Mutex
+	muRead     sync.Mutex
+	muWrite    sync.Mutex
+	version    int // ハンドシェイクで合意したプロトコルのバージョン
+	readWait   time.Duration
+	pingPeriod time.Duration
+	done       chan struct{}
+	once       sync.Once
 }
 
 func NewWebsocketConn(conn *websocket.Conn) *WebsocketConn {
+	return newWebsocketConn(conn, readWait, pingPeriod)
+}
+
+func newWebsocketConn(conn *websocket.Conn, readWait time.Duration, pingPeriod time.Duration) *WebsocketConn {
+	version, _ := common.Negotiate([]string{conn.Subprotocol()})
+	wc := &WebsocketConn{
+		Conn:       conn,
+		version:    version,
+		readWait:   readWait,
+		pingPeriod: pingPeriod,
+		done:       make(chan struct{}),
+	}
 	conn.SetReadLimit(maxMessageSize)
-	conn.SetWriteDeadline(time.Now().Add(writeWait))
-	conn.SetReadDeadline(time.Now().Add(readWait))
-	conn.SetPingHandler(func(string) error {
-		conn.SetReadDeadline(time.Now().Add(readWait))
-		conn.SetWriteDeadline(time.Now().Add(writeWait))
-		if err := conn.WriteMessage(websocket.PongMessage, nil); err != nil {
-			return err
-		}
+	wc.extendReadDeadline()
+	conn.SetPongHandler(func(string) error {
+		wc.extendReadDeadline()
 		return nil
 	})
-	return &WebsocketConn{conn, sync.Mutex{}, sync.Mutex{}}
+	// Pongの送信はWriteと並行して行われるため、WriteControlを利用する。
+	conn.SetPingHandler(func(data string) error {
+		wc.extendReadDeadline()
+		err := conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(writeWait))
+		if errors.Is(err, websocket.ErrCloseSent) {
+			return nil
+		}
+		return err
+	})
+	go wc.heartbeat()
+	return wc
+}
+
+func (wc *WebsocketConn) extendReadDeadline() {
+	wc.SetReadDeadline(time.Now().Add(wc.readWait))
+}
+
+// Closeされるまで一定間隔でPingを送る。送信できなかった場合は接続を閉じる。
+func (wc *WebsocketConn) heartbeat() {
+	ticker := time.NewTicker(wc.pingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-wc.done:
+			return
+		case <-ticker.C:
+			if err := wc.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				wc.Close()
+				return
+			}
+		}
+	}
 }
 
 func (wc *WebsocketConn) Close() error {
+	wc.once.Do(func() { close(wc.done) })
 	return wc.Conn.Close()
 }
 
+// Envelopeを受信し、msgに取り出す。
+// 期限までにPongもメッセージも届かなかった場合はmodel.ErrHeartbeatTimeoutを返す。
 func (wc *WebsocketConn) Read(msg common.Message) error {
-	defer wc.muRead.Unlock()
+	env := &common.Envelope{}
 	wc.muRead.Lock()
-	return wc.ReadJSON(msg)
+	err := wc.ReadJSON(env)
+	wc.muRead.Unlock()
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return fmt.Errorf("%w: %v", model.ErrHeartbeatTimeout, err)
+	} else if err != nil {
+		return err
+	}
+	wc.extendReadDeadline()
+	return env.Unwrap(msg)
 }
 
+// msgを合意したバージョンのEnvelopeに詰めて送信する。
 func (wc *WebsocketConn) Write(msg common.Message) error {
+	env, err := common.Wrap(msg)
+	if err != nil {
+		return err
+	}
+	env.Version = wc.version
 	defer wc.muWrite.Unlock()
 	wc.muWrite.Lock()
-	return wc.WriteJSON(msg)
+	wc.SetWriteDeadline(time.Now().Add(writeWait))
+	return wc.WriteJSON(env)
 }
@@ -0,0 +1,106 @@
+package interfaces
+
+import (
+	"errors"
+	"github.com/gorilla/websocket"
+	"github.com/taise-hub/shellgame-cli/common"
+	"github.com/taise-hub/shellgame-cli/server/domain/model"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testReadWait   = 100 * time.Millisecond
+	testPingPeriod = 30 * time.Millisecond
+)
+
+// サーバ側のWebsocketConnと、それに接続したクライアントを返す。
+// pongがfalseの場合、クライアントはPingに応答しない。
+func connectTestConn(t *testing.T, pong bool) (*WebsocketConn, *websocket.Conn) {
+	t.Helper()
+	conns := make(chan *WebsocketConn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, req, nil)
+		if err != nil {
+			t.Errorf("Upgrade: %v", err)
+			return
+		}
+		conns <- newWebsocketConn(conn, testReadWait, testPingPeriod)
+	}))
+	t.Cleanup(server.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	if !pong {
+		client.SetPingHandler(func(string) error { return nil })
+	}
+	// Pingの受信と応答は読み込み中に行われるため、読み込み続ける。
+	go func() {
+		for {
+			if _, _, err := client.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	wc := <-conns
+	t.Cleanup(func() { wc.Close() })
+	return wc, client
+}
+
+func readAsync(wc *WebsocketConn) (<-chan *common.MatchingMessage, <-chan error) {
+	msgs := make(chan *common.MatchingMessage, 1)
+	errs := make(chan error, 1)
+	go func() {
+		msg := &common.MatchingMessage{}
+		if err := wc.Read(msg); err != nil {
+			errs <- err
+			return
+		}
+		msgs <- msg
+	}()
+	return msgs, errs
+}
+
+func TestWebsocketConnHeartbeat(t *testing.T) {
+	t.Run("Pingに応答するクライアントとの接続は、メッセージがなくても維持される。", func(t *testing.T) {
+		wc, client := connectTestConn(t, true)
+		msgs, errs := readAsync(wc)
+		select {
+		case err := <-errs:
+			t.Fatalf("Expected: no error\n\t\t Actual: %v \n", err)
+		case <-time.After(3 * testReadWait):
+		}
+		env, _ := common.Wrap(&common.MatchingMessage{Data: common.CHAT, Text: "hi"})
+		if err := client.WriteJSON(env); err != nil {
+			t.Fatalf("WriteJSON: %v", err)
+		}
+		select {
+		case msg := <-msgs:
+			if msg.Text != "hi" {
+				t.Errorf("Expected: hi\n\t\t Actual: %s \n", msg.Text)
+			}
+		case err := <-errs:
+			t.Errorf("Expected: hi\n\t\t Actual: %v \n", err)
+		case <-time.After(time.Second):
+			t.Errorf("timed out waiting for message")
+		}
+	})
+	t.Run("Pingに応答しないクライアントの読み込みはErrHeartbeatTimeoutで打ち切られる。", func(t *testing.T) {
+		wc, _ := connectTestConn(t, false)
+		_, errs := readAsync(wc)
+		select {
+		case err := <-errs:
+			if !errors.Is(err, model.ErrHeartbeatTimeout) {
+				t.Errorf("Expected: %v\n\t\t Actual: %v \n", model.ErrHeartbeatTimeout, err)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("Expected: %v\n\t\t Actual: no error \n", model.ErrHeartbeatTimeout)
+		}
+	})
+}
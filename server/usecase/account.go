@@ -0,0 +1,135 @@
+package usecase
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/taise-hub/shellgame-cli/common"
+	"github.com/taise-hub/shellgame-cli/server/domain/model"
+	"github.com/taise-hub/shellgame-cli/server/domain/repository"
+)
+
+type AccountInteractor struct {
+	accountRepo   repository.AccountRepository
+	challengeRepo repository.ChallengeRepository
+	profileRepo   repository.ProfileRepository
+}
+
+func NewAccountInteractor(accountRepo repository.AccountRepository, challengeRepo repository.ChallengeRepository, profileRepo repository.ProfileRepository) *AccountInteractor {
+	return &AccountInteractor{
+		accountRepo:   accountRepo,
+		challengeRepo: challengeRepo,
+		profileRepo:   profileRepo,
+	}
+}
+
+// パスワードとSSH公開鍵の少なくとも一方を指定してアカウントを登録し、そのままログインする。
+// ゲストのプロフィールと同じ名前空間でプレイヤー名を確保する。
+func (ai *AccountInteractor) Register(name string, password string, publicKey string) (*common.Profile, error) {
+	if err := model.ValidateName(name); err != nil {
+		return nil, err
+	}
+	if password == "" && publicKey == "" {
+		return nil, model.ErrNoCredential
+	}
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return nil, err
+	}
+	account := &repository.Account{ID: id.String(), Name: name, Rating: model.DEFAULT_RATING}
+	if password != "" {
+		if account.PasswordHash, err = model.HashPassword(password); err != nil {
+			return nil, err
+		}
+	}
+	if publicKey != "" {
+		key, err := model.NormalizePublicKey(publicKey)
+		if err != nil {
+			return nil, err
+		}
+		account.PublicKeys = append(account.PublicKeys, key)
+	}
+	profile := &common.Profile{ID: account.ID, Name: account.Name, Rating: account.Rating}
+	if err := ai.profileRepo.Create(profile); err != nil {
+		return nil, err
+	}
+	if err := ai.accountRepo.Create(account); err != nil {
+		ai.profileRepo.Delete(profile.ID)
+		return nil, err
+	}
+	return profile, nil
+}
+
+func (ai *AccountInteractor) LoginWithPassword(name string, password string) (*common.Profile, error) {
+	account, err := ai.findAccount(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := model.VerifyPassword(account.PasswordHash, password); err != nil {
+		return nil, err
+	}
+	return ai.login(account)
+}
+
+// SSH公開鍵認証で署名させるチャレンジを発行する。
+// アカウントの有無を推測されないように、存在しないプレイヤー名に対しても発行する。
+// 誰でも発行できるため、同じプレイヤー名に発行しても以前のチャレンジは無効にならない。
+func (ai *AccountInteractor) IssueChallenge(name string) (string, error) {
+	challenge := make([]byte, 32)
+	if _, err := rand.Read(challenge); err != nil {
+		return "", err
+	}
+	encoded := base64.StdEncoding.EncodeToString(challenge)
+	if err := ai.challengeRepo.Save(encoded, name); err != nil {
+		return "", err
+	}
+	return encoded, nil
+}
+
+// signatureはIssueChallengeでnameに発行したチャレンジ(encoded)に対する署名
+func (ai *AccountInteractor) LoginWithSignature(name string, encoded string, signature []byte) (*common.Profile, error) {
+	owner, err := ai.challengeRepo.Take(encoded)
+	if errors.Is(err, repository.ErrChallengeNotFound) {
+		return nil, model.ErrAuthenticationFailed
+	} else if err != nil {
+		return nil, err
+	} else if owner != name {
+		return nil, model.ErrAuthenticationFailed
+	}
+	challenge, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	account, err := ai.findAccount(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := model.VerifySignature(account.PublicKeys, challenge, signature); err != nil {
+		return nil, err
+	}
+	return ai.login(account)
+}
+
+func (ai *AccountInteractor) findAccount(name string) (*repository.Account, error) {
+	account, err := ai.accountRepo.FindByName(name)
+	if errors.Is(err, repository.ErrAccountNotFound) {
+		return nil, model.ErrAuthenticationFailed
+	}
+	return account, err
+}
+
+// 別のセッションで既にログインしている場合は同じプロフィールを返す。
+func (ai *AccountInteractor) login(account *repository.Account) (*common.Profile, error) {
+	profile, err := ai.profileRepo.Find(account.ID)
+	if err == nil {
+		return profile, nil
+	} else if !errors.Is(err, repository.ErrProfileNotFound) {
+		return nil, err
+	}
+	profile = &common.Profile{ID: account.ID, Name: account.Name, Rating: account.Rating}
+	if err := ai.profileRepo.Create(profile); err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
@@ -2,29 +2,98 @@ package usecase
 
 import (
 	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
 	"github.com/taise-hub/shellgame-cli/common"
 	"github.com/taise-hub/shellgame-cli/server/domain/model"
 	"github.com/taise-hub/shellgame-cli/server/domain/repository"
 	"io"
 	"log"
+	"math/big"
 	"net"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
+const (
+	DEFAULT_ROOM        = "beginner"
+	PRIVATE_ROOM_PREFIX = "private:" // プライベートルームのマッチングルーム名の接頭辞。招待コードを続ける。
+	INVITE_CODE_LENGTH  = 6
+	INVITE_CODE_LETTERS = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789" // 読み間違えやすい文字(I, O, 0, 1)を除く。
+	INVITE_CODE_RETRIES = 5                                  // 招待コードが重複した場合に作り直す回数
+	VACANT_ROOM_TIMEOUT = 5 * time.Minute                    // 誰もいなくなったプライベートルームを閉じるまでの時間
+)
+
+var (
+	ErrUnknownQuestionSet = errors.New("問題の組が見つかりません。")
+)
+
 type GameInteractor struct {
-	consoleRepo  repository.ConsoleRepository
+	consoleRepo      repository.ConsoleRepository
+	matchingRoomRepo repository.MatchingRoomRepository
+	matchingEventBus repository.MatchingEventBus
+	privateRoomRepo  repository.PrivateRoomRepository
+	rooms            map[string]*model.MatchingRoom // プライベートルームは参加者が現れたときに開く。
+	cancels          map[string]context.CancelFunc  // 稼働中のマッチングルームを止める。ルーム名から引く。
+	privateVacancy   time.Duration                  // 誰もいなくなったプライベートルームを閉じるまでの時間
+	ratingWindow     model.RatingWindow             // ランダム対戦で組み合わせを許容するレーティング差
+	idlePolicy       model.IdlePolicy               // ロビーで操作のないプレイヤーの扱い
+	roomCapacity     int                            // ルームに参加できるプレイヤー数の上限
+	mu               sync.RWMutex
 }
 
-func NewGameInteractor(consoleRepo repository.ConsoleRepository) *GameInteractor {
+func NewGameInteractor(consoleRepo repository.ConsoleRepository, matchingRoomRepo repository.MatchingRoomRepository, matchingEventBus repository.MatchingEventBus, privateRoomRepo repository.PrivateRoomRepository) *GameInteractor {
 	return &GameInteractor{
-		consoleRepo:  consoleRepo,
+		consoleRepo:      consoleRepo,
+		matchingRoomRepo: matchingRoomRepo,
+		matchingEventBus: matchingEventBus,
+		privateRoomRepo:  privateRoomRepo,
+		rooms:            make(map[string]*model.MatchingRoom),
+		cancels:          make(map[string]context.CancelFunc),
+		privateVacancy:   VACANT_ROOM_TIMEOUT,
+		ratingWindow:     model.DEFAULT_RATING_WINDOW,
+		idlePolicy:       model.DEFAULT_IDLE_POLICY,
+		roomCapacity:     model.MAX_ROOM_PLAYERS,
 	}
 }
 
+// 以降に作成するマッチングルームに適用される。
+func (gi *GameInteractor) SetRatingWindow(w model.RatingWindow) {
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+	gi.ratingWindow = w
+}
+
+// 以降に作成するマッチングルームに適用される。
+func (gi *GameInteractor) SetIdlePolicy(p model.IdlePolicy) {
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+	gi.idlePolicy = p
+}
+
+// 以降に作成するマッチングルームに適用される。
+func (gi *GameInteractor) SetRoomCapacity(n int) {
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+	gi.roomCapacity = n
+}
+
 // ゲーム開始時に利用する。
 // クラアインとから受け取ったコネクションをコンソールの入出力先である別のコネクションに接続する。
-func (gi *GameInteractor) Start(nconn net.Conn) (err error) {
-	cconn, err := gi.consoleRepo.StartShell()
+// チームメイトでコンテナを共有する対戦では、チームごとに同じコンテナに接続する。
+// 対戦の参加者は切断されて接続し直しても同じコンテナで再開できるように、プレイヤーごとのコンテナに接続する。
+// 対戦のコンテナはマッチングルームが対戦を破棄するときに削除する。
+func (gi *GameInteractor) Start(nconn net.Conn, battleID string, playerID string) (err error) {
+	var cconn net.Conn
+	battle, _ := gi.GetBattle(battleID)
+	if battle != nil && battle.ConsoleName(playerID) != "" {
+		cconn, err = gi.consoleRepo.JoinShell(battle.ConsoleName(playerID))
+	} else {
+		cconn, err = gi.consoleRepo.StartShell()
+	}
 	if err != nil {
 		log.Printf("Error in StartShell(): %v\n", err)
 		return err
@@ -36,27 +105,217 @@ func (gi *GameInteractor) Start(nconn net.Conn) (err error) {
 	return
 }
 
-func (gi *GameInteractor) ExtractMatchingProfiles(exceptID string) []*common.Profile {
-	mroom := model.GetMatchingRoom()
-	players := mroom.GetMatchingPlayers()
-	var profiles []*common.Profile
-	for _, v := range players {
-		if v.Profile.ID == exceptID {
+// nameという名前のマッチングルームを作成し、稼働させる。
+func (gi *GameInteractor) OpenRoom(name string) error {
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+	if _, ok := gi.rooms[name]; ok {
+		return fmt.Errorf("room %s already exists", name)
+	}
+	mroom := model.NewMatchingRoom(name, gi.matchingEventBus)
+	gi.runRoom(mroom)
+	return nil
+}
+
+// gi.muをロックして呼び出す。
+func (gi *GameInteractor) runRoom(mroom *model.MatchingRoom) {
+	mroom.SetRatingWindow(gi.ratingWindow)
+	mroom.SetIdlePolicy(gi.idlePolicy)
+	mroom.SetCapacity(gi.roomCapacity)
+	mroom.SetMembers(gi.matchingRoomRepo)
+	mroom.SetConsoles(gi.consoleRepo)
+	ctx, cancel := context.WithCancel(context.Background())
+	gi.rooms[mroom.Name] = mroom
+	gi.cancels[mroom.Name] = cancel
+	go func() {
+		defer gi.closeRoom(mroom)
+		if err := mroom.Run(ctx); err != nil {
+			log.Printf("Error in MatchingRoom.Run(): %s: %v\n", mroom.Name, err)
+		}
+	}()
+}
+
+// 終了したマッチングルームを止めて、一覧から取り除く。既に開き直したルームは取り除かない。
+func (gi *GameInteractor) closeRoom(mroom *model.MatchingRoom) {
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+	if gi.rooms[mroom.Name] != mroom {
+		return
+	}
+	gi.cancels[mroom.Name]()
+	delete(gi.rooms, mroom.Name)
+	delete(gi.cancels, mroom.Name)
+}
+
+// hostIDのプレイヤーをホストとするプライベートルームを作成し、招待コードを発行する。
+// ルームは全てのサーバで、招待コードを使って参加するプレイヤーが現れたときに開く。
+func (gi *GameInteractor) CreatePrivateRoom(hostID string, questionSet string) (*common.PrivateRoom, error) {
+	if questionSet == "" {
+		questionSet = model.DEFAULT_QUESTION_SET
+	} else if !model.IsQuestionSet(questionSet) {
+		return nil, ErrUnknownQuestionSet
+	}
+	for i := 0; i < INVITE_CODE_RETRIES; i++ {
+		code, err := newInviteCode()
+		if err != nil {
+			return nil, err
+		}
+		err = gi.privateRoomRepo.Create(&repository.PrivateRoom{Code: code, Host: hostID, QuestionSet: questionSet})
+		if err == repository.ErrCodeTaken {
 			continue
+		} else if err != nil {
+			return nil, err
+		}
+		return &common.PrivateRoom{Code: code, QuestionSet: questionSet}, nil
+	}
+	return nil, repository.ErrCodeTaken
+}
+
+func newInviteCode() (string, error) {
+	code := make([]byte, INVITE_CODE_LENGTH)
+	max := big.NewInt(int64(len(INVITE_CODE_LETTERS)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
 		}
-		profiles = append(profiles, v.Profile)
+		code[i] = INVITE_CODE_LETTERS[n.Int64()]
 	}
-	return profiles
+	return string(code), nil
+}
+
+// 招待コードに対応するプライベートルームのマッチングルーム名
+func PrivateRoomName(code string) string {
+	return PRIVATE_ROOM_PREFIX + strings.ToUpper(code)
 }
 
-// playerをマッチング待ち状態にする。
-func (gi *GameInteractor) WaitMatch(player *model.MatchingPlayer) {
-	mroom := model.GetMatchingRoom()
-	mroom.GetRegisterChan() <- player
-	go player.ReadPump()
+func (gi *GameInteractor) getRoom(name string) (*model.MatchingRoom, error) {
+	gi.mu.RLock()
+	mroom, ok := gi.rooms[name]
+	gi.mu.RUnlock()
+	if ok {
+		return mroom, nil
+	}
+	if strings.HasPrefix(name, PRIVATE_ROOM_PREFIX) {
+		return gi.openPrivateRoom(strings.TrimPrefix(name, PRIVATE_ROOM_PREFIX))
+	}
+	return nil, fmt.Errorf("room %s is not found", name)
+}
+
+// このサーバでまだ開いていないプライベートルームを開く。
+// 誰もいない状態が続いたプライベートルームは閉じ、再び参加者が現れたときに開き直す。
+func (gi *GameInteractor) openPrivateRoom(code string) (*model.MatchingRoom, error) {
+	room, err := gi.privateRoomRepo.Find(code)
+	if err != nil {
+		return nil, err
+	}
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+	name := PrivateRoomName(room.Code)
+	if mroom, ok := gi.rooms[name]; ok {
+		return mroom, nil
+	}
+	mroom := model.NewMatchingRoom(name, gi.matchingEventBus)
+	mroom.SetHost(room.Host)
+	mroom.SetQuestionSet(room.QuestionSet)
+	mroom.SetVacancyTimeout(gi.privateVacancy)
+	gi.runRoom(mroom)
+	return mroom, nil
+}
+
+// 稼働中のマッチングルームで開始した対戦をIDで探す。
+func (gi *GameInteractor) GetBattle(id string) (*model.Battle, error) {
+	battle, _, err := gi.findBattle(id)
+	return battle, err
+}
+
+// 対戦と、対戦を開始したマッチングルームを返す。
+func (gi *GameInteractor) findBattle(id string) (*model.Battle, *model.MatchingRoom, error) {
+	gi.mu.RLock()
+	defer gi.mu.RUnlock()
+	for _, mroom := range gi.rooms {
+		if battle, ok := mroom.GetBattle(id); ok {
+			return battle, mroom, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("battle %s is not found", id)
+}
+
+// playerをbattleIDの対戦チャンネルに接続し、対戦相手とチャットやエモートをやり取りできるようにする。
+func (gi *GameInteractor) JoinBattle(battleID string, player *model.MatchingPlayer) error {
+	battle, mroom, err := gi.findBattle(battleID)
+	if err != nil {
+		return err
+	} else if battle.TeamOf(player.GetID()) < 0 {
+		return fmt.Errorf("player %s is not in the battle %s", player.GetID(), battleID)
+	}
+	player.SetBattleID(battleID)
+	if err := mroom.Register(player); err != nil {
+		return err
+	}
+	go player.ReadPump(mroom)
 	go func() {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		ctx, cancel := context.WithTimeout(context.Background(), model.BATTLE_LIFETIME)
 		player.WritePump(ctx)
 		cancel()
 	}()
+	return nil
+}
+
+func (gi *GameInteractor) HasRoom(name string) bool {
+	_, err := gi.getRoom(name)
+	return err == nil
+}
+
+// 稼働中のマッチングルームの一覧を名前順に返す。プライベートルームは含めない。
+func (gi *GameInteractor) GetRooms() []*common.Room {
+	gi.mu.RLock()
+	defer gi.mu.RUnlock()
+	rooms := []*common.Room{}
+	for _, v := range gi.rooms {
+		if v.IsPrivate() {
+			continue
+		}
+		rooms = append(rooms, &common.Room{Name: v.Name, Players: len(v.GetMatchingPlayers())})
+	}
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].Name < rooms[j].Name })
+	return rooms
+}
+
+// roomにいるexceptID以外のプレイヤーの状態を参加した順に返す。
+func (gi *GameInteractor) ExtractMatchingPlayers(room string, exceptID string) ([]*common.MatchingPlayer, error) {
+	mroom, err := gi.getRoom(room)
+	if err != nil {
+		return nil, err
+	}
+	players := []*common.MatchingPlayer{}
+	for _, v := range mroom.GetLobby() {
+		if v.Profile.ID == exceptID {
+			continue
+		}
+		players = append(players, v)
+	}
+	return players, nil
+}
+
+// playerをroomでマッチング待ち状態にする。
+// 操作のないプレイヤーはマッチングルームがIdlePolicyに従って切断する。
+// ルームへの参加の記録はマッチングルームが参加・退室のイベントを処理する順に行う。
+func (gi *GameInteractor) WaitMatch(room string, player *model.MatchingPlayer) error {
+	mroom, err := gi.getRoom(room)
+	if err != nil {
+		return err
+	}
+	// 誰もいなくなって閉じたばかりのプライベートルームは開き直す。
+	if err := mroom.Register(player); err == model.ErrRoomClosed {
+		gi.closeRoom(mroom)
+		if mroom, err = gi.getRoom(room); err != nil {
+			return err
+		} else if err = mroom.Register(player); err != nil {
+			return err
+		}
+	}
+	go player.ReadPump(mroom)
+	go player.WritePump(context.Background())
+	return nil
 }
@@ -0,0 +1,118 @@
+package usecase
+
+import (
+	"context"
+	"github.com/taise-hub/shellgame-cli/common"
+	"github.com/taise-hub/shellgame-cli/server/domain/repository"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBusはテスト用のMatchingEventBusの実装
+// 購読中のチャネルの数を数え、ctxが終了すると購読をやめる。
+type fakeBus struct {
+	mu          sync.Mutex
+	subscribers map[chan *common.MatchingMessage]string
+}
+
+func newFakeBus() *fakeBus {
+	return &fakeBus{subscribers: make(map[chan *common.MatchingMessage]string)}
+}
+
+func (b *fakeBus) Publish(room string, msg *common.MatchingMessage) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch, r := range b.subscribers {
+		if r == room {
+			ch <- msg
+		}
+	}
+	return nil
+}
+
+func (b *fakeBus) Subscribe(ctx context.Context, room string) (<-chan *common.MatchingMessage, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch := make(chan *common.MatchingMessage, 64)
+	b.subscribers[ch] = room
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers, ch)
+	}()
+	return ch, nil
+}
+
+func (b *fakeBus) count() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subscribers)
+}
+
+// fakePrivateRoomsはテスト用のPrivateRoomRepositoryの実装
+type fakePrivateRooms struct {
+	mu    sync.Mutex
+	rooms map[string]*repository.PrivateRoom
+}
+
+func (r *fakePrivateRooms) Create(room *repository.PrivateRoom) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.rooms[room.Code]; ok {
+		return repository.ErrCodeTaken
+	}
+	r.rooms[room.Code] = room
+	return nil
+}
+
+func (r *fakePrivateRooms) Find(code string) (*repository.PrivateRoom, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	room, ok := r.rooms[code]
+	if !ok {
+		return nil, repository.ErrPrivateRoomNotFound
+	}
+	return room, nil
+}
+
+func (gi *GameInteractor) isOpen(name string) bool {
+	gi.mu.RLock()
+	defer gi.mu.RUnlock()
+	_, room := gi.rooms[name]
+	_, cancel := gi.cancels[name]
+	return room || cancel
+}
+
+func TestGameInteractorClosesVacantPrivateRoom(t *testing.T) {
+	bus := newFakeBus()
+	gi := NewGameInteractor(nil, nil, bus, &fakePrivateRooms{rooms: make(map[string]*repository.PrivateRoom)})
+	gi.privateVacancy = time.Nanosecond
+	room, err := gi.CreatePrivateRoom("0", "")
+	if err != nil {
+		t.Fatalf("CreatePrivateRoom(): %v", err)
+	}
+	name := PrivateRoomName(room.Code)
+
+	t.Run("招待コードで参照するとプライベートルームを開く。", func(t *testing.T) {
+		if !gi.HasRoom(name) || !gi.isOpen(name) {
+			t.Errorf("Expected: %v\n\t\t Actual: %v \n", true, false)
+		}
+	})
+	t.Run("誰もいないプライベートルームは閉じ、購読をやめる。", func(t *testing.T) {
+		timeout := time.After(3 * time.Second)
+		for gi.isOpen(name) || bus.count() > 0 {
+			select {
+			case <-timeout:
+				t.Fatalf("Expected: closed\n\t\t Actual: open=%v subscriptions=%d \n", gi.isOpen(name), bus.count())
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	})
+	t.Run("閉じたプライベートルームは招待コードで開き直せる。", func(t *testing.T) {
+		if !gi.HasRoom(name) || !gi.isOpen(name) {
+			t.Errorf("Expected: %v\n\t\t Actual: %v \n", true, false)
+		}
+	})
+}
@@ -0,0 +1,99 @@
+package usecase
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"github.com/taise-hub/shellgame-cli/common"
+	"github.com/taise-hub/shellgame-cli/server/domain/model"
+	"github.com/taise-hub/shellgame-cli/server/domain/repository"
+)
+
+var (
+	ErrAccountRename = errors.New("アカウントのプレイヤー名は変更できません。")
+)
+
+type ProfileInteractor struct {
+	profileRepo repository.ProfileRepository
+	accountRepo repository.AccountRepository
+}
+
+func NewProfileInteractor(profileRepo repository.ProfileRepository, accountRepo repository.AccountRepository) *ProfileInteractor {
+	return &ProfileInteractor{
+		profileRepo: profileRepo,
+		accountRepo: accountRepo,
+	}
+}
+
+// プレイヤー名を検証し、サーバで発行したIDでゲストのプロフィールを登録する。
+// アカウントに登録されているプレイヤー名は、そのアカウントがログインしていなくても利用できない。
+func (pi *ProfileInteractor) Create(name string) (*common.Profile, error) {
+	if err := model.ValidateName(name); err != nil {
+		return nil, err
+	}
+	if err := pi.checkAccountName(name); err != nil {
+		return nil, err
+	}
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return nil, err
+	}
+	profile := &common.Profile{ID: id.String(), Name: name, Rating: model.DEFAULT_RATING}
+	if err := pi.profileRepo.Create(profile); err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+func (pi *ProfileInteractor) Find(id string) (*common.Profile, error) {
+	return pi.profileRepo.Find(id)
+}
+
+// アカウントのプロフィールは次回のログインでアカウントのプレイヤー名に戻ってしまうため、変更できない。
+func (pi *ProfileInteractor) Rename(id string, name string) (*common.Profile, error) {
+	if err := model.ValidateName(name); err != nil {
+		return nil, err
+	}
+	profile, err := pi.profileRepo.Find(id)
+	if err != nil {
+		return nil, err
+	}
+	if profile.Name == name {
+		return profile, nil
+	}
+	if account, err := pi.accountRepo.FindByName(profile.Name); err == nil && account.ID == id {
+		return nil, ErrAccountRename
+	} else if err != nil && !errors.Is(err, repository.ErrAccountNotFound) {
+		return nil, err
+	}
+	if err := pi.checkAccountName(name); err != nil {
+		return nil, err
+	}
+	return pi.profileRepo.Rename(id, name)
+}
+
+// セッションで利用していたプロフィールを別のプロフィールに切り替えるときやログアウトするときに、ゲストのプロフィールであれば削除する。
+// アカウントのプロフィールは同じアカウントでログインしている他のセッションでも利用しているため削除しない。
+func (pi *ProfileInteractor) DeleteGuest(id string) error {
+	profile, err := pi.profileRepo.Find(id)
+	if errors.Is(err, repository.ErrProfileNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if account, err := pi.accountRepo.FindByName(profile.Name); err == nil && account.ID == id {
+		return nil
+	} else if err != nil && !errors.Is(err, repository.ErrAccountNotFound) {
+		return err
+	}
+	return pi.profileRepo.Delete(id)
+}
+
+func (pi *ProfileInteractor) checkAccountName(name string) error {
+	_, err := pi.accountRepo.FindByName(name)
+	if err == nil {
+		return repository.ErrNameTaken
+	} else if !errors.Is(err, repository.ErrAccountNotFound) {
+		return err
+	}
+	return nil
+}